@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/central/common"
+)
+
+func main() {
+	output := flag.String("o", "", "CSV file to write, stdout if empty")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [bet log]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	src := "./bets-1.log"
+	if flag.NArg() > 0 {
+		src = flag.Arg(0)
+	}
+
+	if err := export(src, *output); err != nil {
+		fmt.Fprintf(os.Stderr, "action: exportar_apuestas | result: fail | source: %s | error: %v\n", src, err)
+		os.Exit(1)
+	}
+}
+
+// export Writes the bets of the log at src to output in the bets.csv format.
+// The log is opened read-only, so it can be exported while the central writes
+// to it: a record still being written is left out, never truncated
+func export(src string, output string) error {
+	store, err := common.OpenLogStore(src, common.LogOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	var out io.Writer = os.Stdout
+	if output != "" {
+		file, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	return common.ExportCSV(store, out)
+}
@@ -0,0 +1,294 @@
+package common
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// isLoopback Returns whether the host of addr is a loopback address
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// listenAdmin Opens the listener of the admin API, which is only reachable
+// from the host of the central
+func listenAdmin(addr string) (net.Listener, error) {
+	if !isLoopback(addr) {
+		return nil, errors.Errorf("admin API must listen on a loopback address, not %q", addr)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// AgencyInfo Entry of the agencies listing of the admin API
+type AgencyInfo struct {
+	AgencyStatus
+	Bets int `json:"bets"`
+	// Connected Whether the agency has an open connection on which it sent
+	// requests for the contest
+	Connected bool `json:"connected"`
+}
+
+// WinnersInfo Winners of an agency as listed by the admin API
+type WinnersInfo struct {
+	Contest       string   `json:"contest"`
+	Agency        uint8    `json:"agency"`
+	WinningNumber uint16   `json:"winning_number"`
+	Documents     []uint64 `json:"documents"`
+	// Tiers Prize tier of every document, see protocol.Winners
+	Tiers []uint8 `json:"tiers"`
+	// Pool Prize pool of the contest and Payouts the cents paid to every
+	// document
+	Pool    uint64   `json:"pool"`
+	Payouts []uint64 `json:"payouts"`
+}
+
+// adminError Body of every failed admin request
+type adminError struct {
+	Error string `json:"error"`
+}
+
+// adminHandler Serves the admin API of the central:
+//
+//	GET  /contests             phase of every open contest
+//	POST /contests?contest=ID  opens a new contest (needs the token)
+//	GET  /agencies             agencies with bets, finished and connected state
+//	GET  /contest              phase of the contest
+//	GET  /winners[?agency=N]   winners per agency, once the draw happened
+//	POST /close                stops accepting bets (needs the token)
+//	POST /draw                 makes the draw now (needs the token)
+//
+// Every path but /contests refers to the contest given with ?contest=ID, the
+// default one if not given. Operations that change the contest need the
+// AdminToken as a bearer token and are disabled if no token is configured
+func (s *Server) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	listContests := s.adminGet(s.adminContests)
+	mux.HandleFunc("/contests", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			s.adminOpenContest(w, r)
+			return
+		}
+		listContests(w, r)
+	})
+	mux.HandleFunc("/agencies", s.adminGet(s.adminAgencies))
+	mux.HandleFunc("/contest", s.adminGet(func(r *http.Request) (interface{}, int, error) {
+		c, err := s.adminContest(r)
+		if err != nil {
+			return nil, http.StatusNotFound, err
+		}
+		return c.lottery.status(), http.StatusOK, nil
+	}))
+	mux.HandleFunc("/winners", s.adminGet(s.adminWinners))
+	mux.HandleFunc("/close", s.adminPost("cerrar_concurso", (*lottery).forceClose))
+	mux.HandleFunc("/draw", s.adminPost("sortear", (*lottery).forceDraw))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The listener is bound to a loopback address, this only guards
+		// against misconfigured proxies
+		if !isLoopback(r.RemoteAddr) {
+			writeJSON(w, http.StatusForbidden, adminError{Error: "admin API is only available from localhost"})
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// adminGet Serves a read-only operation
+func (s *Server) adminGet(fn func(r *http.Request) (interface{}, int, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			writeJSON(w, http.StatusMethodNotAllowed, adminError{Error: "method not allowed"})
+			return
+		}
+		body, status, err := fn(r)
+		if err != nil {
+			writeJSON(w, status, adminError{Error: err.Error()})
+			return
+		}
+		writeJSON(w, status, body)
+	}
+}
+
+// adminPost Serves an operation that changes the contest, after checking the
+// token. Responds with the status of the contest
+func (s *Server) adminPost(action string, fn func(l *lottery) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.adminAllowed(w, r, action) {
+			return
+		}
+
+		c, err := s.adminContest(r)
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, adminError{Error: err.Error()})
+			return
+		}
+		if err := fn(c.lottery); err != nil {
+			status := http.StatusInternalServerError
+			if err == errAlreadyClosed || err == errAlreadyDrawn || err == errNoAgencyForDraw {
+				status = http.StatusConflict
+			}
+			log.Errorf("action: %v | result: fail | origen: admin | concurso: %v | error: %v", action, c.id, err)
+			writeJSON(w, status, adminError{Error: err.Error()})
+			return
+		}
+		log.Infof("action: %v | result: success | origen: admin | concurso: %v", action, c.id)
+		writeJSON(w, http.StatusOK, c.lottery.status())
+	}
+}
+
+// adminAllowed Checks that the request is a POST with the admin token. If not
+// the error is written and false returned
+func (s *Server) adminAllowed(w http.ResponseWriter, r *http.Request, action string) bool {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, adminError{Error: "method not allowed"})
+		return false
+	}
+	if s.config.AdminToken == "" {
+		writeJSON(w, http.StatusForbidden, adminError{Error: "no admin token configured"})
+		return false
+	}
+	if !s.authorized(r) {
+		log.Warningf("action: %v | result: fail | origen: admin | error: invalid token", action)
+		writeJSON(w, http.StatusUnauthorized, adminError{Error: "invalid token"})
+		return false
+	}
+	return true
+}
+
+// adminOpenContest Opens the contest of the query so agencies can take part
+// in it. Responds with its status, 201 if it was opened now and 200 if it
+// was already open
+func (s *Server) adminOpenContest(w http.ResponseWriter, r *http.Request) {
+	if !s.adminAllowed(w, r, "abrir_concurso") {
+		return
+	}
+	id := r.URL.Query().Get("contest")
+	c, opened, err := s.startContest(id)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == ErrInvalidContest {
+			status = http.StatusBadRequest
+		}
+		log.Errorf("action: abrir_concurso | result: fail | origen: admin | concurso: %q | error: %v", id, err)
+		writeJSON(w, status, adminError{Error: err.Error()})
+		return
+	}
+	status := http.StatusOK
+	if opened {
+		status = http.StatusCreated
+		log.Infof("action: abrir_concurso | result: success | origen: admin | concurso: %v", c.id)
+	}
+	writeJSON(w, status, c.lottery.status())
+}
+
+// authorized Checks the bearer token of the request in constant time. The
+// header must carry the Bearer scheme
+func (s *Server) authorized(r *http.Request) bool {
+	const scheme = "Bearer "
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, scheme) {
+		return false
+	}
+	token := strings.TrimPrefix(header, scheme)
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.config.AdminToken)) == 1
+}
+
+// adminContest Returns the contest a request refers to. Contests are not
+// opened by the admin API
+func (s *Server) adminContest(r *http.Request) (*contest, error) {
+	id := r.URL.Query().Get("contest")
+	c, ok := s.lookupContest(id)
+	if !ok {
+		return nil, errors.Errorf("unknown contest %q", id)
+	}
+	return c, nil
+}
+
+func (s *Server) adminContests(r *http.Request) (interface{}, int, error) {
+	contests := s.contestList()
+	statuses := make([]ContestStatus, len(contests))
+	for i, c := range contests {
+		statuses[i] = c.lottery.status()
+	}
+	return statuses, http.StatusOK, nil
+}
+
+func (s *Server) adminAgencies(r *http.Request) (interface{}, int, error) {
+	c, err := s.adminContest(r)
+	if err != nil {
+		return nil, http.StatusNotFound, err
+	}
+	statuses := c.lottery.agencies()
+	agencies := make([]AgencyInfo, len(statuses))
+	for i, status := range statuses {
+		bets, err := c.store.Count(status.ID)
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		agencies[i] = AgencyInfo{AgencyStatus: status, Bets: bets, Connected: s.isConnected(c.id, status.ID)}
+	}
+	return agencies, http.StatusOK, nil
+}
+
+// adminWinners Lists the winners of the agency in the query, or of every
+// agency that took part in the draw
+func (s *Server) adminWinners(r *http.Request) (interface{}, int, error) {
+	c, err := s.adminContest(r)
+	if err != nil {
+		return nil, http.StatusNotFound, err
+	}
+	var agencies []uint8
+	if param := r.URL.Query().Get("agency"); param != "" {
+		agency, err := strconv.ParseUint(param, 10, 8)
+		if err != nil {
+			return nil, http.StatusBadRequest, errors.Errorf("invalid agency %q", param)
+		}
+		agencies = append(agencies, uint8(agency))
+	} else {
+		for _, status := range c.lottery.agencies() {
+			if status.Finished {
+				agencies = append(agencies, status.ID)
+			}
+		}
+	}
+
+	list := []WinnersInfo{}
+	for _, agency := range agencies {
+		winners, drawn, err := c.lottery.winnersOf(agency)
+		switch {
+		case err == ErrUnknownAgency:
+			return nil, http.StatusNotFound, err
+		case err == ErrContestClosed:
+			return nil, http.StatusNotFound, errors.Errorf("agency %d did not take part in the draw", agency)
+		case err != nil:
+			return nil, http.StatusInternalServerError, err
+		case !drawn:
+			return nil, http.StatusConflict, errors.New("draw not made yet")
+		}
+		list = append(list, WinnersInfo{Contest: c.id, Agency: agency, WinningNumber: winners.WinningNumber, Documents: winners.Documents, Tiers: winners.Tiers, Pool: winners.Pool, Payouts: winners.Payouts})
+	}
+	return list, http.StatusOK, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(body)
+}
@@ -0,0 +1,217 @@
+package common
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+const testToken = "secreto"
+
+// adminRequest Sends a request to the admin API of the server and decodes
+// the response into body, returning its status code
+func adminRequest(t *testing.T, server *Server, method, path, token string, body interface{}) int {
+	t.Helper()
+	request, err := http.NewRequest(method, "http://"+server.AdminAddr()+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if body != nil {
+		if err := json.NewDecoder(response.Body).Decode(body); err != nil {
+			t.Fatalf("%v %v: %v", method, path, err)
+		}
+	}
+	return response.StatusCode
+}
+
+func startAdminServer(t *testing.T, token string) *Server {
+	t.Helper()
+	return startServerWith(t, ServerConfig{Agencies: 3, AdminAddress: "127.0.0.1:0", AdminToken: token})
+}
+
+func TestAdminListsAgencies(t *testing.T) {
+	server := startAdminServer(t, testToken)
+	ackStatus(t, server, sequencedBatch(1, 1, 4))
+	ackStatus(t, server, &protocol.EndOfBets{Agency: 1})
+	ackStatus(t, server, sequencedBatch(2, 1, 2))
+
+	var agencies []AgencyInfo
+	if code := adminRequest(t, server, http.MethodGet, "/agencies", "", &agencies); code != http.StatusOK {
+		t.Fatalf("GET /agencies answered %v", code)
+	}
+	expected := []AgencyInfo{
+		{AgencyStatus: AgencyStatus{ID: 1, Finished: true, Sequence: 1}, Bets: 4},
+		{AgencyStatus: AgencyStatus{ID: 2, Sequence: 1}, Bets: 2},
+		{AgencyStatus: AgencyStatus{ID: 3}},
+	}
+	if len(agencies) != len(expected) {
+		t.Fatalf("got %+v", agencies)
+	}
+	for i := range expected {
+		if agencies[i] != expected[i] {
+			t.Errorf("agency %d: got %+v, expected %+v", i+1, agencies[i], expected[i])
+		}
+	}
+
+	var status ContestStatus
+	adminRequest(t, server, http.MethodGet, "/contest", "", &status)
+	if status.Phase != PhaseOpen || status.Finished != 1 || status.WinningNumber != nil {
+		t.Errorf("got %+v", status)
+	}
+
+	// Agencies cannot open contests, the admin API opens them
+	weekly := sequencedBatch(1, 1, 1)
+	weekly.Contest = "2026-42"
+	if status := ackStatus(t, server, weekly); status != protocol.StatusFail {
+		t.Errorf("bets of a contest not open answered %v", status)
+	}
+	if code := adminRequest(t, server, http.MethodPost, "/contests?contest=2026-42", "", nil); code != http.StatusUnauthorized {
+		t.Errorf("POST /contests without token answered %v", code)
+	}
+	if code := adminRequest(t, server, http.MethodPost, "/contests?contest=2026-42", testToken, &status); code != http.StatusCreated || status.Contest != "2026-42" {
+		t.Errorf("POST /contests answered %v with %+v", code, status)
+	}
+	if code := adminRequest(t, server, http.MethodPost, "/contests?contest=2026-42", testToken, nil); code != http.StatusOK {
+		t.Errorf("POST /contests of an open contest answered %v", code)
+	}
+	if code := adminRequest(t, server, http.MethodPost, "/contests?contest=../1", testToken, nil); code != http.StatusBadRequest {
+		t.Errorf("POST /contests with an invalid id answered %v", code)
+	}
+	ackStatus(t, server, weekly)
+	var contests []ContestStatus
+	adminRequest(t, server, http.MethodGet, "/contests", "", &contests)
+	if len(contests) != 2 || contests[0].Contest != "1" || contests[1].Contest != "2026-42" {
+		t.Errorf("got %+v", contests)
+	}
+	adminRequest(t, server, http.MethodGet, "/agencies?contest=2026-42", "", &agencies)
+	if len(agencies) != 3 || agencies[0].Bets != 1 || agencies[1].Bets != 0 {
+		t.Errorf("agencies of contest 2026-42: %+v", agencies)
+	}
+	if code := adminRequest(t, server, http.MethodGet, "/contest?contest=2026-43", "", nil); code != http.StatusNotFound {
+		t.Errorf("unknown contest answered %v", code)
+	}
+}
+
+func TestAdminShowsAgenciesConnectedToEachContest(t *testing.T) {
+	server := startAdminServer(t, testToken)
+	if code := adminRequest(t, server, http.MethodPost, "/contests?contest=2026-42", testToken, nil); code != http.StatusCreated {
+		t.Fatalf("POST /contests answered %v", code)
+	}
+	conn := dialAgency(t, server)
+	if response := exchange(t, conn, &protocol.ResumeQuery{Agency: 1, Contest: "2026-42"}); response.Type() != protocol.MsgResume {
+		t.Fatalf("resume answered with %v", describeMessage(response))
+	}
+
+	for contest, want := range map[string]bool{"1": false, "2026-42": true} {
+		var agencies []AgencyInfo
+		adminRequest(t, server, http.MethodGet, "/agencies?contest="+contest, "", &agencies)
+		if len(agencies) != 3 || agencies[0].Connected != want || agencies[1].Connected {
+			t.Errorf("agencies of contest %v: %+v", contest, agencies)
+		}
+	}
+}
+
+func TestAdminDrawsWithTheAgenciesThatFinished(t *testing.T) {
+	server := startAdminServer(t, testToken)
+	ackStatus(t, server, sequencedBatch(1, 1, 3))
+	ackStatus(t, server, &protocol.EndOfBets{Agency: 1})
+
+	var failure adminError
+	if code := adminRequest(t, server, http.MethodGet, "/winners?agency=1", "", &failure); code != http.StatusConflict {
+		t.Errorf("winners before the draw answered %v: %+v", code, failure)
+	}
+	if code := adminRequest(t, server, http.MethodPost, "/draw", "", nil); code != http.StatusUnauthorized {
+		t.Errorf("draw without token answered %v", code)
+	}
+	if code := adminRequest(t, server, http.MethodPost, "/draw", "otro", nil); code != http.StatusUnauthorized {
+		t.Errorf("draw with a wrong token answered %v", code)
+	}
+	// The token alone, without the Bearer scheme, is refused
+	request, err := http.NewRequest(http.MethodPost, "http://"+server.AdminAddr()+"/draw", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Authorization", testToken)
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusUnauthorized {
+		t.Errorf("draw with a bare token answered %v", response.StatusCode)
+	}
+
+	var status ContestStatus
+	if code := adminRequest(t, server, http.MethodPost, "/draw", testToken, &status); code != http.StatusOK {
+		t.Fatalf("draw answered %v", code)
+	}
+	waitDraw(t, server)
+	if status.Phase != PhaseDrawn || status.WinningNumber == nil || *status.WinningNumber != testNumber {
+		t.Errorf("got %+v", status)
+	}
+	if code := adminRequest(t, server, http.MethodPost, "/draw", testToken, nil); code != http.StatusConflict {
+		t.Errorf("second draw answered %v", code)
+	}
+
+	var winners []WinnersInfo
+	if code := adminRequest(t, server, http.MethodGet, "/winners", "", &winners); code != http.StatusOK {
+		t.Fatalf("GET /winners answered %v", code)
+	}
+	if len(winners) != 1 || winners[0].Agency != 1 || len(winners[0].Documents) != 3 {
+		t.Errorf("got %+v", winners)
+	}
+	if status := ackStatus(t, server, sequencedBatch(2, 1, 1)); status != protocol.StatusContestClosed {
+		t.Errorf("bets after the draw answered %v", status)
+	}
+}
+
+func TestAdminClosesTheContest(t *testing.T) {
+	server := startAdminServer(t, testToken)
+	if code := adminRequest(t, server, http.MethodGet, "/close", testToken, nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /close answered %v", code)
+	}
+	if code := adminRequest(t, server, http.MethodPost, "/close", testToken, nil); code != http.StatusOK {
+		t.Fatalf("close answered %v", code)
+	}
+	if code := adminRequest(t, server, http.MethodPost, "/close", testToken, nil); code != http.StatusConflict {
+		t.Errorf("second close answered %v", code)
+	}
+	if status := ackStatus(t, server, sequencedBatch(1, 1, 1)); status != protocol.StatusContestClosed {
+		t.Errorf("bets after closing answered %v", status)
+	}
+	if code := adminRequest(t, server, http.MethodPost, "/draw", testToken, nil); code != http.StatusConflict {
+		t.Errorf("draw without finished agencies answered %v", code)
+	}
+}
+
+func TestAdminWithoutTokenIsReadOnly(t *testing.T) {
+	server := startAdminServer(t, "")
+	if code := adminRequest(t, server, http.MethodPost, "/close", "", nil); code != http.StatusForbidden {
+		t.Errorf("close answered %v", code)
+	}
+	if code := adminRequest(t, server, http.MethodGet, "/contest", "", nil); code != http.StatusOK {
+		t.Errorf("GET /contest answered %v", code)
+	}
+}
+
+func TestNewServerRefusesPublicAdminAddress(t *testing.T) {
+	_, err := NewServer(ServerConfig{
+		ListenAddress: "127.0.0.1:0",
+		Agencies:      1,
+		StoragePath:   t.TempDir() + "/bets.log",
+		AdminAddress:  "0.0.0.0:0",
+	})
+	if err == nil {
+		t.Fatal("admin API allowed on every interface")
+	}
+}
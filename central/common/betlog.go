@@ -0,0 +1,566 @@
+package common
+
+import (
+	"bufio"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+const (
+	// logMagic Start of the header of the bet log files
+	logMagic = "TP0BETS"
+	// logVersion Last byte of the header, the version of the record format.
+	// It changes along with the encoding of the records, so a log written by
+	// a build with another format is refused instead of misread. Versions 1
+	// to 6 were written by earlier builds
+	logVersion = "7"
+	// logHeader Header of the bet log files, followed by the records
+	logHeader = logMagic + logVersion
+)
+
+// ErrLogVersion is returned when a bet log was written with a record format
+// this build cannot read
+var ErrLogVersion = errors.New("unsupported bet log version")
+
+// recordHeaderSize Every record starts with its payload length and the
+// CRC32-C of the payload, 4 bytes each
+const recordHeaderSize = 8
+
+// maxRecordBetsSize Room for bets in a record, which must fit in a frame
+const maxRecordBetsSize = protocol.MaxFrameSize - protocol.HeaderSize - 7
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// encodedSize Size of a bet in a BetBatch frame
+func encodedSize(bet protocol.Bet) int {
+	numbers := 2
+	if bet.Type != protocol.BetSingle {
+		// Marker, type and amount of numbers before them
+		numbers = 2 + 1 + 1 + 2*len(bet.Numbers)
+	}
+	return 1 + len(bet.FirstName) + 1 + len(bet.LastName) + 8 + protocol.BirthdateSize + numbers + 4
+}
+
+// SyncPolicy When the bet log is flushed to stable storage
+type SyncPolicy uint8
+
+const (
+	// SyncAlways fsyncs after every record
+	SyncAlways SyncPolicy = iota
+	// SyncBatch fsyncs once per Store call, after all its records
+	SyncBatch
+	// SyncInterval fsyncs in the background every SyncInterval. Bets stored
+	// since the last fsync can be lost on a crash
+	SyncInterval
+)
+
+func (p SyncPolicy) String() string {
+	switch p {
+	case SyncAlways:
+		return "always"
+	case SyncBatch:
+		return "batch"
+	case SyncInterval:
+		return "interval"
+	}
+	return "unknown"
+}
+
+// ParseSyncPolicy Parses the name of a policy as returned by String
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch strings.ToLower(s) {
+	case "always":
+		return SyncAlways, nil
+	case "batch":
+		return SyncBatch, nil
+	case "interval":
+		return SyncInterval, nil
+	}
+	return 0, errors.Errorf("unknown sync policy %q", s)
+}
+
+// LogOptions Configuration of a LogStore
+type LogOptions struct {
+	Sync         SyncPolicy
+	SyncInterval time.Duration
+	// ReadOnly Opens an existing log without ever writing to it, so it can be
+	// read while the central appends to it. A torn tail, maybe a record being
+	// written, is left out instead of truncated
+	ReadOnly bool
+}
+
+// ErrReadOnly is returned when storing or cancelling bets in a log opened
+// read-only
+var ErrReadOnly = errors.New("bet log opened read-only")
+
+// LogStore Append-only bet log. Each record holds bets of a single agency and
+// batch sequence encoded as a protocol.BetBatch frame, or the tombstone of a
+// cancelled bet encoded as a protocol.CancelBet frame with its id:
+//
+//	| length (4 bytes) | crc32-c of payload (4 bytes) | payload (length bytes) |
+//
+// A record is only valid if it is complete and its checksum matches, so a
+// crash in the middle of a write leaves a torn tail that is truncated the next
+// time the log is opened. The offsets of the records of every agency are
+// indexed in memory so the bets of an agency are read without a full scan
+type LogStore struct {
+	options LogOptions
+
+	mu        sync.Mutex
+	file      *os.File
+	size      int64
+	index     map[uint8][]int64
+	counts    map[uint8]int
+	sequences map[uint8]uint32
+	// cancelled Ids of the bets of every agency with a tombstone
+	cancelled map[uint8]map[uint32]bool
+	dirty     bool
+
+	quit chan struct{}
+	done chan struct{}
+}
+
+// OpenLogStore Opens the log at path, creating it if needed. Existing records
+// are scanned to rebuild the index and a torn tail, if any, is truncated. A
+// log with any other invalid record is not opened
+func OpenLogStore(path string, options LogOptions) (*LogStore, error) {
+	flags := os.O_RDWR | os.O_CREATE
+	if options.ReadOnly {
+		flags = os.O_RDONLY
+	}
+	file, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &LogStore{
+		options:   options,
+		file:      file,
+		index:     make(map[uint8][]int64),
+		counts:    make(map[uint8]int),
+		sequences: make(map[uint8]uint32),
+		cancelled: make(map[uint8]map[uint32]bool),
+	}
+	if err := s.recover(); err != nil {
+		file.Close()
+		return nil, errors.Wrapf(err, "could not recover %s", path)
+	}
+
+	if options.Sync == SyncInterval && !options.ReadOnly {
+		if options.SyncInterval <= 0 {
+			file.Close()
+			return nil, errors.New("sync interval must be positive")
+		}
+		s.quit = make(chan struct{})
+		s.done = make(chan struct{})
+		go s.syncLoop()
+	}
+	return s, nil
+}
+
+// errTornRecord is returned for a record cut short by the end of the log, as
+// left by a crash in the middle of its write
+var errTornRecord = errors.New("torn record")
+
+// recover Validates the log from the beginning, indexing every record. A
+// torn record at the end is truncated, or left out if the log is read-only;
+// any other invalid record means the
+// log is corrupt and it is not opened, so no valid record after it is lost
+func (s *LogStore) recover() error {
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 && !s.options.ReadOnly {
+		if _, err := s.file.Write([]byte(logHeader)); err != nil {
+			return err
+		}
+		s.size = int64(len(logHeader))
+		return s.file.Sync()
+	}
+
+	header := make([]byte, len(logHeader))
+	if _, err := io.ReadFull(s.file, header); err != nil || string(header[:len(logMagic)]) != logMagic {
+		return errors.New("not a bet log")
+	}
+	if version := string(header[len(logMagic):]); version != logVersion {
+		return errors.Wrapf(ErrLogVersion, "version %s, this central reads version %s", version, logVersion)
+	}
+
+	reader := bufio.NewReader(s.file)
+	offset := int64(len(logHeader))
+	for {
+		record, n, err := readRecord(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && errors.Cause(err) != errTornRecord {
+			return errors.Wrapf(err, "corrupt record at offset %d", offset)
+		}
+		if err != nil && s.options.ReadOnly {
+			log.Warningf("action: leer_apuestas | result: fail | offset: %v | ignorados: %v | error: %v",
+				offset,
+				info.Size()-offset,
+				err,
+			)
+			break
+		}
+		if err != nil {
+			log.Warningf("action: recuperar_apuestas | result: fail | offset: %v | truncados: %v | error: %v",
+				offset,
+				info.Size()-offset,
+				err,
+			)
+			if err := s.file.Truncate(offset); err != nil {
+				return err
+			}
+			if err := s.file.Sync(); err != nil {
+				return err
+			}
+			break
+		}
+		s.indexRecord(record, offset)
+		offset += n
+	}
+	s.size = offset
+	_, err = s.file.Seek(offset, io.SeekStart)
+	return err
+}
+
+// readRecord Reads and validates the next record, a *protocol.BetBatch or a
+// *protocol.CancelBet. Returns io.EOF only if the log ends exactly at a
+// record boundary
+func readRecord(r io.Reader) (protocol.Message, int64, error) {
+	var header [recordHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, 0, errors.Wrap(errTornRecord, "header")
+		}
+		return nil, 0, err
+	}
+	length := binary.BigEndian.Uint32(header[:4])
+	if length > protocol.MaxFrameSize {
+		return nil, 0, errors.Errorf("record of %d bytes", length)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, 0, errTornRecord
+		}
+		return nil, 0, err
+	}
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, 0, errors.New("checksum mismatch")
+	}
+	msg, err := protocol.Decode(payload)
+	if err != nil {
+		return nil, 0, err
+	}
+	switch msg.(type) {
+	case *protocol.BetBatch, *protocol.CancelBet:
+		return msg, recordHeaderSize + int64(length), nil
+	}
+	return nil, 0, errors.Errorf("unexpected %v record", msg.Type())
+}
+
+func (s *LogStore) indexRecord(record protocol.Message, offset int64) {
+	switch r := record.(type) {
+	case *protocol.BetBatch:
+		s.index[r.Agency] = append(s.index[r.Agency], offset)
+		s.counts[r.Agency] += len(r.Bets)
+		if r.Sequence > s.sequences[r.Agency] {
+			s.sequences[r.Agency] = r.Sequence
+		}
+	case *protocol.CancelBet:
+		if s.cancelled[r.Agency] == nil {
+			s.cancelled[r.Agency] = make(map[uint32]bool)
+		}
+		s.cancelled[r.Agency][r.BetID] = true
+	}
+}
+
+// appendTombstone Encodes the record that cancels a bet
+func appendTombstone(buf []byte, agency uint8, id uint32) ([]byte, error) {
+	payload, err := protocol.Encode(&protocol.CancelBet{Agency: agency, BetID: id})
+	if err != nil {
+		return nil, err
+	}
+	return appendFrame(buf, payload), nil
+}
+
+// appendRecord Encodes a record with bets of an agency and batch sequence
+func appendRecord(buf []byte, agency uint8, sequence uint32, bets []protocol.Bet) ([]byte, error) {
+	payload, err := protocol.Encode(&protocol.BetBatch{Agency: agency, Sequence: sequence, Bets: bets})
+	if err != nil {
+		return nil, err
+	}
+	return appendFrame(buf, payload), nil
+}
+
+// appendFrame Appends the header of a record followed by its payload
+func appendFrame(buf []byte, payload []byte) []byte {
+	var header [recordHeaderSize]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(header[4:], crc32.Checksum(payload, crcTable))
+	buf = append(buf, header[:]...)
+	return append(buf, payload...)
+}
+
+// Store Appends the bets, one record per run of bets of the same agency and
+// sequence, and fsyncs according to the sync policy. Their ids follow the
+// bets already stored for every agency. Safe to call from several goroutines
+func (s *LogStore) Store(bets []StoredBet) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.options.ReadOnly {
+		return ErrReadOnly
+	}
+
+	ids := make(map[uint8]uint32)
+	for i := range bets {
+		if _, ok := ids[bets[i].Agency]; !ok {
+			ids[bets[i].Agency] = uint32(s.counts[bets[i].Agency])
+		}
+		ids[bets[i].Agency]++
+		bets[i].ID = ids[bets[i].Agency]
+	}
+
+	type pending struct {
+		batch  protocol.BetBatch
+		offset int64
+	}
+	var records []pending
+	var buf []byte
+	for start := 0; start < len(bets); {
+		end := start + 1
+		size := encodedSize(bets[start].Bet)
+		for end < len(bets) && end-start < protocol.MaxBatchAmount && sameBatch(bets[start], bets[end]) {
+			if size += encodedSize(bets[end].Bet); size > maxRecordBetsSize {
+				break
+			}
+			end++
+		}
+		group := make([]protocol.Bet, end-start)
+		for i := range group {
+			group[i] = bets[start+i].Bet
+		}
+		batch := protocol.BetBatch{Agency: bets[start].Agency, Sequence: bets[start].Sequence, Bets: group}
+		offset := s.size + int64(len(buf))
+		var err error
+		if buf, err = appendRecord(buf, batch.Agency, batch.Sequence, group); err != nil {
+			return err
+		}
+		records = append(records, pending{batch: batch, offset: offset})
+		start = end
+	}
+	if len(records) == 0 {
+		return nil
+	}
+
+	if err := s.write(buf, records[0].offset, len(records)); err != nil {
+		return err
+	}
+	for _, r := range records {
+		s.indexRecord(&r.batch, r.offset)
+	}
+	s.size += int64(len(buf))
+	return nil
+}
+
+// Cancel Appends the tombstone of the bet, unless it already has one
+func (s *LogStore) Cancel(agency uint8, id uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.options.ReadOnly {
+		return ErrReadOnly
+	}
+	if id == 0 || int(id) > s.counts[agency] {
+		return ErrBetNotFound
+	}
+	if s.cancelled[agency][id] {
+		return nil
+	}
+	buf, err := appendTombstone(nil, agency, id)
+	if err != nil {
+		return err
+	}
+	if err := s.write(buf, s.size, 1); err != nil {
+		return err
+	}
+	record := &protocol.CancelBet{Agency: agency, BetID: id}
+	s.indexRecord(record, s.size)
+	s.size += int64(len(buf))
+	return nil
+}
+
+func sameBatch(a StoredBet, b StoredBet) bool {
+	return a.Agency == b.Agency && a.Sequence == b.Sequence
+}
+
+// write Writes the encoded records and syncs them according to the policy.
+// If anything fails the log is truncated back to its previous size so no
+// record follows a torn one
+func (s *LogStore) write(buf []byte, offset int64, records int) error {
+	var err error
+	if s.options.Sync == SyncAlways && records > 1 {
+		// One write and fsync per record
+		for rest := buf; len(rest) > 0 && err == nil; {
+			n := recordHeaderSize + int(binary.BigEndian.Uint32(rest[:4]))
+			if _, err = s.file.Write(rest[:n]); err == nil {
+				err = s.file.Sync()
+			}
+			rest = rest[n:]
+		}
+	} else if _, err = s.file.Write(buf); err == nil {
+		if s.options.Sync == SyncInterval {
+			s.dirty = true
+		} else {
+			err = s.file.Sync()
+		}
+	}
+
+	if err != nil {
+		if truncErr := s.file.Truncate(offset); truncErr == nil {
+			s.file.Seek(offset, io.SeekStart)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *LogStore) syncLoop() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.options.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			if s.dirty {
+				if err := s.file.Sync(); err != nil {
+					log.Errorf("action: sync_apuestas | result: fail | error: %v", err)
+				} else {
+					s.dirty = false
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// Load Reads every record of the log, in the order they were stored
+func (s *LogStore) Load(fn func(bet StoredBet) error) error {
+	s.mu.Lock()
+	size := s.size
+	cancelled := s.cancelledBets()
+	s.mu.Unlock()
+
+	section := io.NewSectionReader(s.file, int64(len(logHeader)), size-int64(len(logHeader)))
+	reader := bufio.NewReader(section)
+	ids := make(map[uint8]uint32)
+	for {
+		record, _, err := readRecord(reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		batch, ok := record.(*protocol.BetBatch)
+		if !ok {
+			continue
+		}
+		next := ids[batch.Agency]
+		if err := forEachBet(batch, &next, cancelled[batch.Agency], fn); err != nil {
+			return err
+		}
+		ids[batch.Agency] = next
+	}
+}
+
+// cancelledBets Returns a copy of the tombstones. Must be called with mu held
+func (s *LogStore) cancelledBets() map[uint8]map[uint32]bool {
+	copied := make(map[uint8]map[uint32]bool, len(s.cancelled))
+	for agency, ids := range s.cancelled {
+		copied[agency] = make(map[uint32]bool, len(ids))
+		for id := range ids {
+			copied[agency][id] = true
+		}
+	}
+	return copied
+}
+
+// LoadAgency Reads only the records of the agency, using the index
+func (s *LogStore) LoadAgency(agency uint8, fn func(bet StoredBet) error) error {
+	s.mu.Lock()
+	offsets := append([]int64(nil), s.index[agency]...)
+	cancelled := s.cancelledBets()[agency]
+	s.mu.Unlock()
+
+	var id uint32
+	for _, offset := range offsets {
+		record, _, err := readRecord(io.NewSectionReader(s.file, offset, protocol.MaxFrameSize+recordHeaderSize))
+		if err != nil {
+			return errors.Wrapf(err, "record at %d", offset)
+		}
+		if err := forEachBet(record.(*protocol.BetBatch), &id, cancelled, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sequences Returns the last batch sequence stored for every agency
+func (s *LogStore) Sequences() map[uint8]uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return copySequences(s.sequences)
+}
+
+// Count Returns the amount of bets stored for the agency, kept by the index
+func (s *LogStore) Count(agency uint8) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counts[agency], nil
+}
+
+// Close Syncs pending records, if any, and closes the log
+func (s *LogStore) Close() error {
+	if s.quit != nil {
+		close(s.quit)
+		<-s.done
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	if !s.options.ReadOnly {
+		err = s.file.Sync()
+	}
+	if closeErr := s.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// forEachBet Calls fn with every bet of the record. Ids are given following
+// the last one, which is updated
+func forEachBet(batch *protocol.BetBatch, last *uint32, cancelled map[uint32]bool, fn func(bet StoredBet) error) error {
+	for _, bet := range batch.Bets {
+		*last++
+		stored := StoredBet{Agency: batch.Agency, Sequence: batch.Sequence, ID: *last, Cancelled: cancelled[*last], Bet: bet}
+		if err := fn(stored); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,356 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+func testBets(agencies int, perAgency int) []StoredBet {
+	var bets []StoredBet
+	for i := 0; i < perAgency; i++ {
+		for agency := 1; agency <= agencies; agency++ {
+			bets = append(bets, StoredBet{
+				Agency: uint8(agency),
+				Bet: protocol.Bet{
+					FirstName: fmt.Sprintf("Nombre %d", i),
+					LastName:  "Apellido",
+					Document:  uint64(agency*1000000 + i),
+					Birthdate: "1999-03-17",
+					Number:    uint16(i % protocol.NumberRange),
+					Stake:     uint32(i % 3 * 2550),
+				},
+			})
+		}
+	}
+	return bets
+}
+
+func loadAll(t *testing.T, store BetStore) []StoredBet {
+	t.Helper()
+	var loaded []StoredBet
+	if err := store.Load(func(bet StoredBet) error {
+		loaded = append(loaded, bet)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return loaded
+}
+
+func loadAgency(t *testing.T, store BetStore, agency uint8) []StoredBet {
+	t.Helper()
+	var loaded []StoredBet
+	if err := store.LoadAgency(agency, func(bet StoredBet) error {
+		loaded = append(loaded, bet)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return loaded
+}
+
+func filterAgency(bets []StoredBet, agency uint8) []StoredBet {
+	var filtered []StoredBet
+	for _, bet := range bets {
+		if bet.Agency == agency {
+			filtered = append(filtered, bet)
+		}
+	}
+	return filtered
+}
+
+func TestLogStoreKeepsBetsAcrossReopen(t *testing.T) {
+	for _, policy := range []SyncPolicy{SyncAlways, SyncBatch, SyncInterval} {
+		t.Run(policy.String(), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "bets.log")
+			options := LogOptions{Sync: policy, SyncInterval: 10 * time.Millisecond}
+			bets := testBets(3, 20)
+			for start := 0; start < len(bets); start += 7 {
+				end := start + 7
+				if end > len(bets) {
+					end = len(bets)
+				}
+				store, err := OpenLogStore(path, options)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := store.Store(bets[start:end]); err != nil {
+					t.Fatal(err)
+				}
+				store.Close()
+			}
+
+			store, err := OpenLogStore(path, options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+			if loaded := loadAll(t, store); fmt.Sprint(loaded) != fmt.Sprint(bets) {
+				t.Errorf("loaded %v bets, want %v", len(loaded), len(bets))
+			}
+			for agency := uint8(1); agency <= 3; agency++ {
+				want := filterAgency(bets, agency)
+				if loaded := loadAgency(t, store, agency); fmt.Sprint(loaded) != fmt.Sprint(want) {
+					t.Errorf("agency %v: loaded %v, want %v", agency, loaded, want)
+				}
+				if count, _ := store.Count(agency); count != len(want) {
+					t.Errorf("agency %v: count %v, want %v", agency, count, len(want))
+				}
+			}
+			if loaded := loadAgency(t, store, 4); len(loaded) != 0 {
+				t.Errorf("agency without bets loaded %v", loaded)
+			}
+		})
+	}
+}
+
+func TestLogStoreSplitsLargeBatches(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bets.log")
+	store, err := OpenLogStore(path, LogOptions{Sync: SyncBatch})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	bets := testBets(1, 600)
+	for i := range bets {
+		bets[i].FirstName = strings.Repeat("n", protocol.MaxStringLength)
+		bets[i].LastName = strings.Repeat("a", protocol.MaxStringLength)
+	}
+	if err := store.Store(bets); err != nil {
+		t.Fatal(err)
+	}
+	if loaded := loadAgency(t, store, 1); len(loaded) != len(bets) {
+		t.Errorf("loaded %v bets, want %v", len(loaded), len(bets))
+	}
+}
+
+func TestLogStoreTruncatesTornTail(t *testing.T) {
+	bets := testBets(2, 10)
+	tails := map[string]func(record []byte) []byte{
+		"partial header": func(record []byte) []byte { return record[:recordHeaderSize-3] },
+		"partial record": func(record []byte) []byte { return record[:len(record)-5] },
+	}
+
+	for name, tail := range tails {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "bets.log")
+			store, err := OpenLogStore(path, LogOptions{Sync: SyncBatch})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Store(bets); err != nil {
+				t.Fatal(err)
+			}
+			store.Close()
+			info, _ := os.Stat(path)
+
+			record, err := appendRecord(nil, 1, 0, []protocol.Bet{bets[0].Bet})
+			if err != nil {
+				t.Fatal(err)
+			}
+			file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			file.Write(tail(record))
+			file.Close()
+
+			store, err = OpenLogStore(path, LogOptions{Sync: SyncBatch})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if truncated, _ := os.Stat(path); truncated.Size() != info.Size() {
+				t.Errorf("log is %v bytes after recovery, want %v", truncated.Size(), info.Size())
+			}
+			first, _ := store.Count(1)
+			second, _ := store.Count(2)
+			if first != 10 || second != 10 {
+				t.Errorf("counts after recovery: %v and %v", first, second)
+			}
+
+			// New records follow the last valid one
+			again := []StoredBet{bets[0]}
+			if err := store.Store(again); err != nil {
+				t.Fatal(err)
+			}
+			if again[0].ID != 11 {
+				t.Errorf("bet stored after recovery has id %v, want 11", again[0].ID)
+			}
+			store.Close()
+			store, err = OpenLogStore(path, LogOptions{Sync: SyncBatch})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+			want := append(append([]StoredBet{}, bets...), again[0])
+			if loaded := loadAll(t, store); fmt.Sprint(loaded) != fmt.Sprint(want) {
+				t.Errorf("loaded %v bets, want %v", len(loaded), len(want))
+			}
+		})
+	}
+}
+
+func TestLogStoreOpensReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bets.log")
+	if _, err := OpenLogStore(path, LogOptions{ReadOnly: true}); err == nil {
+		t.Error("opened a log that does not exist read-only")
+	}
+	store, err := OpenLogStore(path, LogOptions{Sync: SyncBatch})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bets := testBets(2, 10)
+	if err := store.Store(bets); err != nil {
+		t.Fatal(err)
+	}
+	// A record the central is still writing
+	record, err := appendRecord(nil, 1, 0, []protocol.Bet{bets[0].Bet})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write(record[:len(record)-5])
+	file.Close()
+	before, _ := os.ReadFile(path)
+
+	reader, err := OpenLogStore(path, LogOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := reader.Count(1); count != 10 {
+		t.Errorf("read %v bets of agency 1, want 10", count)
+	}
+	if err := reader.Store([]StoredBet{bets[0]}); err != ErrReadOnly {
+		t.Errorf("store on a read-only log returned %v", err)
+	}
+	if err := reader.Cancel(1, 1); err != ErrReadOnly {
+		t.Errorf("cancel on a read-only log returned %v", err)
+	}
+	reader.Close()
+	store.Close()
+	if after, _ := os.ReadFile(path); !bytes.Equal(after, before) {
+		t.Errorf("read-only log changed from %v to %v bytes", len(before), len(after))
+	}
+}
+
+func TestLogStoreRefusesCorruptRecords(t *testing.T) {
+	bets := testBets(2, 10)
+	record, err := appendRecord(nil, 1, 0, []protocol.Bet{bets[0].Bet})
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := map[string]func(log []byte) []byte{
+		"bad checksum at the end": func(log []byte) []byte {
+			tail := append([]byte{}, record...)
+			tail[len(tail)-1] ^= 0xff
+			return append(log, tail...)
+		},
+		"garbage at the end": func(log []byte) []byte { return append(log, bytes.Repeat([]byte{0xff}, 32)...) },
+		"bad checksum in the middle": func(log []byte) []byte {
+			log[len(logHeader)+recordHeaderSize] ^= 0xff
+			return log
+		},
+	}
+
+	for name, corrupt := range corrupt {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "bets.log")
+			store, err := OpenLogStore(path, LogOptions{Sync: SyncBatch})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Store(bets); err != nil {
+				t.Fatal(err)
+			}
+			store.Close()
+			log, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			log = corrupt(log)
+			if err := os.WriteFile(path, log, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			if store, err := OpenLogStore(path, LogOptions{Sync: SyncBatch}); err == nil {
+				store.Close()
+				t.Fatal("opened a corrupt log")
+			}
+			if after, _ := os.ReadFile(path); !bytes.Equal(after, log) {
+				t.Errorf("corrupt log changed from %v to %v bytes", len(log), len(after))
+			}
+		})
+	}
+}
+
+func TestLogStoreRejectsOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bets.csv")
+	os.WriteFile(path, []byte("1,Santiago Lionel,Lorca,30904465,1999-03-17,7574\n"), 0644)
+	if store, err := OpenLogStore(path, LogOptions{Sync: SyncBatch}); err == nil {
+		store.Close()
+		t.Fatal("opened a CSV file as a bet log")
+	}
+
+	// A log written by a build with another record format
+	path = filepath.Join(t.TempDir(), "bets.log")
+	os.WriteFile(path, []byte(logMagic+"6"), 0644)
+	if store, err := OpenLogStore(path, LogOptions{Sync: SyncBatch}); errors.Cause(err) != ErrLogVersion {
+		if err == nil {
+			store.Close()
+		}
+		t.Errorf("opened a log of version 6: %v", err)
+	}
+}
+
+func TestExportCSV(t *testing.T) {
+	store, err := OpenLogStore(filepath.Join(t.TempDir(), "bets.log"), LogOptions{Sync: SyncBatch})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	bets := []StoredBet{
+		{Agency: 1, Bet: protocol.Bet{FirstName: "Santiago Lionel", LastName: "Lorca", Document: 30904465, Birthdate: "1999-03-17", Number: 7574}},
+		{Agency: 2, Bet: protocol.Bet{FirstName: "Tiago, Nicolás", LastName: "Rivera", Document: 34407251, Birthdate: "2001-08-29", Number: 1033, Stake: 15050}},
+		{Agency: 2, Bet: protocol.Bet{FirstName: "Ana", LastName: "Perez", Document: 30000001, Birthdate: "1980-01-01", Type: protocol.BetCombined, Numbers: []uint16{7574, 1033}}},
+	}
+	store.Store(bets)
+
+	var out bytes.Buffer
+	if err := ExportCSV(store, &out); err != nil {
+		t.Fatal(err)
+	}
+	want := "1,Santiago Lionel,Lorca,30904465,1999-03-17,7574\n" +
+		"2,\"Tiago, Nicolás\",Rivera,34407251,2001-08-29,1033,150.50\n" +
+		"2,Ana,Perez,30000001,1980-01-01,7574/1033\n"
+	if out.String() != want {
+		t.Errorf("exported\n%s\nwant\n%s", out.String(), want)
+	}
+
+	// Bets of every type, with and without stake, are read back from a CSV
+	// store
+	csvStore, err := OpenCSVStore(filepath.Join(t.TempDir(), "bets.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer csvStore.Close()
+	if err := csvStore.Store(bets); err != nil {
+		t.Fatal(err)
+	}
+	if loaded := loadAll(t, csvStore); !reflect.DeepEqual(loaded, bets) {
+		t.Errorf("loaded %+v, want %+v", loaded, bets)
+	}
+}
@@ -0,0 +1,99 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// betStatuses Returns the state of the bets of the document the agency gets
+func betStatuses(t *testing.T, server *Server, agency uint8, document uint64) []protocol.BetState {
+	t.Helper()
+	response, err := server.handle(nil, &protocol.BetStatusQuery{Agency: agency, Document: document})
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses, ok := response.(*protocol.BetStatuses)
+	if !ok {
+		t.Fatalf("bet status query answered with %v", describeMessage(response))
+	}
+	return statuses.Bets
+}
+
+// summary Returns the id and status of every bet
+func summary(states []protocol.BetState) map[uint32]protocol.BetStatus {
+	statuses := make(map[uint32]protocol.BetStatus, len(states))
+	for _, state := range states {
+		statuses[state.ID] = state.Status
+	}
+	return statuses
+}
+
+func TestServerReportsTheStatusOfTheBetsOfADocument(t *testing.T) {
+	server := startServerWith(t, ServerConfig{Agencies: 2})
+	first := sequencedBatch(1, 1, 3)
+	first.Bets[1].Stake = 10000
+	again := first.Bets[0]
+	again.Number = (testNumber + 1) % protocol.NumberRange
+	first.Bets = append(first.Bets, again)
+	ackStatus(t, server, first)
+	// The same document bets in another agency
+	ackStatus(t, server, &protocol.BetBatch{Agency: 2, Sequence: 1, Bets: []protocol.Bet{again}})
+	ackStatus(t, server, &protocol.CancelBet{Agency: 1, BetID: 1})
+
+	document := first.Bets[0].Document
+	before := summary(betStatuses(t, server, 1, document))
+	if len(before) != 2 || before[1] != protocol.BetCancelled || before[4] != protocol.BetStored {
+		t.Errorf("before the draw: %v, want bet 1 cancelled and 4 stored", before)
+	}
+	if other := summary(betStatuses(t, server, 2, document)); len(other) != 1 || other[1] != protocol.BetStored {
+		t.Errorf("agency 2 got %v, want only its own bet", other)
+	}
+	if status := ackStatus(t, server, &protocol.BetStatusQuery{Agency: 3, Document: document}); status != protocol.StatusFail {
+		t.Errorf("agency outside the roster answered %v", status)
+	}
+	if states := betStatuses(t, server, 1, 1); len(states) != 0 {
+		t.Errorf("document without bets got %+v", states)
+	}
+
+	ackStatus(t, server, &protocol.EndOfBets{Agency: 1})
+	if err := defaultContest(server).lottery.forceDraw(); err != nil {
+		t.Fatal(err)
+	}
+	waitDraw(t, server)
+
+	after := summary(betStatuses(t, server, 1, document))
+	if len(after) != 2 || after[1] != protocol.BetCancelled || after[4] != protocol.BetLost {
+		t.Errorf("after the draw: %v, want bet 1 cancelled and 4 not winner", after)
+	}
+	won := betStatuses(t, server, 1, first.Bets[1].Document)
+	if len(won) != 1 || won[0].Status != protocol.BetWon || won[0].Tier != protocol.NumberDigits || won[0].Payout != 10000 {
+		t.Errorf("winning bet reported as %+v, want the whole pool of 10000", won)
+	}
+	// Agency 2 was left out of the draw
+	if other := summary(betStatuses(t, server, 2, document)); other[1] != protocol.BetLost {
+		t.Errorf("bet of an agency left out of the draw reported as %v", other[1])
+	}
+}
+
+func TestConnectionCannotSeeBetsOfAnotherAgency(t *testing.T) {
+	server := startServerWith(t, ServerConfig{Agencies: 2})
+	batch := sequencedBatch(1, 1, 1)
+	ackStatus(t, server, batch)
+
+	conn := dialAgency(t, server)
+	document := batch.Bets[0].Document
+	if response := exchange(t, conn, &protocol.BetStatusQuery{Agency: 2, Document: document}); response.Type() != protocol.MsgBetStatuses {
+		t.Fatalf("query of agency 2 answered with %v", describeMessage(response))
+	}
+	response := exchange(t, conn, &protocol.BetStatusQuery{Agency: 1, Document: document})
+	if ack, ok := response.(*protocol.Ack); !ok || ack.Status != protocol.StatusFail {
+		t.Errorf("query on behalf of agency 1 answered with %v", describeMessage(response))
+	}
+
+	own := dialAgency(t, server)
+	response = exchange(t, own, &protocol.BetStatusQuery{Agency: 1, Document: document})
+	if statuses, ok := response.(*protocol.BetStatuses); !ok || len(statuses.Bets) != 1 {
+		t.Errorf("query of its own bets answered with %v", describeMessage(response))
+	}
+}
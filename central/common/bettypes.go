@@ -0,0 +1,99 @@
+package common
+
+import (
+	"math/bits"
+
+	"github.com/pkg/errors"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// BetEvaluator Rules of a type of bet: which bets of the type are taken and
+// which of them win
+type BetEvaluator interface {
+	// Validate Returns why the bet cannot be taken, nil if it can
+	Validate(bet protocol.Bet) error
+	// Evaluate Returns the prize tier the bet won, the best one if several of
+	// its numbers did, and its weight in the split of the prize pool: its
+	// stake times the payout of every winning number, shared among the
+	// numbers it plays. False if the bet did not win
+	Evaluate(bet protocol.Bet, tiers []PrizeTier, winning uint16) (PrizeTier, uint64, bool)
+}
+
+// DefaultBetTypes Single and combined bets. There is no redoblona, which
+// bets on the positions of several drawn numbers, since a contest draws one
+var DefaultBetTypes = map[protocol.BetType]BetEvaluator{
+	protocol.BetSingle:   SingleBet{},
+	protocol.BetCombined: CombinedBet{},
+}
+
+// SingleBet A single number that wins the tier it matches, as in the
+// original server
+type SingleBet struct{}
+
+// Validate Takes any number. Those out of range never match exactly
+func (SingleBet) Validate(bet protocol.Bet) error {
+	return nil
+}
+
+// Evaluate Matches the number against the winning one
+func (SingleBet) Evaluate(bet protocol.Bet, tiers []PrizeTier, winning uint16) (PrizeTier, uint64, bool) {
+	tier, won := matchTier(tiers, bet.Number, winning)
+	if !won {
+		return PrizeTier{}, 0, false
+	}
+	return tier, uint64(bet.Stake) * uint64(tier.Payout), true
+}
+
+// CombinedBet Quiniela combinada: several different numbers, each one played
+// as a single bet with an even part of the stake
+type CombinedBet struct{}
+
+// Validate Takes from 2 to protocol.MaxCombinedNumbers different numbers in
+// range
+func (CombinedBet) Validate(bet protocol.Bet) error {
+	if len(bet.Numbers) < 2 || len(bet.Numbers) > protocol.MaxCombinedNumbers {
+		return errors.Errorf("combined bet has %d numbers, must have from 2 to %d", len(bet.Numbers), protocol.MaxCombinedNumbers)
+	}
+	seen := make(map[uint16]bool, len(bet.Numbers))
+	for _, number := range bet.Numbers {
+		if number >= protocol.NumberRange {
+			return errors.Errorf("number %d is out of range", number)
+		}
+		if seen[number] {
+			return errors.Errorf("number %d is played twice", number)
+		}
+		seen[number] = true
+	}
+	return nil
+}
+
+// Evaluate Matches every number against the winning one. The weight is
+// rounded down once, after adding up the winning numbers in 128 bits
+func (CombinedBet) Evaluate(bet protocol.Bet, tiers []PrizeTier, winning uint16) (PrizeTier, uint64, bool) {
+	var (
+		best  PrizeTier
+		high  uint64
+		low   uint64
+		carry uint64
+		won   bool
+	)
+	for _, number := range bet.Numbers {
+		tier, ok := matchTier(tiers, number, winning)
+		if !ok {
+			continue
+		}
+		low, carry = bits.Add64(low, uint64(bet.Stake)*uint64(tier.Payout), 0)
+		high += carry
+		if !won || tier.Digits > best.Digits {
+			best = tier
+		}
+		won = true
+	}
+	if !won {
+		return PrizeTier{}, 0, false
+	}
+	// Below the largest weight of a number, so it fits in 64 bits
+	weight, _ := bits.Div64(high, low, uint64(len(bet.Numbers)))
+	return best, weight, true
+}
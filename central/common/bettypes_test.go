@@ -0,0 +1,83 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+func combinedBet(stake uint32, numbers ...uint16) protocol.Bet {
+	return protocol.Bet{Type: protocol.BetCombined, Numbers: numbers, Stake: stake}
+}
+
+func TestCombinedBetValidation(t *testing.T) {
+	valid := combinedBet(0, 1, 2)
+	if err := (CombinedBet{}).Validate(valid); err != nil {
+		t.Errorf("%v: %v", valid.Numbers, err)
+	}
+	for _, invalid := range []protocol.Bet{
+		combinedBet(0, 1),
+		combinedBet(0, 1, 1),
+		combinedBet(0, 1, protocol.NumberRange),
+		combinedBet(0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11),
+	} {
+		if err := (CombinedBet{}).Validate(invalid); err == nil {
+			t.Errorf("%v validated", invalid.Numbers)
+		}
+	}
+}
+
+func TestCombinedBetSharesItsStakeAmongItsNumbers(t *testing.T) {
+	tiers, _ := ParsePrizeTiers("4:3500,3:600")
+	tests := []struct {
+		bet    protocol.Bet
+		digits uint8
+		weight uint64
+		won    bool
+	}{
+		// A third of the stake on the exact match
+		{combinedBet(300, 5164, 1, 2), 4, 100 * 3500, true},
+		// Both numbers win, the bet reports the best tier
+		{combinedBet(200, 5164, 1164), 4, 100*3500 + 100*600, true},
+		{combinedBet(200, 1, 2), 0, 0, false},
+	}
+	for _, test := range tests {
+		tier, weight, won := (CombinedBet{}).Evaluate(test.bet, tiers, 5164)
+		if won != test.won || tier.Digits != test.digits || weight != test.weight {
+			t.Errorf("%v: got %v %v %v, want %v %v %v", test.bet.Numbers, tier.Digits, weight, won, test.digits, test.weight, test.won)
+		}
+	}
+}
+
+func TestServerEvaluatesEveryBetType(t *testing.T) {
+	server := startServerWith(t, ServerConfig{Agencies: 1})
+	batch := sequencedBatch(1, 1, 3)
+	batch.Bets[1].Type = protocol.BetCombined
+	batch.Bets[1].Numbers = []uint16{(testNumber + 1) % protocol.NumberRange, testNumber}
+	batch.Bets[2].Type = protocol.BetCombined
+	batch.Bets[2].Numbers = []uint16{(testNumber + 1) % protocol.NumberRange, (testNumber + 2) % protocol.NumberRange}
+
+	invalid := sequencedBatch(1, 2, 1)
+	invalid.Bets[0].Type = protocol.BetCombined
+	unknown := sequencedBatch(1, 2, 1)
+	unknown.Bets[0].Type = 200
+	for _, rejected := range []*protocol.BetBatch{invalid, unknown} {
+		if status := ackStatus(t, server, rejected); status != protocol.StatusFail {
+			t.Errorf("bet of type %v answered with %v", rejected.Bets[0].Type, status)
+		}
+	}
+	ackStatus(t, server, batch)
+	ackStatus(t, server, &protocol.EndOfBets{Agency: 1})
+	waitDraw(t, server)
+
+	winners, _, err := defaultContest(server).lottery.winnersOf(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(winners.Documents) != 2 || winners.Documents[0] != batch.Bets[0].Document || winners.Documents[1] != batch.Bets[1].Document {
+		t.Errorf("winners %v, want the single and the first combined bet", winners.Documents)
+	}
+	if count, _ := defaultContest(server).store.Count(1); count != 3 {
+		t.Errorf("stored %v bets, want 3", count)
+	}
+}
@@ -0,0 +1,181 @@
+package common
+
+import (
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+func TestLogStoreKeepsTombstonesAcrossReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bets.log")
+	store, err := OpenLogStore(path, LogOptions{Sync: SyncBatch})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bets := testBets(2, 5)
+	if err := store.Store(bets); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Cancel(1, 3); err != nil {
+		t.Fatal(err)
+	}
+	// A second cancellation of the bet changes nothing
+	if err := store.Cancel(1, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Cancel(2, 6); err != ErrBetNotFound {
+		t.Errorf("cancel of a bet never stored: got %v, want %v", err, ErrBetNotFound)
+	}
+	store.Close()
+
+	store, err = OpenLogStore(path, LogOptions{Sync: SyncBatch})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	for _, bet := range loadAll(t, store) {
+		if want := bet.Agency == 1 && bet.ID == 3; bet.Cancelled != want {
+			t.Errorf("bet %v of agency %v: cancelled %v, want %v", bet.ID, bet.Agency, bet.Cancelled, want)
+		}
+	}
+	if loaded := loadAgency(t, store, 1); !loaded[2].Cancelled || loaded[2].ID != 3 {
+		t.Errorf("third bet of agency 1 loaded as %+v", loaded[2])
+	}
+
+	// Tombstones are not bets, so ids go on from the last one
+	more := []StoredBet{bets[0]}
+	if err := store.Store(more); err != nil {
+		t.Fatal(err)
+	}
+	if more[0].ID != 6 {
+		t.Errorf("next bet of agency 1 has id %v, want 6", more[0].ID)
+	}
+	if count, _ := store.Count(1); count != 6 {
+		t.Errorf("agency 1 has %v bets, want 6", count)
+	}
+}
+
+// handleAck Returns the Ack the server answers msg with
+func handleAck(t *testing.T, server *Server, msg protocol.Message) *protocol.Ack {
+	t.Helper()
+	response, err := server.handle(nil, msg)
+	if err != nil {
+		t.Fatalf("%v: %v", msg.Type(), err)
+	}
+	ack, ok := response.(*protocol.Ack)
+	if !ok {
+		t.Fatalf("%v answered with %v", msg.Type(), response.Type())
+	}
+	return ack
+}
+
+func TestServerCancelsBetsWhileOpen(t *testing.T) {
+	server := startServerWith(t, ServerConfig{Agencies: 2})
+	if ack := handleAck(t, server, sequencedBatch(1, 1, 3)); ack.BetID != 1 {
+		t.Errorf("first batch starts at id %v, want 1", ack.BetID)
+	}
+	second := sequencedBatch(1, 2, 2)
+	if ack := handleAck(t, server, second); ack.BetID != 4 {
+		t.Errorf("second batch starts at id %v, want 4", ack.BetID)
+	}
+	if ack := handleAck(t, server, second); ack.Status != protocol.StatusOK || ack.BetID != 4 {
+		t.Errorf("retry of the second batch answered %v with id %v, want ok with 4", ack.Status, ack.BetID)
+	}
+	other := (testNumber + 1) % protocol.NumberRange
+	twice := &protocol.BetBatch{Agency: 1, Sequence: 3, Bets: []protocol.Bet{
+		{FirstName: "Nombre", LastName: "Apellido", Document: 7, Birthdate: "1999-03-17", Number: other},
+		{FirstName: "Nombre", LastName: "Apellido", Document: 7, Birthdate: "1999-03-17", Number: other},
+	}}
+	handleAck(t, server, twice)
+
+	tests := []struct {
+		name   string
+		cancel *protocol.CancelBet
+		status protocol.Status
+		id     uint32
+	}{
+		{"by id", &protocol.CancelBet{Agency: 1, BetID: 1}, protocol.StatusOK, 1},
+		{"twice", &protocol.CancelBet{Agency: 1, BetID: 1}, protocol.StatusOK, 1},
+		{"unknown id", &protocol.CancelBet{Agency: 1, BetID: 50}, protocol.StatusNotFound, 0},
+		{"by document", &protocol.CancelBet{Agency: 1, Document: second.Bets[0].Document, Numbers: []uint16{testNumber}}, protocol.StatusOK, 4},
+		{"other number", &protocol.CancelBet{Agency: 1, Document: second.Bets[1].Document, Numbers: []uint16{other}}, protocol.StatusNotFound, 0},
+		{"ambiguous", &protocol.CancelBet{Agency: 1, Document: 7, Numbers: []uint16{other}}, protocol.StatusFail, 0},
+		{"other agency", &protocol.CancelBet{Agency: 2, BetID: 2}, protocol.StatusNotFound, 0},
+		{"outside the roster", &protocol.CancelBet{Agency: 3, BetID: 2}, protocol.StatusFail, 0},
+	}
+	for _, test := range tests {
+		if ack := handleAck(t, server, test.cancel); ack.Status != test.status || ack.BetID != test.id {
+			t.Errorf("%v: answered %v with id %v, want %v with %v", test.name, ack.Status, ack.BetID, test.status, test.id)
+		}
+	}
+
+	ackStatus(t, server, &protocol.EndOfBets{Agency: 1})
+	if status := ackStatus(t, server, &protocol.CancelBet{Agency: 1, BetID: 2}); status != protocol.StatusContestClosed {
+		t.Errorf("cancel after finishing answered %v", status)
+	}
+	ackStatus(t, server, &protocol.EndOfBets{Agency: 2})
+	waitDraw(t, server)
+
+	winners, _, err := defaultContest(server).lottery.winnersOf(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []uint64{1001001, 1001002, 1002001}
+	if !reflect.DeepEqual(winners.Documents, want) {
+		t.Errorf("winners %v, want %v without the cancelled bets", winners.Documents, want)
+	}
+}
+
+// dialAgency Opens a connection to the server that stays open until the test
+// ends
+func dialAgency(t *testing.T, server *Server) net.Conn {
+	t.Helper()
+	conn, err := net.Dial("tcp", server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// exchange Sends msg over the connection and returns the answer
+func exchange(t *testing.T, conn net.Conn, msg protocol.Message) protocol.Message {
+	t.Helper()
+	if err := protocol.WriteMessage(conn, msg); err != nil {
+		t.Fatal(err)
+	}
+	response, err := protocol.ReadMessage(conn)
+	if err != nil {
+		t.Fatalf("%v: %v", msg.Type(), err)
+	}
+	return response
+}
+
+func TestConnectionCannotCancelBetsOfAnotherAgency(t *testing.T) {
+	server := startServerWith(t, ServerConfig{Agencies: 2})
+	ackStatus(t, server, sequencedBatch(1, 1, 2))
+
+	conn := dialAgency(t, server)
+	if response := exchange(t, conn, &protocol.ResumeQuery{Agency: 2}); response.Type() != protocol.MsgResume {
+		t.Fatalf("resume answered with %v", describeMessage(response))
+	}
+	response := exchange(t, conn, &protocol.CancelBet{Agency: 1, BetID: 1})
+	if ack, ok := response.(*protocol.Ack); !ok || ack.Status != protocol.StatusFail {
+		t.Errorf("cancel on behalf of agency 1 answered with %v", describeMessage(response))
+	}
+	for _, bet := range loadAgency(t, defaultContest(server).lottery.store, 1) {
+		if bet.Cancelled {
+			t.Errorf("bet %v of agency 1 was cancelled by agency 2", bet.ID)
+		}
+	}
+
+	// The agency can still cancel its own bets over a connection
+	own := dialAgency(t, server)
+	response = exchange(t, own, &protocol.CancelBet{Agency: 1, BetID: 1})
+	if ack, ok := response.(*protocol.Ack); !ok || ack.Status != protocol.StatusOK {
+		t.Errorf("cancel of its own bet answered with %v", describeMessage(response))
+	}
+}
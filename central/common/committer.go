@@ -0,0 +1,138 @@
+package common
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// maxCommitBets Max amount of bets coalesced in a single write. A single
+// batch larger than this is still written at once
+const maxCommitBets = 8192
+
+// ErrStoreClosed is returned for bets submitted after the committer stopped
+var ErrStoreClosed = errors.New("bet storage closed")
+
+// CommitStats Counters of the writes made by the committer
+type CommitStats struct {
+	// Commits Amount of writes to the store, each followed by its fsync
+	Commits int
+	// Batches Amount of batches written, coalesced in Commits writes
+	Batches int
+	Bets    int
+}
+
+// commitRequest Bets of a single batch waiting to be persisted. The result
+// of the write is sent on done
+type commitRequest struct {
+	bets []StoredBet
+	done chan error
+}
+
+// committer Single writer of the bet store. The batches submitted by every
+// connection while a write is in progress are coalesced into the next one, so
+// they share a single write and fsync instead of queueing on a lock. Each
+// submitter is released only once its bets are durable
+type committer struct {
+	store    BetStore
+	requests chan commitRequest
+	quit     chan struct{}
+	done     chan struct{}
+
+	mu     sync.Mutex
+	counts CommitStats
+}
+
+// newCommitter Starts the writer goroutine of the store
+func newCommitter(store BetStore) *committer {
+	c := &committer{
+		store:    store,
+		requests: make(chan commitRequest),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go c.loop()
+	return c
+}
+
+// submit Queues the bets and waits until they are durable or the write fails
+func (c *committer) submit(bets []StoredBet) error {
+	request := commitRequest{bets: bets, done: make(chan error, 1)}
+	select {
+	case c.requests <- request:
+	case <-c.quit:
+		return ErrStoreClosed
+	}
+	return <-request.done
+}
+
+// stop Stops the writer goroutine once the write in progress, if any, is done
+func (c *committer) stop() {
+	close(c.quit)
+	<-c.done
+}
+
+func (c *committer) loop() {
+	defer close(c.done)
+	for {
+		select {
+		case request := <-c.requests:
+			c.commit(c.coalesce(request))
+		case <-c.quit:
+			return
+		}
+	}
+}
+
+// coalesce Collects the requests already waiting to be written, up to
+// maxCommitBets bets
+func (c *committer) coalesce(first commitRequest) []commitRequest {
+	group := []commitRequest{first}
+	bets := len(first.bets)
+	for bets < maxCommitBets {
+		select {
+		case request := <-c.requests:
+			group = append(group, request)
+			bets += len(request.bets)
+		default:
+			return group
+		}
+	}
+	return group
+}
+
+// commit Writes the bets of every request at once and releases their
+// submitters, with the ids given by the store set in their bets. If the
+// write fails all of them get the error
+func (c *committer) commit(group []commitRequest) {
+	var bets []StoredBet
+	for _, request := range group {
+		bets = append(bets, request.bets...)
+	}
+	err := c.store.Store(bets)
+	if err == nil {
+		rest := bets
+		for _, request := range group {
+			copy(request.bets, rest)
+			rest = rest[len(request.bets):]
+		}
+		c.mu.Lock()
+		c.counts.Commits++
+		c.counts.Batches += len(group)
+		c.counts.Bets += len(bets)
+		c.mu.Unlock()
+		log.Debugf("action: group_commit | result: success | batches: %v | apuestas: %v", len(group), len(bets))
+	} else {
+		log.Errorf("action: group_commit | result: fail | batches: %v | apuestas: %v | error: %v", len(group), len(bets), err)
+	}
+	for _, request := range group {
+		request.done <- err
+	}
+}
+
+// stats Returns the counters of the writes made so far
+func (c *committer) stats() CommitStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts
+}
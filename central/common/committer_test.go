@@ -0,0 +1,168 @@
+package common
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// slowStore Takes a while to store so batches pile up behind the write in
+// progress, like they do behind an fsync
+type slowStore struct {
+	BetStore
+	delay time.Duration
+	err   error
+
+	mu     sync.Mutex
+	writes [][]StoredBet
+}
+
+func (s *slowStore) Store(bets []StoredBet) error {
+	time.Sleep(s.delay)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.writes = append(s.writes, bets)
+	return nil
+}
+
+func submitConcurrently(c *committer, batches int) []error {
+	errs := make([]error, batches)
+	var wg sync.WaitGroup
+	for i := 0; i < batches; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = c.submit(testBets(1, 3))
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
+
+func TestCommitterCoalescesConcurrentBatches(t *testing.T) {
+	store := &slowStore{delay: 20 * time.Millisecond}
+	c := newCommitter(store)
+	defer c.stop()
+
+	const batches = 50
+	for _, err := range submitConcurrently(c, batches) {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats := c.stats()
+	if stats.Batches != batches || stats.Bets != 3*batches {
+		t.Errorf("stats %+v, want %v batches of 3 bets", stats, batches)
+	}
+	if stats.Commits != len(store.writes) || stats.Commits >= batches/2 {
+		t.Errorf("%v batches written in %v commits", batches, stats.Commits)
+	}
+	stored := 0
+	for _, write := range store.writes {
+		stored += len(write)
+	}
+	if stored != 3*batches {
+		t.Errorf("stored %v bets, want %v", stored, 3*batches)
+	}
+}
+
+func TestCommitterFailsEveryCoalescedBatch(t *testing.T) {
+	failure := errors.New("disk full")
+	c := newCommitter(&slowStore{delay: 5 * time.Millisecond, err: failure})
+	defer c.stop()
+
+	for _, err := range submitConcurrently(c, 10) {
+		if err != failure {
+			t.Errorf("submit returned %v, want %v", err, failure)
+		}
+	}
+	if stats := c.stats(); stats.Commits != 0 {
+		t.Errorf("failed writes counted: %+v", stats)
+	}
+}
+
+func TestCommitterRejectsBetsAfterStop(t *testing.T) {
+	c := newCommitter(&slowStore{})
+	c.stop()
+	if err := c.submit(testBets(1, 1)); err != ErrStoreClosed {
+		t.Errorf("submit after stop returned %v", err)
+	}
+}
+
+// gatedStore Holds every write until released, then fails it with err or
+// passes it on to the wrapped store if there is none
+type gatedStore struct {
+	BetStore
+	writing chan struct{}
+	release chan struct{}
+	err     error
+}
+
+func (s *gatedStore) Store(bets []StoredBet) error {
+	s.writing <- struct{}{}
+	<-s.release
+	if s.err != nil {
+		return s.err
+	}
+	return s.BetStore.Store(bets)
+}
+
+func TestRetryGetsTheResultOfTheBatchBeingWritten(t *testing.T) {
+	dir := t.TempDir()
+	logStore, err := OpenLogStore(filepath.Join(dir, "bets.log"), LogOptions{Sync: SyncBatch})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logStore.Close()
+	failure := errors.New("disk full")
+	store := &gatedStore{BetStore: logStore, writing: make(chan struct{}, 1), release: make(chan struct{}), err: failure}
+	c := newCommitter(store)
+	defer c.stop()
+	config := ServerConfig{Roster: []uint8{1}, Quorum: 1, Contest: "1", Seed: testSeed, StatePath: filepath.Join(dir, "contest.json")}
+	l, err := newLottery(store, c, config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bets := []StoredBet{{Agency: 1, Sequence: 1, Bet: sequencedBatch(1, 1, 1).Bets[0]}}
+	type result struct {
+		duplicate bool
+		err       error
+	}
+	results := make(chan result, 2)
+	send := func() {
+		duplicate, err := l.storeBets(1, 1, bets)
+		results <- result{duplicate, err}
+	}
+	go send()
+	<-store.writing
+	go send()
+	select {
+	case r := <-results:
+		close(store.release)
+		t.Fatalf("retry answered %+v while the batch was being written", r)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(store.release)
+	for i := 0; i < 2; i++ {
+		if r := <-results; r.duplicate || r.err != failure {
+			t.Errorf("batch and retry answered %+v, want the failed write", r)
+		}
+	}
+
+	// The failed sequence was not accepted, so the next retry stores it
+	store.err = nil
+	if duplicate, err := l.storeBets(1, 1, bets); duplicate || err != nil {
+		t.Errorf("retry after the failed write: duplicate %v, error %v", duplicate, err)
+	}
+	if duplicate, err := l.storeBets(1, 1, bets); !duplicate || err != nil {
+		t.Errorf("retry after the batch was stored: duplicate %v, error %v", duplicate, err)
+	}
+}
@@ -0,0 +1,168 @@
+package common
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// ErrInvalidContest is returned for contest ids that are too long or have
+// characters other than letters, digits, '.', '_' and '-'
+var ErrInvalidContest = errors.New("invalid contest id")
+
+// ErrUnknownContest is returned for requests that name a contest the central
+// does not run
+var ErrUnknownContest = errors.New("unknown contest")
+
+// maxContestIDLength Contest ids are part of file names
+const maxContestIDLength = 64
+
+// contestSeedDomain Prefix of the hash the seed of every contest is derived
+// from
+const contestSeedDomain = "tp0/contest-seed/v1"
+
+// contest Bets and draw of a single contest. Every contest has its own
+// storage, state, roster, barrier and winning number
+type contest struct {
+	id        string
+	store     BetStore
+	committer *committer
+	lottery   *lottery
+}
+
+// openContest Opens the storage of the contest and restores its state, if it
+// was saved, or opens it with the roster, quorum and deadline of config
+func openContest(config ServerConfig, id string) (*contest, error) {
+	config.Contest = id
+	config.StoragePath = contestPath(config.StoragePath, id)
+	config.StatePath = contestPath(config.StatePath, id)
+	state, err := loadState(config.StatePath)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not load contest state")
+	}
+
+	var seed []byte
+	if len(config.Seed) > 0 {
+		seed = contestSeed(config.Seed, id)
+	}
+	if state != nil {
+		saved, err := state.seed()
+		if err != nil {
+			return nil, err
+		}
+		if seed != nil && !bytes.Equal(seed, saved) {
+			return nil, errors.New("configured seed differs from the one of the saved contest")
+		}
+		seed = saved
+	}
+	if seed == nil {
+		seed = make([]byte, protocol.SeedSize)
+		if _, err := rand.Read(seed); err != nil {
+			return nil, errors.Wrap(err, "could not generate the seed of the draw")
+		}
+	}
+	if len(seed) > protocol.MaxStringLength {
+		return nil, errors.Errorf("seed is %d bytes long, max is %d", len(seed), protocol.MaxStringLength)
+	}
+	config.Seed = seed
+
+	store, err := openStore(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not open bet storage")
+	}
+	committer := newCommitter(store)
+	lottery, err := newLottery(store, committer, config, state)
+	if err != nil {
+		committer.stop()
+		store.Close()
+		return nil, err
+	}
+	log.Infof("action: commitment | result: success | concurso: %v | hash: %x", id, lottery.commitment)
+	return &contest{id: id, store: store, committer: committer, lottery: lottery}, nil
+}
+
+// close Stops the deadline and the committer and closes the storage
+func (c *contest) close() {
+	c.lottery.stop()
+	c.committer.stop()
+	c.store.Close()
+}
+
+// validContestID Returns whether id can identify a contest
+func validContestID(id string) bool {
+	if id == "" || len(id) > maxContestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '.' || c == '_' || c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// contestSeed Derives the seed of a contest from the seed of the central, so
+// every contest has its own winning number while a configured seed keeps them
+// reproducible. Only the derived seeds are revealed
+func contestSeed(master []byte, id string) []byte {
+	h := sha256.New()
+	h.Write([]byte(contestSeedDomain))
+	h.Write([]byte{byte(len(id))})
+	h.Write([]byte(id))
+	h.Write(master)
+	return h.Sum(nil)
+}
+
+// contestPath Returns the path of a file of the contest: the configured path
+// with the id of the contest before its extension, bets-<id>.log for bets.log
+func contestPath(path string, id string) string {
+	if path == "" {
+		return ""
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + id + ext
+}
+
+// savedContests Returns the ids of the contests whose state was saved next to
+// statePath, so they are restored when the central starts
+func savedContests(statePath string) ([]string, error) {
+	if statePath == "" {
+		return nil, nil
+	}
+	dir, file := filepath.Split(statePath)
+	if dir == "" {
+		dir = "."
+	}
+	ext := filepath.Ext(file)
+	prefix := strings.TrimSuffix(file, ext) + "-"
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		if id := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext); validContestID(id) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
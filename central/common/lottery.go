@@ -0,0 +1,744 @@
+package common
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// ErrContestClosed is returned when an agency sends bets after it finished or
+// after the draw, and when an agency that did not finish in time asks for its
+// winners
+var ErrContestClosed = errors.New("contest closed")
+
+// ErrUnknownAgency is returned for requests of agencies not in the roster
+var ErrUnknownAgency = errors.New("agency not in the roster")
+
+// ErrAmbiguousBet is returned when several bets match the document and
+// numbers of a cancellation, which must give the bet id instead
+var ErrAmbiguousBet = errors.New("several bets match")
+
+var (
+	errAlreadyClosed   = errors.New("contest already closed")
+	errAlreadyDrawn    = errors.New("draw already made")
+	errNoAgencyForDraw = errors.New("no agency finished sending bets")
+)
+
+// Phases of the contest
+const (
+	PhaseOpen   = "open"
+	PhaseClosed = "closed"
+	PhaseDrawn  = "drawn"
+)
+
+// lottery State of a contest shared by every connection: the agencies that
+// finished sending bets and, once the draw happened, their winners. The draw
+// happens when every agency of the roster finished or, after the deadline, as
+// soon as a quorum of them did. Agencies that did not finish by then are left
+// out of the draw. The winning number is derived from a secret seed,
+// committed to before bets open and revealed with the winners. The stakes of
+// the agencies in the draw, minus the cut of the house, make the prize pool
+// shared by the winners. The state of
+// the contest is saved to statePath, if set, and restored when the central
+// starts again
+type lottery struct {
+	store        BetStore
+	committer    *committer
+	roster       map[uint8]bool
+	quorum       int
+	contest      string
+	key          ed25519.PrivateKey
+	seed         []byte
+	commitment   [protocol.CommitmentSize]byte
+	winnerNumber uint16
+	// tiers Prize tiers the contest was opened with, from the most to the
+	// least digits
+	tiers []PrizeTier
+	// betTypes Rules of every type of bet taken
+	betTypes map[protocol.BetType]BetEvaluator
+	// houseCut Part of the stakes kept by the house, in basis points
+	houseCut  uint16
+	statePath string
+	deadline  *time.Timer
+
+	mu       sync.Mutex
+	openedAt time.Time
+	finished map[uint8]bool
+	// sequences Last batch sequence accepted from every agency
+	sequences map[uint8]uint32
+	// committing Sequenced batches being written, so a retry of one of them
+	// gets the result of the write
+	committing map[batchKey]*inflightBatch
+	expired    bool
+	// closed is set right before the draw, or when an operator closes the
+	// contest, so no more bets are accepted. The draw waits for the bets
+	// being written before that
+	closed bool
+	// drawing is set by whoever is going to make the draw, so it happens once
+	drawing bool
+	pending sync.WaitGroup
+	drawnAt time.Time
+	// winners Winning bets of every agency in the draw, looked up in the
+	// store the first time an agency asks for them, since the payouts depend
+	// on the stakes of all of them. Nil until then
+	winners map[uint8]*agencyWinners
+	// pool Prize pool shared by the winners, in cents
+	pool uint64
+	// drawn is closed when the draw happens, waking up the agencies waiting
+	// for their winners to be pushed
+	drawn chan struct{}
+}
+
+// newLottery Opens the contest, or restores it from state if the central
+// restarted. The deadline, if any, counts from when the contest was first
+// opened. The roster and quorum must have been validated, and the seed must
+// be the one of the state
+func newLottery(store BetStore, committer *committer, config ServerConfig, state *contestState) (*lottery, error) {
+	l := &lottery{
+		store:        store,
+		committer:    committer,
+		roster:       make(map[uint8]bool, len(config.Roster)),
+		quorum:       config.Quorum,
+		contest:      config.Contest,
+		key:          config.SigningKey,
+		seed:         config.Seed,
+		commitment:   protocol.Commit(config.Seed),
+		winnerNumber: protocol.WinningNumber(config.Seed),
+		tiers:        config.PrizeTiers,
+		betTypes:     config.BetTypes,
+		houseCut:     config.HouseCut,
+		statePath:    config.StatePath,
+		openedAt:     time.Now(),
+		finished:     make(map[uint8]bool),
+		sequences:    store.Sequences(),
+		committing:   make(map[batchKey]*inflightBatch),
+		drawn:        make(chan struct{}),
+	}
+	for _, agency := range config.Roster {
+		l.roster[agency] = true
+	}
+
+	if state != nil {
+		if err := l.restore(state); err != nil {
+			return nil, err
+		}
+	} else if err := l.save(); err != nil {
+		return nil, errors.Wrap(err, "could not save contest state")
+	}
+
+	l.expired = config.Deadline > 0 && time.Since(l.openedAt) >= config.Deadline
+	if l.closed && l.drawnAt.IsZero() && l.ready() {
+		// The central stopped between closing the contest and the draw
+		l.drawing = true
+		l.draw()
+	}
+	if config.Deadline > 0 && !l.closed {
+		l.deadline = time.AfterFunc(config.Deadline-time.Since(l.openedAt), l.expire)
+	}
+	return l, nil
+}
+
+// restore Continues the contest saved in state
+func (l *lottery) restore(state *contestState) error {
+	if state.Contest != l.contest {
+		return errors.Errorf("saved state belongs to contest %q, not %q", state.Contest, l.contest)
+	}
+	if state.WinningNumber != l.winnerNumber {
+		return errors.Errorf("saved winning number %d was not derived from the seed", state.WinningNumber)
+	}
+	l.openedAt = state.OpenedAt
+	if len(state.Tiers) > 0 {
+		// Prize tiers configured after the contest opened apply to the next
+		// ones only
+		l.tiers = state.Tiers
+	}
+	if state.HouseCut != nil {
+		l.houseCut = *state.HouseCut
+	}
+	for _, agency := range state.Finished {
+		if !l.roster[uint8(agency)] {
+			return errors.Errorf("finished agency %d is not in the roster", agency)
+		}
+		l.finished[uint8(agency)] = true
+	}
+	for agency, sequence := range state.Sequences {
+		if sequence > l.sequences[agency] {
+			l.sequences[agency] = sequence
+		}
+	}
+	l.closed = state.Closed
+	if state.DrawnAt != nil {
+		l.drawnAt = *state.DrawnAt
+		close(l.drawn)
+	}
+	log.Infof("action: restaurar_concurso | result: success | agencias: %v/%v | sorteo: %v | concurso: %v",
+		len(l.finished),
+		len(l.roster),
+		!l.drawnAt.IsZero(),
+		l.contest,
+	)
+	return nil
+}
+
+// save Writes the state of the contest, if a path is configured. Must be
+// called with mu held or before the lottery is shared
+func (l *lottery) save() error {
+	if l.statePath == "" {
+		return nil
+	}
+	state := &contestState{
+		Contest:       l.contest,
+		Seed:          hex.EncodeToString(l.seed),
+		WinningNumber: l.winnerNumber,
+		OpenedAt:      l.openedAt,
+		Finished:      []int{},
+		Sequences:     l.sequences,
+		Closed:        l.closed,
+		Tiers:         l.tiers,
+	}
+	houseCut := l.houseCut
+	state.HouseCut = &houseCut
+	for agency := range l.finished {
+		state.Finished = append(state.Finished, int(agency))
+	}
+	sort.Ints(state.Finished)
+	if !l.drawnAt.IsZero() {
+		drawnAt := l.drawnAt
+		state.DrawnAt = &drawnAt
+	}
+	return state.save(l.statePath)
+}
+
+// stop Stops the deadline timer
+func (l *lottery) stop() {
+	if l.deadline != nil {
+		l.deadline.Stop()
+	}
+}
+
+// batchKey A batch of an agency, by its sequence
+type batchKey struct {
+	agency   uint8
+	sequence uint32
+}
+
+// inflightBatch A sequenced batch being written. done is closed once err
+// holds the result of the write
+type inflightBatch struct {
+	done chan struct{}
+	err  error
+}
+
+// storeBets Persists a batch of bets of an agency that did not finish yet.
+// The bets are written by the committer along with those of other agencies,
+// and the draw does not happen until they are durable. A batch whose sequence
+// was already accepted is not stored again and true is returned. If it is
+// still being written, the result of that write is waited for and returned
+func (l *lottery) storeBets(agency uint8, sequence uint32, bets []StoredBet) (bool, error) {
+	l.mu.Lock()
+	if !l.roster[agency] {
+		l.mu.Unlock()
+		return false, ErrUnknownAgency
+	}
+	if l.finished[agency] || l.closed {
+		l.mu.Unlock()
+		return false, ErrContestClosed
+	}
+	previous := l.sequences[agency]
+	key := batchKey{agency: agency, sequence: sequence}
+	if sequence != 0 && sequence <= previous {
+		inflight := l.committing[key]
+		l.mu.Unlock()
+		if inflight == nil {
+			return true, nil
+		}
+		// A retry sent before the write of the batch ended
+		<-inflight.done
+		return inflight.err == nil, inflight.err
+	}
+	var inflight *inflightBatch
+	if sequence != 0 {
+		l.sequences[agency] = sequence
+		inflight = &inflightBatch{done: make(chan struct{})}
+		l.committing[key] = inflight
+	}
+	l.pending.Add(1)
+	l.mu.Unlock()
+
+	defer l.pending.Done()
+	err := l.committer.submit(bets)
+	if sequence != 0 {
+		l.mu.Lock()
+		if err != nil && l.sequences[agency] == sequence {
+			l.sequences[agency] = previous
+		}
+		delete(l.committing, key)
+		inflight.err = err
+		l.mu.Unlock()
+		close(inflight.done)
+	}
+	return false, err
+}
+
+// batchID Returns the id of the first bet of the batch the agency sent with
+// the sequence, zero if it is not stored
+func (l *lottery) batchID(agency uint8, sequence uint32) (uint32, error) {
+	var id uint32
+	err := l.store.LoadAgency(agency, func(bet StoredBet) error {
+		if id == 0 && bet.Sequence == sequence {
+			id = bet.ID
+		}
+		return nil
+	})
+	return id, err
+}
+
+// cancel Voids a bet of an agency that did not finish yet, found by its id
+// or, if it is zero, by its document and the numbers it plays. The bet is
+// kept in the store with a tombstone and plays no more. Like storeBets, the
+// draw does not happen until the tombstone is durable
+func (l *lottery) cancel(m *protocol.CancelBet) (uint32, error) {
+	l.mu.Lock()
+	if !l.roster[m.Agency] {
+		l.mu.Unlock()
+		return 0, ErrUnknownAgency
+	}
+	if l.finished[m.Agency] || l.closed {
+		l.mu.Unlock()
+		return 0, ErrContestClosed
+	}
+	l.pending.Add(1)
+	l.mu.Unlock()
+	defer l.pending.Done()
+
+	id := m.BetID
+	if id == 0 {
+		var err error
+		if id, err = l.findBet(m.Agency, m.Document, m.Numbers); err != nil {
+			return 0, err
+		}
+	}
+	return id, l.store.Cancel(m.Agency, id)
+}
+
+// findBet Returns the id of the only bet of the agency not cancelled yet with
+// the document and numbers
+func (l *lottery) findBet(agency uint8, document uint64, numbers []uint16) (uint32, error) {
+	var ids []uint32
+	err := l.store.LoadAgency(agency, func(bet StoredBet) error {
+		if !bet.Cancelled && bet.Document == document && sameNumbers(bet.Played(), numbers) {
+			ids = append(ids, bet.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	switch len(ids) {
+	case 0:
+		return 0, ErrBetNotFound
+	case 1:
+		return ids[0], nil
+	}
+	return 0, errors.Wrapf(ErrAmbiguousBet, "%d bets of document %v play %v", len(ids), document, numbers)
+}
+
+// sameNumbers Whether both bets play the same numbers, in any order
+func sameNumbers(a []uint16, b []uint16) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	count := make(map[uint16]int, len(a))
+	for _, number := range a {
+		count[number]++
+	}
+	for _, number := range b {
+		if count[number] == 0 {
+			return false
+		}
+		count[number]--
+	}
+	return true
+}
+
+// validate Returns why the bet cannot be taken, nil if it can
+func (l *lottery) validate(bet protocol.Bet) error {
+	evaluator, ok := l.betTypes[bet.Type]
+	if !ok {
+		return errors.Errorf("bets of type %v are not taken", bet.Type)
+	}
+	return evaluator.Validate(bet)
+}
+
+// resume Returns where the agency left off
+func (l *lottery) resume(agency uint8) (*protocol.Resume, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.roster[agency] {
+		return nil, ErrUnknownAgency
+	}
+	if l.closed && !l.finished[agency] {
+		return nil, ErrContestClosed
+	}
+	return &protocol.Resume{Sequence: l.sequences[agency], Finished: l.finished[agency]}, nil
+}
+
+// finish Registers that the agency sent all of its bets and makes the draw
+// if it was the last one needed. Agencies that finish after the draw get
+// ErrContestClosed
+func (l *lottery) finish(agency uint8) error {
+	l.mu.Lock()
+	if !l.roster[agency] {
+		l.mu.Unlock()
+		return ErrUnknownAgency
+	}
+	if l.closed {
+		finished := l.finished[agency]
+		l.mu.Unlock()
+		if !finished {
+			return ErrContestClosed
+		}
+		// A retry of an agency that already finished
+		return nil
+	}
+	l.finished[agency] = true
+	l.closed = l.ready()
+	if err := l.save(); err != nil {
+		delete(l.finished, agency)
+		l.closed = false
+		l.mu.Unlock()
+		return errors.Wrap(err, "could not save contest state")
+	}
+	drawing := l.closed
+	l.drawing = drawing
+	l.mu.Unlock()
+	if drawing {
+		l.draw()
+	}
+	return nil
+}
+
+// forceClose Stops accepting bets before every agency finished. The draw
+// is left to forceDraw
+func (l *lottery) forceClose() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return errAlreadyClosed
+	}
+	l.closed = true
+	if err := l.save(); err != nil {
+		l.closed = false
+		return errors.Wrap(err, "could not save contest state")
+	}
+	log.Infof("action: cerrar_concurso | result: success | agencias: %v/%v | concurso: %v", len(l.finished), len(l.roster), l.contest)
+	return nil
+}
+
+// forceDraw Closes the contest, if still open, and makes the draw with the
+// agencies that finished so far, regardless of the quorum
+func (l *lottery) forceDraw() error {
+	l.mu.Lock()
+	if l.drawing {
+		l.mu.Unlock()
+		return errAlreadyDrawn
+	}
+	if len(l.finished) == 0 {
+		l.mu.Unlock()
+		return errNoAgencyForDraw
+	}
+	wasClosed := l.closed
+	l.closed = true
+	if err := l.save(); err != nil {
+		l.closed = wasClosed
+		l.mu.Unlock()
+		return errors.Wrap(err, "could not save contest state")
+	}
+	l.drawing = true
+	l.mu.Unlock()
+	l.draw()
+	return nil
+}
+
+// expire Called when the deadline passes. The draw happens now if a quorum
+// of agencies already finished, otherwise as soon as it does
+func (l *lottery) expire() {
+	l.mu.Lock()
+	l.expired = true
+	if l.closed {
+		l.mu.Unlock()
+		return
+	}
+	if !l.ready() {
+		log.Warningf("action: sorteo | result: in_progress | agencias: %v/%v | quorum: %v | concurso: %v",
+			len(l.finished),
+			len(l.roster),
+			l.quorum,
+			l.contest,
+		)
+		l.mu.Unlock()
+		return
+	}
+	l.closed = true
+	l.drawing = true
+	if err := l.save(); err != nil {
+		log.Errorf("action: guardar_concurso | result: fail | error: %v", err)
+	}
+	l.mu.Unlock()
+	l.draw()
+}
+
+// ready Returns whether the draw can happen. Must be called with mu held
+func (l *lottery) ready() bool {
+	return len(l.finished) == len(l.roster) || (l.expired && len(l.finished) >= l.quorum)
+}
+
+// draw Waits for the bets being written and sets the time of the draw.
+// Winners are looked up per agency when asked for. Must be called once, by
+// whoever set drawing
+func (l *lottery) draw() {
+	// No bets are accepted once closed, so only those already submitted
+	// are waited for
+	l.pending.Wait()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.drawnAt = time.Now()
+	close(l.drawn)
+	if err := l.save(); err != nil {
+		// The draw is made again with the same seed on restart
+		log.Errorf("action: guardar_concurso | result: fail | error: %v", err)
+	}
+	log.Infof("action: sorteo | result: success | agencias: %v/%v | concurso: %v", len(l.finished), len(l.roster), l.contest)
+	log.Infof("action: revelar_semilla | result: success | semilla: %x | numero_ganador: %v | concurso: %v", l.seed, l.winnerNumber, l.contest)
+}
+
+// winnersOf Returns the winners of the agency, signed by the central, and
+// false if the draw did not happen yet. Agencies left out of the draw get
+// ErrContestClosed
+func (l *lottery) winnersOf(agency uint8) (*protocol.Winners, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.roster[agency] {
+		return nil, false, ErrUnknownAgency
+	}
+	if l.drawnAt.IsZero() {
+		return nil, false, nil
+	}
+	if !l.finished[agency] {
+		return nil, true, ErrContestClosed
+	}
+	if l.winners == nil {
+		if err := l.settle(); err != nil {
+			return nil, true, errors.Wrap(err, "could not load bets")
+		}
+	}
+	found := l.winners[agency]
+	winners := &protocol.Winners{
+		Contest:       l.contest,
+		DrawnAt:       l.drawnAt.UnixNano() / int64(time.Millisecond),
+		WinningNumber: l.winnerNumber,
+		Seed:          l.seed,
+		Documents:     found.documents,
+		Tiers:         found.tiers,
+		Pool:          l.pool,
+		Payouts:       found.payouts,
+	}
+	if err := protocol.SignWinners(l.key, l.contest, agency, winners); err != nil {
+		return nil, true, err
+	}
+	return winners, true, nil
+}
+
+// settle Looks up the winners of every agency in the draw and splits the
+// prize pool among them, in proportion to the weight given to every winning
+// bet by the evaluator of its type. Cancelled bets neither win nor add to the
+// pool. Must be called with mu held, once the draw happened
+func (l *lottery) settle() error {
+	winners := make(map[uint8]*agencyWinners, len(l.finished))
+	for agency := range l.finished {
+		winners[agency] = &agencyWinners{documents: []uint64{}, tiers: []uint8{}, payouts: []uint64{}}
+	}
+	var (
+		stakes  uint64
+		weights []uint64
+		owners  []*agencyWinners
+	)
+	err := l.store.Load(func(bet StoredBet) error {
+		found, ok := winners[bet.Agency]
+		if !ok || bet.Cancelled {
+			// Left out of the draw, its stakes are not part of the pool
+			return nil
+		}
+		stakes += uint64(bet.Stake)
+		if tier, weight, won := l.evaluate(bet); won {
+			found.documents = append(found.documents, bet.Document)
+			found.tiers = append(found.tiers, tier.Digits)
+			weights = append(weights, weight)
+			owners = append(owners, found)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	pool := prizePool(stakes, l.houseCut)
+	for i, payout := range splitPool(pool, weights) {
+		owners[i].payouts = append(owners[i].payouts, payout)
+	}
+	l.winners = winners
+	l.pool = pool
+	log.Infof("action: pozo | result: success | apostado: %v | pozo: %v | ganadores: %v | concurso: %v",
+		protocol.FormatAmount(stakes),
+		protocol.FormatAmount(pool),
+		len(weights),
+		l.contest,
+	)
+	return nil
+}
+
+// evaluate Returns the prize tier and weight of the bet if it won the draw.
+// Must be called once the draw happened
+func (l *lottery) evaluate(bet StoredBet) (PrizeTier, uint64, bool) {
+	evaluator, ok := l.betTypes[bet.Type]
+	if !ok {
+		// Stored while the central took the type. It plays no more
+		return PrizeTier{}, 0, false
+	}
+	return evaluator.Evaluate(bet.Bet, l.tiers, l.winnerNumber)
+}
+
+// betsOf Returns the bets of the agency placed by the document, in the order
+// they were stored, along with their state. Once the draw happened the
+// winners are settled if they were not yet
+func (l *lottery) betsOf(agency uint8, document uint64) ([]protocol.BetState, error) {
+	l.mu.Lock()
+	if !l.roster[agency] {
+		l.mu.Unlock()
+		return nil, ErrUnknownAgency
+	}
+	drawn := !l.drawnAt.IsZero()
+	var found *agencyWinners
+	if drawn && l.finished[agency] {
+		if l.winners == nil {
+			if err := l.settle(); err != nil {
+				l.mu.Unlock()
+				return nil, errors.Wrap(err, "could not load bets")
+			}
+		}
+		found = l.winners[agency]
+	}
+	l.mu.Unlock()
+
+	// The winning bets of the agency were settled in the order they are
+	// loaded, so the i-th one gets the i-th payout
+	won := 0
+	states := []protocol.BetState{}
+	err := l.store.LoadAgency(agency, func(bet StoredBet) error {
+		state := protocol.BetState{ID: bet.ID, Status: protocol.BetStored, Bet: bet.Bet}
+		switch {
+		case bet.Cancelled:
+			state.Status = protocol.BetCancelled
+		case !drawn:
+		case found == nil:
+			// Left out of the draw
+			state.Status = protocol.BetLost
+		default:
+			state.Status = protocol.BetLost
+			if _, _, ok := l.evaluate(bet); ok {
+				state.Status = protocol.BetWon
+				state.Tier = found.tiers[won]
+				state.Payout = found.payouts[won]
+				won++
+			}
+		}
+		if bet.Document == document {
+			states = append(states, state)
+		}
+		return nil
+	})
+	return states, err
+}
+
+// agencyWinners Winning documents of an agency with their prize tier and
+// payout
+type agencyWinners struct {
+	documents []uint64
+	tiers     []uint8
+	payouts   []uint64
+}
+
+// AgencyStatus State of an agency as seen by the central
+type AgencyStatus struct {
+	ID       uint8  `json:"id"`
+	Finished bool   `json:"finished"`
+	Sequence uint32 `json:"sequence"`
+}
+
+// ContestStatus Summary of the contest
+type ContestStatus struct {
+	Contest    string      `json:"contest"`
+	Phase      string      `json:"phase"`
+	Agencies   int         `json:"agencies"`
+	Finished   int         `json:"finished"`
+	Quorum     int         `json:"quorum"`
+	OpenedAt   time.Time   `json:"opened_at"`
+	Commitment string      `json:"commitment"`
+	Tiers      []PrizeTier `json:"tiers"`
+	// HouseCut Part of the stakes kept by the house, in basis points
+	HouseCut uint16     `json:"house_cut"`
+	DrawnAt  *time.Time `json:"drawn_at,omitempty"`
+	// WinningNumber Only known once the draw happened
+	WinningNumber *uint16 `json:"winning_number,omitempty"`
+}
+
+// agencies Returns the state of every agency of the roster, by id
+func (l *lottery) agencies() []AgencyStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	statuses := make([]AgencyStatus, 0, len(l.roster))
+	for agency := range l.roster {
+		statuses = append(statuses, AgencyStatus{
+			ID:       agency,
+			Finished: l.finished[agency],
+			Sequence: l.sequences[agency],
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].ID < statuses[j].ID })
+	return statuses
+}
+
+// status Returns the summary of the contest
+func (l *lottery) status() ContestStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	status := ContestStatus{
+		Contest:    l.contest,
+		Phase:      PhaseOpen,
+		Agencies:   len(l.roster),
+		Finished:   len(l.finished),
+		Quorum:     l.quorum,
+		OpenedAt:   l.openedAt,
+		Commitment: hex.EncodeToString(l.commitment[:]),
+		Tiers:      l.tiers,
+		HouseCut:   l.houseCut,
+	}
+	if l.closed {
+		status.Phase = PhaseClosed
+	}
+	if !l.drawnAt.IsZero() {
+		drawnAt := l.drawnAt
+		number := l.winnerNumber
+		status.Phase = PhaseDrawn
+		status.DrawnAt = &drawnAt
+		status.WinningNumber = &number
+	}
+	return status
+}
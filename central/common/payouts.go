@@ -0,0 +1,77 @@
+package common
+
+import (
+	"math/big"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// maxHouseCut A house cut of 100%, in basis points
+const maxHouseCut = 10000
+
+// ParseHouseCut Parses the cut of the house as a percentage with up to two
+// decimals, like "25" or "12.5", and returns it in basis points
+func ParseHouseCut(s string) (uint16, error) {
+	// Hundredths of a percent are written like cents
+	cut, err := protocol.ParseAmount(s)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid house cut %q", s)
+	}
+	if cut > maxHouseCut {
+		return 0, errors.Errorf("house cut %q is over 100%%", s)
+	}
+	return uint16(cut), nil
+}
+
+// prizePool Returns the pool shared by the winners: the stakes minus the cut
+// of the house, in basis points. The fraction of a cent left by the cut goes
+// to the house
+func prizePool(stakes uint64, houseCut uint16) uint64 {
+	pool := new(big.Int).SetUint64(stakes)
+	pool.Mul(pool, big.NewInt(int64(maxHouseCut-houseCut)))
+	pool.Quo(pool, big.NewInt(maxHouseCut))
+	return pool.Uint64()
+}
+
+// splitPool Splits the pool among the winners in proportion to their
+// weights, in whole cents. Every winner gets its share rounded down, and the
+// cents left over go one each to the winners with the largest fractions,
+// the first one in weights on ties, so the whole pool is paid and the split
+// is the same on every run. Nothing is paid if every weight is zero
+func splitPool(pool uint64, weights []uint64) []uint64 {
+	payouts := make([]uint64, len(weights))
+	total := new(big.Int)
+	for _, weight := range weights {
+		total.Add(total, new(big.Int).SetUint64(weight))
+	}
+	if total.Sign() == 0 {
+		return payouts
+	}
+
+	fractions := make([]*big.Int, len(weights))
+	paid := uint64(0)
+	for i, weight := range weights {
+		share := new(big.Int).SetUint64(pool)
+		share.Mul(share, new(big.Int).SetUint64(weight))
+		fractions[i] = new(big.Int)
+		share.QuoRem(share, total, fractions[i])
+		payouts[i] = share.Uint64()
+		paid += payouts[i]
+	}
+
+	order := make([]int, len(weights))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return fractions[order[a]].Cmp(fractions[order[b]]) > 0
+	})
+	// Less cents are left than winners, since each one lost less than one
+	for _, i := range order[:pool-paid] {
+		payouts[i]++
+	}
+	return payouts
+}
@@ -0,0 +1,80 @@
+package common
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+func TestParseHouseCut(t *testing.T) {
+	for s, want := range map[string]uint16{"0": 0, "25": 2500, "12.5": 1250, "100": 10000} {
+		if cut, err := ParseHouseCut(s); err != nil || cut != want {
+			t.Errorf("%q: got %v %v, want %v", s, cut, err, want)
+		}
+	}
+	for _, invalid := range []string{"", "-5", "100.01", "25%"} {
+		if _, err := ParseHouseCut(invalid); err == nil {
+			t.Errorf("%q parsed", invalid)
+		}
+	}
+}
+
+func TestSplitPoolPaysEveryCent(t *testing.T) {
+	tests := []struct {
+		pool    uint64
+		weights []uint64
+		want    []uint64
+	}{
+		// The cent left goes to the first of the tied winners
+		{1000, []uint64{1, 1, 1}, []uint64{334, 333, 333}},
+		// 66.67 and 33.33
+		{100, []uint64{2, 1}, []uint64{67, 33}},
+		{100, []uint64{0, 3}, []uint64{0, 100}},
+		{100, []uint64{0, 0}, []uint64{0, 0}},
+		{100, nil, []uint64{}},
+		{1 << 60, []uint64{math.MaxUint64, math.MaxUint64}, []uint64{1 << 59, 1 << 59}},
+	}
+	for _, test := range tests {
+		if got := splitPool(test.pool, test.weights); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("split of %v among %v: got %v, want %v", test.pool, test.weights, got, test.want)
+		}
+	}
+	if pool := prizePool(10001, 2500); pool != 7500 {
+		t.Errorf("pool of 100.01 with a 25%% cut is %v, want 7500", pool)
+	}
+}
+
+func TestServerSplitsThePoolAmongWinners(t *testing.T) {
+	server := startServerWith(t, ServerConfig{Agencies: 3, HouseCut: 2500})
+	first := sequencedBatch(1, 1, 3)
+	first.Bets[0].Stake = 10000
+	first.Bets[1].Stake = 5000
+	first.Bets[1].Number = (testNumber + 1) % protocol.NumberRange
+	second := sequencedBatch(2, 1, 2)
+	second.Bets[0].Stake = 30000
+	second.Bets[1].Stake = 5000
+	second.Bets[1].Number = (testNumber + 1) % protocol.NumberRange
+	// Agency 3 is left out of the draw, so its stakes do not count
+	third := sequencedBatch(3, 1, 1)
+	third.Bets[0].Stake = 100000
+	for _, msg := range []protocol.Message{first, second, third, &protocol.EndOfBets{Agency: 1}, &protocol.EndOfBets{Agency: 2}} {
+		ackStatus(t, server, msg)
+	}
+	if err := defaultContest(server).lottery.forceDraw(); err != nil {
+		t.Fatal(err)
+	}
+	waitDraw(t, server)
+
+	// 500.00 staked minus 25% makes a pool of 375.00, split 1:0:3
+	for agency, want := range map[uint8][]uint64{1: {9375, 0}, 2: {28125}} {
+		winners, _, err := defaultContest(server).lottery.winnersOf(agency)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if winners.Pool != 37500 || !reflect.DeepEqual(winners.Payouts, want) {
+			t.Errorf("agency %v: pool %v with payouts %v, want 37500 with %v", agency, winners.Pool, winners.Payouts, want)
+		}
+	}
+}
@@ -0,0 +1,724 @@
+package common
+
+import (
+	"context"
+	"crypto/ed25519"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/pkg/errors"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+var log = logging.MustGetLogger("log")
+
+// ServerConfig Configuration used by the central
+type ServerConfig struct {
+	ListenAddress string
+	// Agencies Amount of agencies taking part in the contest, numbered from
+	// 1. Only used if Roster is empty
+	Agencies int
+	// Roster Agencies taking part in the contest. The draw happens once all
+	// of them finished sending bets
+	Roster []uint8
+	// Quorum Amount of agencies of the roster that must finish for the draw
+	// to happen after the deadline. Zero means all of them
+	Quorum int
+	// Deadline Time after a contest opens from which the draw runs with the
+	// agencies that finished, as long as they are a quorum. Zero waits for
+	// all of them
+	Deadline time.Duration
+	// Seed Secret the seed of every contest is derived from, see contestSeed.
+	// Every contest gets a random seed if empty
+	Seed []byte
+	// Contest Default contest, opened on startup and used by requests that
+	// do not name one. Roster, quorum and deadline apply to each contest
+	Contest string
+	// Contests Other contests opened on startup. Agencies can only take part
+	// in these, the default one, those restored after a restart and those
+	// opened with the admin API
+	Contests []string
+	// PrizeTiers Prize tiers of the contests, DefaultPrizeTiers if empty. A
+	// contest keeps the tiers it was opened with across restarts
+	PrizeTiers []PrizeTier
+	// HouseCut Part of the stakes of every contest kept by the house, in
+	// basis points. The rest is the prize pool shared by the winners. A
+	// contest keeps the cut it was opened with across restarts
+	HouseCut uint16
+	// BetTypes Rules of every type of bet taken, DefaultBetTypes if nil.
+	// Bets of other types are refused
+	BetTypes map[protocol.BetType]BetEvaluator
+	// SigningKey Key the winners are signed with. A random one is generated
+	// if nil
+	SigningKey ed25519.PrivateKey
+	// StorageEngine Either "log", an append-only checksummed log, or "csv",
+	// the bets.csv format of the original server
+	StorageEngine string
+	// StoragePath Every contest stores its bets in this path with its id
+	// added before the extension, see contestPath
+	StoragePath string
+	// Storage Options of the log engine
+	Storage LogOptions
+	// StatePath File the state of the contest is saved to, so it survives a
+	// restart, with the id of the contest added like to StoragePath. Not
+	// saved if empty
+	StatePath string
+	// AdminAddress Loopback address of the admin HTTP API. Disabled if empty
+	AdminAddress string
+	// AdminToken Bearer token needed by the admin operations that change the
+	// contest. They are disabled if empty
+	AdminToken string
+}
+
+// Server Lottery central. Every agency is served in its own goroutine over a
+// persistent connection
+type Server struct {
+	config   ServerConfig
+	listener net.Listener
+	admin    *http.Server
+	adminLn  net.Listener
+
+	contestsMu sync.Mutex
+	contests   map[string]*contest
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+	// connected Open connections of every agency to every contest it sent
+	// requests for. The agency is known by the first request
+	connected map[agencyKey]int
+	wg        sync.WaitGroup
+
+	quit     chan struct{}
+	quitOnce sync.Once
+}
+
+// NewServer Opens the default contest, restores those saved before a
+// restart and starts listening on ListenAddress
+func NewServer(config ServerConfig) (*Server, error) {
+	if config.Contest == "" {
+		config.Contest = "1"
+	}
+	for _, id := range append([]string{config.Contest}, config.Contests...) {
+		if !validContestID(id) {
+			return nil, errors.Wrapf(ErrInvalidContest, "%q", id)
+		}
+	}
+	roster, err := buildRoster(config)
+	if err != nil {
+		return nil, err
+	}
+	config.Roster = roster
+	if config.Quorum == 0 {
+		config.Quorum = len(roster)
+	}
+	if config.Quorum < 1 || config.Quorum > len(roster) {
+		return nil, errors.Errorf("quorum %d must be between 1 and the %d agencies of the roster", config.Quorum, len(roster))
+	}
+	if len(config.PrizeTiers) == 0 {
+		config.PrizeTiers = DefaultPrizeTiers
+	}
+	if config.PrizeTiers, err = sortPrizeTiers(config.PrizeTiers); err != nil {
+		return nil, err
+	}
+	if config.BetTypes == nil {
+		config.BetTypes = DefaultBetTypes
+	}
+	if config.HouseCut > maxHouseCut {
+		return nil, errors.Errorf("house cut of %d basis points is over 100%%", config.HouseCut)
+	}
+	if config.SigningKey == nil {
+		_, key, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not generate the signing key")
+		}
+		config.SigningKey = key
+	}
+	s := &Server{
+		config:    config,
+		contests:  make(map[string]*contest),
+		conns:     make(map[net.Conn]struct{}),
+		connected: make(map[agencyKey]int),
+		quit:      make(chan struct{}),
+	}
+	if err := s.openContests(); err != nil {
+		s.closeContests()
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", config.ListenAddress)
+	if err != nil {
+		s.closeContests()
+		return nil, err
+	}
+	s.listener = listener
+	if config.AdminAddress != "" {
+		if s.adminLn, err = listenAdmin(config.AdminAddress); err != nil {
+			listener.Close()
+			s.closeContests()
+			return nil, err
+		}
+	}
+	s.admin = &http.Server{Handler: s.adminHandler()}
+	return s, nil
+}
+
+// openContests Opens the default contest, the configured ones and those
+// saved before a restart
+func (s *Server) openContests() error {
+	ids, err := savedContests(s.config.StatePath)
+	if err != nil {
+		return errors.Wrap(err, "could not list saved contests")
+	}
+	ids = append(ids, s.config.Contests...)
+	ids = append(ids, s.config.Contest)
+	for _, id := range ids {
+		if _, _, err := s.startContest(id); err != nil {
+			return errors.Wrapf(err, "contest %v", id)
+		}
+	}
+	return nil
+}
+
+// startContest Returns the contest, opening it if it is not open yet. True if
+// it was opened now
+func (s *Server) startContest(id string) (*contest, bool, error) {
+	if !validContestID(id) {
+		return nil, false, ErrInvalidContest
+	}
+	s.contestsMu.Lock()
+	defer s.contestsMu.Unlock()
+	if c, ok := s.contests[id]; ok {
+		return c, false, nil
+	}
+	if s.contests == nil {
+		return nil, false, errors.New("server stopped")
+	}
+	c, err := openContest(s.config, id)
+	if err != nil {
+		return nil, false, err
+	}
+	s.contests[id] = c
+	return c, true, nil
+}
+
+// contest Returns the open contest an agency request refers to. Requests
+// never open contests, so a mistyped id cannot start a new one. An empty id
+// is the default contest
+func (s *Server) contest(id string) (*contest, error) {
+	if id == "" {
+		id = s.config.Contest
+	}
+	if !validContestID(id) {
+		return nil, ErrInvalidContest
+	}
+	c, ok := s.lookupContest(id)
+	if !ok {
+		return nil, ErrUnknownContest
+	}
+	return c, nil
+}
+
+// lookupContest Returns the contest if it is open, without opening it. An
+// empty id is the default contest
+func (s *Server) lookupContest(id string) (*contest, bool) {
+	if id == "" {
+		id = s.config.Contest
+	}
+	s.contestsMu.Lock()
+	defer s.contestsMu.Unlock()
+	c, ok := s.contests[id]
+	return c, ok
+}
+
+// contestList Returns the open contests, by id
+func (s *Server) contestList() []*contest {
+	s.contestsMu.Lock()
+	defer s.contestsMu.Unlock()
+	contests := make([]*contest, 0, len(s.contests))
+	for _, c := range s.contests {
+		contests = append(contests, c)
+	}
+	sort.Slice(contests, func(i, j int) bool { return contests[i].id < contests[j].id })
+	return contests
+}
+
+// closeContests Closes every contest. No contest can be opened afterwards
+func (s *Server) closeContests() {
+	s.contestsMu.Lock()
+	defer s.contestsMu.Unlock()
+	for _, c := range s.contests {
+		c.close()
+	}
+	s.contests = nil
+}
+
+// buildRoster Returns the agencies of the roster, 1 to Agencies if not given
+func buildRoster(config ServerConfig) ([]uint8, error) {
+	if len(config.Roster) == 0 {
+		if config.Agencies < 1 || config.Agencies > math.MaxUint8 {
+			return nil, errors.Errorf("invalid amount of agencies %d", config.Agencies)
+		}
+		roster := make([]uint8, config.Agencies)
+		for i := range roster {
+			roster[i] = uint8(i + 1)
+		}
+		return roster, nil
+	}
+	seen := make(map[uint8]bool, len(config.Roster))
+	for _, agency := range config.Roster {
+		if seen[agency] {
+			return nil, errors.Errorf("agency %d is twice in the roster", agency)
+		}
+		seen[agency] = true
+	}
+	return config.Roster, nil
+}
+
+// openStore Opens the storage engine of the configuration
+func openStore(config ServerConfig) (BetStore, error) {
+	switch config.StorageEngine {
+	case "", "log":
+		return OpenLogStore(config.StoragePath, config.Storage)
+	case "csv":
+		return OpenCSVStore(config.StoragePath)
+	}
+	return nil, errors.Errorf("unknown storage engine %q", config.StorageEngine)
+}
+
+// Addr Address the server is listening on
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// AdminAddr Address the admin API is listening on, empty if disabled
+func (s *Server) AdminAddr() string {
+	if s.adminLn == nil {
+		return ""
+	}
+	return s.adminLn.Addr().String()
+}
+
+// CommitStats Returns how many writes were made to the bet storage of every
+// contest and how many batches they coalesced
+func (s *Server) CommitStats() CommitStats {
+	var total CommitStats
+	for _, c := range s.contestList() {
+		stats := c.committer.stats()
+		total.Commits += stats.Commits
+		total.Batches += stats.Batches
+		total.Bets += stats.Bets
+	}
+	return total
+}
+
+// Run Accepts connections until Shutdown is called, then waits for the open
+// connections to be closed
+func (s *Server) Run() error {
+	defer s.closeContests()
+	log.Infof("action: roster | result: success | agencias: %v | quorum: %v | deadline: %v | concurso: %v",
+		s.config.Roster,
+		s.config.Quorum,
+		s.config.Deadline,
+		s.config.Contest,
+	)
+	log.Infof("action: signing_key | result: success | public_key: %x", s.config.SigningKey.Public())
+	if s.adminLn != nil {
+		log.Infof("action: admin_api | result: success | address: %v", s.adminLn.Addr())
+		go func() {
+			if err := s.admin.Serve(s.adminLn); err != nil && err != http.ErrServerClosed {
+				log.Errorf("action: admin_api | result: fail | error: %v", err)
+			}
+		}()
+	}
+	for {
+		log.Debugf("action: accept_connections | result: in_progress")
+		conn, err := s.listener.Accept()
+		if err != nil {
+			select {
+			case <-s.quit:
+				s.wg.Wait()
+				return nil
+			default:
+			}
+			log.Errorf("action: accept_connections | result: fail | error: %v", err)
+			s.Shutdown()
+			s.wg.Wait()
+			return err
+		}
+		log.Infof("action: accept_connections | result: success | ip: %v", conn.RemoteAddr())
+
+		if !s.track(conn) {
+			conn.Close()
+			continue
+		}
+		s.wg.Add(1)
+		go s.handleConnection(conn)
+	}
+}
+
+// Shutdown Stops accepting connections and closes the open ones. Safe to call
+// more than once and from any goroutine
+func (s *Server) Shutdown() {
+	s.quitOnce.Do(func() {
+		close(s.quit)
+		s.listener.Close()
+		if s.adminLn != nil {
+			s.admin.Shutdown(context.Background())
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for conn := range s.conns {
+			conn.Close()
+		}
+	})
+}
+
+// track Registers an open connection so Shutdown closes it. Returns false if
+// the server is already stopped
+func (s *Server) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case <-s.quit:
+		return false
+	default:
+	}
+	s.conns[conn] = struct{}{}
+	return true
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, conn)
+	conn.Close()
+}
+
+// handleConnection Serves the requests of an agency until it closes the
+// connection. The connection belongs to the agency of its first request, and
+// requests on behalf of any other agency are refused so a misconfigured
+// client cannot see or change the bets of another. The agency is not
+// authenticated, so this is no access control: a client that claims another
+// agency in its first request is served as that agency. Any protocol error
+// closes the connection too
+func (s *Server) handleConnection(conn net.Conn) {
+	defer s.wg.Done()
+	defer s.untrack(conn)
+
+	identified := false
+	var agency uint8
+	// Contests the agency sent requests for on this connection
+	contests := make(map[string]bool)
+	defer func() {
+		for id := range contests {
+			s.disconnect(id, agency)
+		}
+	}()
+
+	for {
+		msg, err := protocol.ReadMessage(conn)
+		if err != nil {
+			if err != io.EOF && !s.stopped() {
+				log.Errorf("action: receive_message | result: fail | ip: %v | error: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		if !identified {
+			agency, _, identified = requestOf(msg)
+		}
+		if other, id, ok := requestOf(msg); ok && other == agency {
+			if c, found := s.lookupContest(id); found && !contests[c.id] {
+				contests[c.id] = true
+				s.connect(c.id, agency)
+			}
+		}
+
+		var response protocol.Message
+		if other, _, ok := requestOf(msg); ok && other != agency {
+			log.Errorf("action: %v | result: fail | ip: %v | agencia: %v | error: connection of agency %v", msg.Type(), conn.RemoteAddr(), other, agency)
+			response = &protocol.Ack{Status: protocol.StatusFail}
+		} else {
+			response, err = s.handle(conn, msg)
+		}
+		if err != nil {
+			log.Errorf("action: %v | result: fail | ip: %v | error: %v", msg.Type(), conn.RemoteAddr(), err)
+			return
+		}
+		if err := protocol.WriteMessage(conn, response); err != nil {
+			if !s.stopped() {
+				log.Errorf("action: send_message | result: fail | ip: %v | error: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+	}
+}
+
+// agencyKey An agency taking part in a contest
+type agencyKey struct {
+	contest string
+	agency  uint8
+}
+
+func (s *Server) connect(contest string, agency uint8) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connected[agencyKey{contest: contest, agency: agency}]++
+}
+
+func (s *Server) disconnect(contest string, agency uint8) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := agencyKey{contest: contest, agency: agency}
+	if s.connected[key]--; s.connected[key] <= 0 {
+		delete(s.connected, key)
+	}
+}
+
+// isConnected Whether the agency has an open connection on which it sent
+// requests for the contest
+func (s *Server) isConnected(contest string, agency uint8) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connected[agencyKey{contest: contest, agency: agency}] > 0
+}
+
+// requestOf Returns the agency that sent a request and the contest it refers
+// to. False if msg is not a request
+func requestOf(msg protocol.Message) (uint8, string, bool) {
+	switch m := msg.(type) {
+	case *protocol.BetBatch:
+		return m.Agency, m.Contest, true
+	case *protocol.EndOfBets:
+		return m.Agency, m.Contest, true
+	case *protocol.WinnersQuery:
+		return m.Agency, m.Contest, true
+	case *protocol.AwaitWinners:
+		return m.Agency, m.Contest, true
+	case *protocol.CommitmentQuery:
+		return m.Agency, m.Contest, true
+	case *protocol.ResumeQuery:
+		return m.Agency, m.Contest, true
+	case *protocol.CancelBet:
+		return m.Agency, m.Contest, true
+	case *protocol.BetStatusQuery:
+		return m.Agency, m.Contest, true
+	}
+	return 0, "", false
+}
+
+func (s *Server) stopped() bool {
+	select {
+	case <-s.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// handle Returns the response to a request received on conn, served by the
+// contest it refers to. An error closes the connection
+func (s *Server) handle(conn net.Conn, msg protocol.Message) (protocol.Message, error) {
+	agency, id, ok := requestOf(msg)
+	if !ok {
+		return nil, errors.Errorf("unexpected message %v", msg.Type())
+	}
+	c, err := s.contest(id)
+	if err != nil {
+		log.Errorf("action: %v | result: fail | agencia: %v | concurso: %q | error: %v", msg.Type(), agency, id, err)
+		return rejection(err)
+	}
+	l := c.lottery
+
+	switch m := msg.(type) {
+	case *protocol.BetBatch:
+		return s.handleBets(l, m)
+	case *protocol.EndOfBets:
+		return s.handleEndOfBets(l, m)
+	case *protocol.WinnersQuery:
+		winners, ok, err := l.winnersOf(m.Agency)
+		if err != nil {
+			return rejection(err)
+		}
+		if !ok {
+			return &protocol.Ack{Status: protocol.StatusDrawNotReady}, nil
+		}
+		return winners, nil
+	case *protocol.AwaitWinners:
+		return s.awaitWinners(conn, l, m)
+	case *protocol.CommitmentQuery:
+		return &protocol.Commitment{Hash: l.commitment}, nil
+	case *protocol.ResumeQuery:
+		resume, err := l.resume(m.Agency)
+		if err != nil {
+			return rejection(err)
+		}
+		log.Infof("action: reanudar | result: success | agencia: %v | secuencia: %v | finalizada: %v | concurso: %v", m.Agency, resume.Sequence, resume.Finished, l.contest)
+		return resume, nil
+	case *protocol.CancelBet:
+		return s.handleCancelBet(l, m)
+	case *protocol.BetStatusQuery:
+		return s.handleBetStatus(l, m)
+	}
+	return nil, errors.Errorf("unexpected message %v", msg.Type())
+}
+
+func (s *Server) handleBets(l *lottery, batch *protocol.BetBatch) (protocol.Message, error) {
+	bets := make([]StoredBet, len(batch.Bets))
+	for i, bet := range batch.Bets {
+		if err := l.validate(bet); err != nil {
+			log.Errorf("action: apuesta_recibida | result: fail | agencia: %v | cantidad: %v | error: bet %d: %v", batch.Agency, len(bets), i, err)
+			return &protocol.Ack{Status: protocol.StatusFail}, nil
+		}
+		bets[i] = StoredBet{Agency: batch.Agency, Sequence: batch.Sequence, Bet: bet}
+	}
+	duplicate, err := l.storeBets(batch.Agency, batch.Sequence, bets)
+	if err != nil {
+		log.Errorf("action: apuesta_recibida | result: fail | agencia: %v | cantidad: %v | error: %v", batch.Agency, len(bets), err)
+		return rejection(err)
+	}
+	if duplicate {
+		// Answered with the ids the batch got when it was stored
+		id, err := l.batchID(batch.Agency, batch.Sequence)
+		if err != nil {
+			return nil, err
+		}
+		log.Infof("action: apuesta_duplicada | result: success | agencia: %v | secuencia: %v", batch.Agency, batch.Sequence)
+		return &protocol.Ack{Status: protocol.StatusOK, BetID: id}, nil
+	}
+
+	if len(bets) == 1 {
+		log.Infof("action: apuesta_almacenada | result: success | dni: %v | numero: %v | id: %v", bets[0].Document, protocol.FormatNumbers(bets[0].Bet), bets[0].ID)
+	} else {
+		log.Infof("action: apuesta_recibida | result: success | cantidad: %v", len(bets))
+	}
+	var id uint32
+	if len(bets) > 0 {
+		id = bets[0].ID
+	}
+	return &protocol.Ack{Status: protocol.StatusOK, BetID: id}, nil
+}
+
+func (s *Server) handleCancelBet(l *lottery, m *protocol.CancelBet) (protocol.Message, error) {
+	id, err := l.cancel(m)
+	if err == ErrBetNotFound {
+		log.Errorf("action: anular_apuesta | result: fail | agencia: %v | id: %v | dni: %v | concurso: %v | error: %v", m.Agency, m.BetID, m.Document, l.contest, err)
+		return &protocol.Ack{Status: protocol.StatusNotFound}, nil
+	}
+	if err != nil {
+		log.Errorf("action: anular_apuesta | result: fail | agencia: %v | id: %v | dni: %v | concurso: %v | error: %v", m.Agency, m.BetID, m.Document, l.contest, err)
+		switch errors.Cause(err) {
+		case ErrAmbiguousBet, ErrCancelUnsupported:
+			return &protocol.Ack{Status: protocol.StatusFail}, nil
+		}
+		return rejection(err)
+	}
+	log.Infof("action: anular_apuesta | result: success | agencia: %v | id: %v | concurso: %v", m.Agency, id, l.contest)
+	return &protocol.Ack{Status: protocol.StatusOK, BetID: id}, nil
+}
+
+func (s *Server) handleEndOfBets(l *lottery, m *protocol.EndOfBets) (protocol.Message, error) {
+	if err := l.finish(m.Agency); err != nil {
+		log.Errorf("action: fin_apuestas | result: fail | agencia: %v | concurso: %v | error: %v", m.Agency, l.contest, err)
+		return rejection(err)
+	}
+	log.Infof("action: fin_apuestas | result: success | agencia: %v | concurso: %v", m.Agency, l.contest)
+	return &protocol.Ack{Status: protocol.StatusOK}, nil
+}
+
+// handleBetStatus Answers with the bets of the document stored by the agency
+// that asks, never those of other agencies
+func (s *Server) handleBetStatus(l *lottery, m *protocol.BetStatusQuery) (protocol.Message, error) {
+	states, err := l.betsOf(m.Agency, m.Document)
+	if err != nil {
+		log.Errorf("action: consulta_apuestas | result: fail | agencia: %v | dni: %v | concurso: %v | error: %v", m.Agency, m.Document, l.contest, err)
+		return rejection(err)
+	}
+	response := &protocol.BetStatuses{Bets: states}
+	if len(states) > protocol.MaxBatchAmount {
+		response.Bets = states[:protocol.MaxBatchAmount]
+		response.More = true
+	}
+	// Long names may not fit in a frame, the last bets are left out
+	for len(response.Bets) > 0 {
+		if _, err := protocol.Encode(response); errors.Cause(err) != protocol.ErrFrameTooLarge {
+			break
+		}
+		response.Bets = response.Bets[:len(response.Bets)-1]
+		response.More = true
+	}
+	log.Infof("action: consulta_apuestas | result: success | agencia: %v | dni: %v | apuestas: %v | concurso: %v", m.Agency, m.Document, len(states), l.contest)
+	return response, nil
+}
+
+// rejection Returns the Ack telling the agency why its request was refused.
+// Unexpected errors close the connection
+func rejection(err error) (protocol.Message, error) {
+	switch err {
+	case ErrContestClosed:
+		return &protocol.Ack{Status: protocol.StatusContestClosed}, nil
+	case ErrUnknownAgency, ErrInvalidContest, ErrUnknownContest:
+		return &protocol.Ack{Status: protocol.StatusFail}, nil
+	}
+	return nil, err
+}
+
+// awaitWinners Holds the request until the draw happens, then pushes the
+// winners of the agency. The connection is closed if the server stops or the
+// agency stops waiting first, as it does after its PushTimeout
+func (s *Server) awaitWinners(conn net.Conn, l *lottery, m *protocol.AwaitWinners) (protocol.Message, error) {
+	closed, stop := watchClose(conn)
+	var err error
+	select {
+	case <-l.drawn:
+	case <-closed:
+	case <-s.quit:
+		err = errors.New("server shutdown while waiting for the draw")
+	}
+	if !stop() && err == nil {
+		err = errors.New("agency stopped waiting for the draw")
+	}
+	if err != nil {
+		return nil, err
+	}
+	winners, _, err := l.winnersOf(m.Agency)
+	if err != nil {
+		return rejection(err)
+	}
+	log.Infof("action: enviar_ganadores | result: success | agencia: %v | cant_ganadores: %v", m.Agency, len(winners.Documents))
+	return winners, nil
+}
+
+// watchClose Reads conn while a request is held, closing the returned channel
+// once the agency closes the connection or sends anything, since it only
+// does so after it stopped waiting. stop ends the watch, leaving conn ready to
+// be read again, and returns false if the channel was closed
+func watchClose(conn net.Conn) (<-chan struct{}, func() bool) {
+	closed := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var b [1]byte
+		n, err := conn.Read(b[:])
+		if ne, ok := err.(net.Error); n > 0 || !ok || !ne.Timeout() {
+			close(closed)
+		}
+	}()
+	stop := func() bool {
+		conn.SetReadDeadline(time.Now())
+		<-done
+		conn.SetReadDeadline(time.Time{})
+		select {
+		case <-closed:
+			return false
+		default:
+			return true
+		}
+	}
+	return closed, stop
+}
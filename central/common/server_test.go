@@ -0,0 +1,443 @@
+package common
+
+import (
+	"crypto/ed25519"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	client "github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/dataset"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// testSeed Seed of the central in the tests
+var testSeed = func() []byte {
+	seed := make([]byte, protocol.SeedSize)
+	for i := range seed {
+		seed[i] = byte(i)
+	}
+	return seed
+}()
+
+// testNumber Winning number of the default contest of the tests
+var testNumber = protocol.WinningNumber(contestSeed(testSeed, "1"))
+
+// defaultContest Returns the default contest of the server
+func defaultContest(server *Server) *contest {
+	c, _ := server.lookupContest("")
+	return c
+}
+
+// testKey Key the winners of the tests are signed with
+var testKey = ed25519.NewKeyFromSeed(testSeed)
+
+// startServer Starts a central on a random port that stops when the test ends
+func startServer(t *testing.T, agencies int) *Server {
+	t.Helper()
+	return startServerWith(t, ServerConfig{Agencies: agencies})
+}
+
+// startServerWith Starts a central with the test seed, key and storage
+// added to config
+func startServerWith(t *testing.T, config ServerConfig) *Server {
+	t.Helper()
+	config.ListenAddress = "127.0.0.1:0"
+	config.Seed = testSeed
+	config.Contest = "1"
+	config.SigningKey = testKey
+	if config.StoragePath == "" {
+		config.StoragePath = filepath.Join(t.TempDir(), "bets.log")
+	}
+	server, err := NewServer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- server.Run() }()
+	t.Cleanup(func() {
+		server.Shutdown()
+		if err := <-done; err != nil {
+			t.Errorf("Run: %v", err)
+		}
+	})
+	return server
+}
+
+// writeDataset Writes an agency file with n bets. Every third bet plays the
+// winning number
+func writeDataset(t *testing.T, agency int, n int) string {
+	t.Helper()
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		number := i
+		if i%3 == 0 {
+			number = int(testNumber)
+		}
+		fmt.Fprintf(&b, "Nombre,Apellido,%d,1999-03-17,%d\n", agency*1000000+i, number)
+	}
+	path := filepath.Join(t.TempDir(), fmt.Sprintf("agency-%d.csv", agency))
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func clientConfig(server *Server) client.ClientConfig {
+	return client.ClientConfig{
+		ServerAddress:  server.Addr(),
+		LoopAmount:     50,
+		LoopPeriod:     10 * time.Millisecond,
+		BatchMaxAmount: 4,
+		Timeout:        time.Second,
+		MaxRetries:     1,
+		RetryPeriod:    time.Millisecond,
+		PushTimeout:    5 * time.Second,
+		VerifyDraw:     true,
+		Contest:        "1",
+		PublicKey:      testKey.Public().(ed25519.PublicKey),
+	}
+}
+
+func runAgencies(t *testing.T, config client.ClientConfig, bets ...int) []client.AgencyResult {
+	t.Helper()
+	for i, n := range bets {
+		config.Agencies = append(config.Agencies, client.AgencyConfig{
+			ID:          fmt.Sprint(i + 1),
+			DatasetPath: writeDataset(t, i+1, n),
+		})
+	}
+	results, err := client.NewMultiClient(config).Run()
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	return results
+}
+
+func TestServerDrawsOnceEveryAgencyFinished(t *testing.T) {
+	server := startServer(t, 3)
+	results := runAgencies(t, clientConfig(server), 10, 3, 7)
+
+	for i, want := range []int{4, 1, 3} {
+		if got := results[i].Metrics.Winners; got != want {
+			t.Errorf("agency %d got %d winners, want %d", i+1, got, want)
+		}
+	}
+	if _, drawn, _ := defaultContest(server).lottery.winnersOf(1); !drawn {
+		t.Error("draw did not happen")
+	}
+}
+
+func TestServerPushesWinnersWhenDrawCompletes(t *testing.T) {
+	server := startServer(t, 2)
+	config := clientConfig(server)
+	config.PushWinners = true
+	config.LoopAmount = 1
+	config.Stagger = 100 * time.Millisecond
+
+	results := runAgencies(t, config, 6, 3)
+	for i, want := range []int{2, 1} {
+		if got := results[i].Metrics.Winners; got != want {
+			t.Errorf("agency %d got %d winners, want %d", i+1, got, want)
+		}
+		if results[i].Metrics.Errors != 0 {
+			t.Errorf("agency %d fell back to polling", i+1)
+		}
+	}
+}
+
+func TestServerReleasesAgenciesThatStopWaitingForTheDraw(t *testing.T) {
+	server := startServer(t, 2)
+	conn, err := net.Dial("tcp", server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := protocol.WriteMessage(conn, &protocol.AwaitWinners{Agency: 1, Contest: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	open := func() int {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		return len(server.conns)
+	}
+	for deadline := time.Now().Add(time.Second); open() == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("connection was never served")
+		}
+	}
+
+	conn.Close()
+	for deadline := time.Now().Add(time.Second); open() != 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("connection still held after the agency closed it")
+		}
+	}
+}
+
+func TestServerStoresEverySingleBetOfAnAgency(t *testing.T) {
+	server := startServer(t, 1)
+	config := clientConfig(server)
+	config.ID = "1"
+	for i, document := range []string{"30904465", "30904466"} {
+		row := dataset.Row{FirstName: "Nombre", LastName: "Apellido", Document: document, Birthdate: "1999-03-17", Number: fmt.Sprint(7574 + i)}
+		if err := client.NewClient(config).SendBet(row); err != nil {
+			t.Fatalf("SendBet %v: %v", document, err)
+		}
+	}
+	bets := loadAgency(t, defaultContest(server).lottery.store, 1)
+	if len(bets) != 2 || bets[0].Sequence != 1 || bets[1].Sequence != 2 {
+		t.Errorf("stored %+v, want two bets with sequences 1 and 2", bets)
+	}
+}
+
+func TestServerSignsWinnersOfEachAgency(t *testing.T) {
+	server := startServer(t, 2)
+	config := clientConfig(server)
+	config.ReportFormat = client.ReportJSON
+	dir := t.TempDir()
+	for i, n := range []int{4, 2} {
+		id := fmt.Sprint(i + 1)
+		config.Agencies = append(config.Agencies, client.AgencyConfig{
+			ID:          id,
+			DatasetPath: writeDataset(t, i+1, n),
+			ReportPath:  filepath.Join(dir, "winners-"+id+".json"),
+		})
+	}
+	if _, err := client.NewMultiClient(config).Run(); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	signature, err := os.ReadFile(filepath.Join(dir, "winners-1.json.sig"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	winners, _, _ := defaultContest(server).lottery.winnersOf(1)
+	winners.Signature, err = hex.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := protocol.VerifyWinners(config.PublicKey, "1", 1, winners); err != nil {
+		t.Errorf("stored signature: %v", err)
+	}
+	if err := protocol.VerifyWinners(config.PublicKey, "1", 2, winners); err == nil {
+		t.Error("signature of agency 1 is valid for agency 2")
+	}
+
+	// An agency configured for another contest refuses the results
+	other := clientConfig(server)
+	other.Contest = "2"
+	other.Agencies = []client.AgencyConfig{{ID: "1", DatasetPath: writeDataset(t, 1, 0)}}
+	if _, err := client.NewMultiClient(other).Run(); err == nil {
+		t.Error("winners of contest 1 accepted as contest 2")
+	}
+}
+
+func TestServerAnswersDrawNotReadyBeforeDraw(t *testing.T) {
+	server := startServer(t, 2)
+	response, err := server.handle(nil, &protocol.WinnersQuery{Agency: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ack, ok := response.(*protocol.Ack); !ok || ack.Status != protocol.StatusDrawNotReady {
+		t.Errorf("got %+v before the draw", response)
+	}
+
+	if _, err := server.handle(nil, &protocol.EndOfBets{Agency: 1}); err != nil {
+		t.Fatal(err)
+	}
+	response, _ = server.handle(nil, &protocol.BetBatch{Agency: 1, Bets: []protocol.Bet{{Birthdate: "1999-03-17"}}})
+	if ack, ok := response.(*protocol.Ack); !ok || ack.Status != protocol.StatusContestClosed {
+		t.Errorf("bets accepted after the agency finished: %+v", response)
+	}
+}
+
+func ackStatus(t *testing.T, server *Server, msg protocol.Message) protocol.Status {
+	t.Helper()
+	response, err := server.handle(nil, msg)
+	if err != nil {
+		t.Fatalf("%v: %v", msg.Type(), err)
+	}
+	ack, ok := response.(*protocol.Ack)
+	if !ok {
+		t.Fatalf("%v answered with %v", msg.Type(), response.Type())
+	}
+	return ack.Status
+}
+
+func waitDraw(t *testing.T, server *Server) {
+	t.Helper()
+	waitDrawOf(t, server, "")
+}
+
+func waitDrawOf(t *testing.T, server *Server, contest string) {
+	t.Helper()
+	c, ok := server.lookupContest(contest)
+	if !ok {
+		t.Fatalf("contest %q is not open", contest)
+	}
+	select {
+	case <-c.lottery.drawn:
+	case <-time.After(5 * time.Second):
+		t.Fatal("draw did not happen")
+	}
+}
+
+func TestServerRefusesAgenciesOutsideTheRoster(t *testing.T) {
+	server := startServerWith(t, ServerConfig{Roster: []uint8{2, 4}})
+	bets := &protocol.BetBatch{Agency: 1, Bets: []protocol.Bet{{Birthdate: "1999-03-17"}}}
+	if status := ackStatus(t, server, bets); status != protocol.StatusFail {
+		t.Errorf("bets of agency 1 answered with %v", status)
+	}
+	if status := ackStatus(t, server, &protocol.EndOfBets{Agency: 1}); status != protocol.StatusFail {
+		t.Errorf("end of bets of agency 1 answered with %v", status)
+	}
+
+	ackStatus(t, server, &protocol.EndOfBets{Agency: 2})
+	ackStatus(t, server, &protocol.EndOfBets{Agency: 4})
+	waitDraw(t, server)
+}
+
+func TestServerDrawsWithQuorumAfterDeadline(t *testing.T) {
+	server := startServerWith(t, ServerConfig{Agencies: 3, Quorum: 2, Deadline: 100 * time.Millisecond})
+	config := clientConfig(server)
+	results := runAgencies(t, config, 10, 3)
+	for _, result := range results {
+		if result.Err != nil {
+			t.Fatalf("agency %v: %v", result.ID, result.Err)
+		}
+	}
+	waitDraw(t, server)
+
+	// The late agency is told the contest is closed at every step
+	bets := &protocol.BetBatch{Agency: 3, Bets: []protocol.Bet{{Birthdate: "1999-03-17"}}}
+	for _, msg := range []protocol.Message{bets, &protocol.EndOfBets{Agency: 3}, &protocol.WinnersQuery{Agency: 3}} {
+		if status := ackStatus(t, server, msg); status != protocol.StatusContestClosed {
+			t.Errorf("%v of the late agency answered with %v", msg.Type(), status)
+		}
+	}
+	config.Agencies = []client.AgencyConfig{{ID: "3", DatasetPath: writeDataset(t, 3, 5)}}
+	late, _ := client.NewMultiClient(config).Run()
+	if late[0].Err != client.ErrContestClosed {
+		t.Errorf("late agency got %v, want %v", late[0].Err, client.ErrContestClosed)
+	}
+
+	// A retry of an agency that finished in time is still accepted
+	if status := ackStatus(t, server, &protocol.EndOfBets{Agency: 1}); status != protocol.StatusOK {
+		t.Errorf("end of bets retry answered with %v", status)
+	}
+}
+
+func TestServerWaitsForQuorumAfterDeadline(t *testing.T) {
+	server := startServerWith(t, ServerConfig{Agencies: 3, Quorum: 2, Deadline: 20 * time.Millisecond})
+	ackStatus(t, server, &protocol.EndOfBets{Agency: 1})
+	time.Sleep(100 * time.Millisecond)
+	if status := ackStatus(t, server, &protocol.WinnersQuery{Agency: 1}); status != protocol.StatusDrawNotReady {
+		t.Fatalf("draw happened without quorum: %v", status)
+	}
+
+	ackStatus(t, server, &protocol.EndOfBets{Agency: 2})
+	waitDraw(t, server)
+}
+
+func TestServerWaitsForEveryAgencyWithoutDeadline(t *testing.T) {
+	server := startServerWith(t, ServerConfig{Agencies: 3, Quorum: 1})
+	ackStatus(t, server, &protocol.EndOfBets{Agency: 1})
+	ackStatus(t, server, &protocol.EndOfBets{Agency: 2})
+	if status := ackStatus(t, server, &protocol.WinnersQuery{Agency: 1}); status != protocol.StatusDrawNotReady {
+		t.Fatalf("draw happened before every agency finished: %v", status)
+	}
+	ackStatus(t, server, &protocol.EndOfBets{Agency: 3})
+	waitDraw(t, server)
+}
+
+func TestNewServerValidatesRoster(t *testing.T) {
+	for _, config := range []ServerConfig{
+		{Agencies: 0},
+		{Agencies: 256},
+		{Roster: []uint8{1, 2, 1}},
+		{Agencies: 3, Quorum: 4},
+		{Agencies: 3, Quorum: -1},
+	} {
+		config.ListenAddress = "127.0.0.1:0"
+		config.StoragePath = filepath.Join(t.TempDir(), "bets.log")
+		if server, err := NewServer(config); err == nil {
+			server.Shutdown()
+			t.Errorf("accepted %+v", config)
+		}
+	}
+}
+
+func TestCSVStoreKeepsBetsAcrossReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bets.csv")
+	bets := []StoredBet{
+		{Agency: 1, ID: 1, Bet: protocol.Bet{FirstName: "Santiago Lionel", LastName: "Lorca", Document: 30904465, Birthdate: "1999-03-17", Number: 7574}},
+		{Agency: 1, ID: 2, Bet: protocol.Bet{FirstName: "Tiago, Nicolás", LastName: "Rivera", Document: 34407251, Birthdate: "2001-08-29", Number: 1033}},
+	}
+	for _, bet := range bets {
+		store, err := OpenCSVStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Store([]StoredBet{bet}); err != nil {
+			t.Fatal(err)
+		}
+		store.Close()
+	}
+
+	store, err := OpenCSVStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	var loaded []StoredBet
+	store.Load(func(bet StoredBet) error {
+		loaded = append(loaded, bet)
+		return nil
+	})
+	if fmt.Sprint(loaded) != fmt.Sprint(bets) {
+		t.Errorf("loaded %+v, want %+v", loaded, bets)
+	}
+}
+
+func TestCSVStoreKeepsIdsWhenAWriteFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bets.csv")
+	store, err := OpenCSVStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	bet := StoredBet{Agency: 1, Bet: protocol.Bet{FirstName: "Santiago Lionel", LastName: "Lorca", Document: 30904465, Birthdate: "1999-03-17", Number: 7574}}
+	if err := store.Store([]StoredBet{bet}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Writes fail on a file opened read-only
+	writable := store.file
+	if store.file, err = os.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	store.writer = csv.NewWriter(store.file)
+	if err := store.Store([]StoredBet{bet, bet}); err == nil {
+		t.Fatal("write to a read-only file succeeded")
+	}
+	store.file.Close()
+	store.file = writable
+	store.writer = csv.NewWriter(writable)
+
+	stored := []StoredBet{bet}
+	if err := store.Store(stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored[0].ID != 2 {
+		t.Errorf("bet stored with id %d after a failed write, want 2", stored[0].ID)
+	}
+	if loaded := loadAgency(t, store, 1); len(loaded) != 2 || loaded[1].ID != 2 {
+		t.Errorf("loaded %+v, want 2 bets", loaded)
+	}
+}
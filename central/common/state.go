@@ -0,0 +1,103 @@
+package common
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// contestState Durable state of the contest, saved to ServerConfig.StatePath
+// when it opens, whenever an agency finishes and when the draw happens, so a
+// central that restarts mid-contest continues where it left off. The bets
+// and their batch sequences are persisted by the BetStore; the sequences
+// saved here are only a snapshot
+type contestState struct {
+	Contest string `json:"contest"`
+	// Seed Hex encoded secret of the draw. The commitment published before
+	// the restart must still hold after it
+	Seed          string           `json:"seed"`
+	WinningNumber uint16           `json:"winning_number"`
+	OpenedAt      time.Time        `json:"opened_at"`
+	Finished      []int            `json:"finished"`
+	Sequences     map[uint8]uint32 `json:"sequences"`
+	Closed        bool             `json:"closed"`
+	DrawnAt       *time.Time       `json:"drawn_at,omitempty"`
+	// Tiers Prize tiers the contest was opened with. Missing in the state of
+	// contests opened before tiers existed, which use the configured ones
+	Tiers []PrizeTier `json:"tiers,omitempty"`
+	// HouseCut Cut of the house the contest was opened with, in basis points.
+	// Missing in the state of contests opened before stakes existed
+	HouseCut *uint16 `json:"house_cut,omitempty"`
+}
+
+// loadState Reads the state saved at path. Returns nil if there is none
+func loadState(path string) (*contestState, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := &contestState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, errors.Wrapf(err, "could not parse %s", path)
+	}
+	return state, nil
+}
+
+// seed Returns the decoded seed of the draw
+func (s *contestState) seed() ([]byte, error) {
+	seed, err := hex.DecodeString(s.Seed)
+	if err != nil || len(seed) == 0 {
+		return nil, errors.Errorf("invalid seed %q in contest state", s.Seed)
+	}
+	return seed, nil
+}
+
+// save Replaces the state at path. It is written to a temporary file that is
+// renamed over the previous one, so a crash leaves either of them complete
+func (s *contestState) save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	// Only the central may read the seed before the draw
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(append(data, '\n'))
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir Makes a rename in the directory durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
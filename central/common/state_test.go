@@ -0,0 +1,296 @@
+package common
+
+import (
+	"crypto/ed25519"
+	"os"
+	"path/filepath"
+	"testing"
+
+	client "github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// openServer Creates a central that is driven through handle, without
+// listening, and stops it when the test ends unless stopped before
+func openServer(t *testing.T, config ServerConfig) (*Server, func()) {
+	t.Helper()
+	config.ListenAddress = "127.0.0.1:0"
+	config.Seed = testSeed
+	config.Contest = "1"
+	config.SigningKey = testKey
+	server, err := NewServer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopped := false
+	stop := func() {
+		if stopped {
+			return
+		}
+		stopped = true
+		server.Shutdown()
+		server.closeContests()
+	}
+	t.Cleanup(stop)
+	return server, stop
+}
+
+func restartConfig(t *testing.T) ServerConfig {
+	dir := t.TempDir()
+	return ServerConfig{
+		Agencies:    3,
+		StoragePath: filepath.Join(dir, "bets.log"),
+		StatePath:   filepath.Join(dir, "contest.json"),
+	}
+}
+
+func sequencedBatch(agency uint8, sequence uint32, n int) *protocol.BetBatch {
+	batch := &protocol.BetBatch{Agency: agency, Sequence: sequence}
+	for i := 0; i < n; i++ {
+		batch.Bets = append(batch.Bets, protocol.Bet{
+			FirstName: "Nombre",
+			LastName:  "Apellido",
+			Document:  uint64(agency)*1000000 + uint64(sequence)*1000 + uint64(i),
+			Birthdate: "1999-03-17",
+			Number:    testNumber,
+		})
+	}
+	return batch
+}
+
+func resumeOf(t *testing.T, server *Server, agency uint8) *protocol.Resume {
+	t.Helper()
+	response, err := server.handle(nil, &protocol.ResumeQuery{Agency: agency})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resume, ok := response.(*protocol.Resume)
+	if !ok {
+		t.Fatalf("resume query answered with %v", describeMessage(response))
+	}
+	return resume
+}
+
+func describeMessage(msg protocol.Message) string {
+	if ack, ok := msg.(*protocol.Ack); ok {
+		return ack.Status.String()
+	}
+	return msg.Type().String()
+}
+
+func TestServerRestoresContestAfterRestart(t *testing.T) {
+	config := restartConfig(t)
+	server, stop := openServer(t, config)
+	commitment := defaultContest(server).lottery.commitment
+	ackStatus(t, server, sequencedBatch(1, 1, 2))
+	ackStatus(t, server, &protocol.EndOfBets{Agency: 1})
+	ackStatus(t, server, &protocol.EndOfBets{Agency: 2})
+	ackStatus(t, server, sequencedBatch(3, 1, 3))
+	ackStatus(t, server, sequencedBatch(3, 2, 3))
+	stop()
+
+	server, stop = openServer(t, config)
+	if defaultContest(server).lottery.commitment != commitment {
+		t.Fatal("commitment changed across the restart")
+	}
+	for agency, want := range map[uint8]protocol.Resume{
+		1: {Sequence: 1, Finished: true},
+		2: {Sequence: 0, Finished: true},
+		3: {Sequence: 2, Finished: false},
+	} {
+		if got := resumeOf(t, server, agency); *got != want {
+			t.Errorf("agency %v resumes at %+v, want %+v", agency, *got, want)
+		}
+	}
+
+	// A batch resent after the restart is not stored twice
+	ackStatus(t, server, sequencedBatch(3, 2, 3))
+	ackStatus(t, server, sequencedBatch(3, 3, 1))
+	if status := ackStatus(t, server, &protocol.EndOfBets{Agency: 3}); status != protocol.StatusOK {
+		t.Fatalf("end of bets answered with %v", status)
+	}
+	waitDraw(t, server)
+	winners, _, err := defaultContest(server).lottery.winnersOf(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(winners.Documents) != 7 {
+		t.Errorf("agency 3 has %v winners, want 7", len(winners.Documents))
+	}
+	drawnAt := winners.DrawnAt
+	stop()
+
+	// The draw is not made again
+	server, _ = openServer(t, config)
+	waitDraw(t, server)
+	winners, _, _ = defaultContest(server).lottery.winnersOf(3)
+	if winners.DrawnAt != drawnAt || len(winners.Documents) != 7 {
+		t.Errorf("restored draw at %v with %v winners, want %v with 7", winners.DrawnAt, len(winners.Documents), drawnAt)
+	}
+	if status := ackStatus(t, server, sequencedBatch(2, 1, 1)); status != protocol.StatusContestClosed {
+		t.Errorf("bets after the restored draw answered with %v", status)
+	}
+}
+
+func TestServerDoesNotStoreDuplicateSequences(t *testing.T) {
+	server, _ := openServer(t, restartConfig(t))
+	for i := 0; i < 3; i++ {
+		if status := ackStatus(t, server, sequencedBatch(1, 1, 4)); status != protocol.StatusOK {
+			t.Fatalf("batch answered with %v", status)
+		}
+	}
+	// Unsequenced batches are always stored
+	ackStatus(t, server, sequencedBatch(1, 0, 1))
+	ackStatus(t, server, sequencedBatch(1, 0, 1))
+	if count, _ := defaultContest(server).store.Count(1); count != 6 {
+		t.Errorf("stored %v bets, want 6", count)
+	}
+}
+
+func TestServerRefusesStateOfAnotherContest(t *testing.T) {
+	config := restartConfig(t)
+	_, stop := openServer(t, config)
+	stop()
+
+	// The state of contest 1 renamed as the one of contest 2
+	saved, err := os.ReadFile(contestPath(config.StatePath, "1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(contestPath(config.StatePath, "2"), saved, 0600); err != nil {
+		t.Fatal(err)
+	}
+	config.ListenAddress = "127.0.0.1:0"
+	if server, err := NewServer(config); err == nil {
+		server.Shutdown()
+		t.Error("opened the state of contest 1 as contest 2")
+	}
+	os.Remove(contestPath(config.StatePath, "2"))
+
+	config.Seed = []byte("another seed")
+	if server, err := NewServer(config); err == nil {
+		server.Shutdown()
+		t.Error("opened the saved contest with another seed")
+	}
+}
+
+func TestAgencyResumesAfterRestart(t *testing.T) {
+	config := restartConfig(t)
+	config.Agencies = 1
+	server := startServerWith(t, config)
+	clientConfig := clientConfig(server)
+	dataset := writeDataset(t, 1, 10)
+
+	// The agency stored its first two batches of 4 bets before crashing
+	var batches []*protocol.BetBatch
+	for sequence := uint32(1); sequence <= 2; sequence++ {
+		batch := &protocol.BetBatch{Agency: 1, Sequence: sequence}
+		for i := 0; i < 4; i++ {
+			n := int(sequence-1)*4 + i
+			number := uint16(n)
+			if n%3 == 0 {
+				number = testNumber
+			}
+			batch.Bets = append(batch.Bets, protocol.Bet{
+				FirstName: "Nombre",
+				LastName:  "Apellido",
+				Document:  uint64(1000000 + n),
+				Birthdate: "1999-03-17",
+				Number:    number,
+			})
+		}
+		batches = append(batches, batch)
+	}
+	for _, batch := range batches {
+		ackStatus(t, server, batch)
+	}
+
+	clientConfig.Agencies = []client.AgencyConfig{{ID: "1", DatasetPath: dataset}}
+	results, err := client.NewMultiClient(clientConfig).Run()
+	if err != nil || results[0].Err != nil {
+		t.Fatalf("Run: %v %v", err, results[0].Err)
+	}
+	metrics := results[0].Metrics
+	if metrics.Resumed != 8 || metrics.BetsSent != 2 || metrics.Winners != 4 {
+		t.Errorf("metrics %+v, want 8 resumed, 2 sent and 4 winners", metrics)
+	}
+	if count, _ := defaultContest(server).store.Count(1); count != 10 {
+		t.Errorf("stored %v bets, want 10", count)
+	}
+}
+
+func TestServerKeepsContestsApart(t *testing.T) {
+	config := restartConfig(t)
+	config.Contests = []string{"2026-42"}
+	server, stop := openServer(t, config)
+
+	// Agency 1 bets on both contests, every bet plays the number of contest 1
+	ackStatus(t, server, sequencedBatch(1, 1, 3))
+	weekly := sequencedBatch(1, 1, 2)
+	weekly.Contest = "2026-42"
+	weeklyNumber := protocol.WinningNumber(contestSeed(testSeed, "2026-42"))
+	weekly.Bets[0].Number = weeklyNumber
+	ackStatus(t, server, weekly)
+	for agency := uint8(1); agency <= 3; agency++ {
+		ackStatus(t, server, &protocol.EndOfBets{Agency: agency, Contest: "2026-42"})
+	}
+	waitDrawOf(t, server, "2026-42")
+
+	response, err := server.handle(nil, &protocol.WinnersQuery{Agency: 1, Contest: "2026-42"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	winners, ok := response.(*protocol.Winners)
+	if !ok {
+		t.Fatalf("winners query answered with %v", describeMessage(response))
+	}
+	if winners.Contest != "2026-42" || winners.WinningNumber != weeklyNumber || len(winners.Documents) != 1 || winners.Documents[0] != weekly.Bets[0].Document {
+		t.Errorf("got %+v", winners)
+	}
+	if err := protocol.VerifyWinners(testKey.Public().(ed25519.PublicKey), "2026-42", 1, winners); err != nil {
+		t.Errorf("signature: %v", err)
+	}
+
+	// The default contest is still open
+	if status := ackStatus(t, server, &protocol.WinnersQuery{Agency: 1}); status != protocol.StatusDrawNotReady {
+		t.Errorf("winners of contest 1 answered %v", status)
+	}
+	if status := ackStatus(t, server, sequencedBatch(1, 2, 1)); status != protocol.StatusOK {
+		t.Errorf("bets of contest 1 answered %v", status)
+	}
+	if status := ackStatus(t, server, &protocol.EndOfBets{Agency: 1, Contest: "../1"}); status != protocol.StatusFail {
+		t.Errorf("invalid contest answered %v", status)
+	}
+	// Requests never open contests
+	for _, request := range []protocol.Message{
+		&protocol.CommitmentQuery{Agency: 1, Contest: "2026-43"},
+		&protocol.WinnersQuery{Agency: 1, Contest: "2026-43"},
+		&protocol.BetStatusQuery{Agency: 1, Contest: "2026-43"},
+		&protocol.BetBatch{Agency: 1, Contest: "2026-43", Sequence: 1, Bets: weekly.Bets},
+	} {
+		if status := ackStatus(t, server, request); status != protocol.StatusFail {
+			t.Errorf("%v of a contest not open answered %v", request.Type(), status)
+		}
+	}
+	if _, ok := server.lookupContest("2026-43"); ok {
+		t.Error("contest 2026-43 was opened by a request")
+	}
+	if _, err := os.Stat(contestPath(config.StoragePath, "2026-43")); !os.IsNotExist(err) {
+		t.Errorf("bets of contest 2026-43: %v", err)
+	}
+
+	// Both contests are restored, even if no longer configured
+	stop()
+	config.Contests = nil
+	server, _ = openServer(t, config)
+	if resume := resumeOf(t, server, 1); resume.Sequence != 2 || resume.Finished {
+		t.Errorf("contest 1 resumed at %+v", resume)
+	}
+	weeklyContest, ok := server.lookupContest("2026-42")
+	if !ok {
+		t.Fatal("contest 2026-42 was not restored")
+	}
+	if status := weeklyContest.lottery.status(); status.Phase != PhaseDrawn {
+		t.Errorf("contest 2026-42 restored as %+v", status)
+	}
+}
@@ -0,0 +1,298 @@
+package common
+
+import (
+	"encoding/csv"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// ErrBetNotFound is returned when the agency has no bet to cancel with the
+// given id
+var ErrBetNotFound = errors.New("bet not found")
+
+// ErrCancelUnsupported is returned by stores that keep no record of
+// cancelled bets
+var ErrCancelUnsupported = errors.New("the store cannot cancel bets")
+
+// StoredBet A bet as persisted by the central, along with its agency, the
+// sequence of the batch it arrived in and its id
+type StoredBet struct {
+	Agency   uint8
+	Sequence uint32
+	// ID Position of the bet among those of its agency, from 1. Given by the
+	// store
+	ID uint32
+	// Cancelled Whether the agency cancelled the bet
+	Cancelled bool
+	protocol.Bet
+}
+
+// BetStore Persistence of the bets received by the central
+type BetStore interface {
+	// Store Persists the bets and sets their ids. They are durable once it
+	// returns
+	Store(bets []StoredBet) error
+	// Cancel Marks the bet of the agency as cancelled. Returns ErrBetNotFound
+	// if the agency has no bet with the id
+	Cancel(agency uint8, id uint32) error
+	// Load Calls fn with every stored bet, in the order they were stored
+	Load(fn func(bet StoredBet) error) error
+	// LoadAgency Calls fn with every stored bet of the agency
+	LoadAgency(agency uint8, fn func(bet StoredBet) error) error
+	// Sequences Returns the last batch sequence stored for every agency
+	Sequences() map[uint8]uint32
+	// Count Returns the amount of bets stored for the agency
+	Count(agency uint8) (int, error)
+	Close() error
+}
+
+// CSVStore Stores the bets in a CSV file with the format of the original
+// bets.csv: agency, first name, last name, document, birthdate and number,
+// followed by the stake of the bets that have one. The numbers of combined
+// bets are separated by protocol.NumbersSeparator.
+// Every lookup scans the whole file, and batch sequences are not persisted, so
+// they are lost on restart. Bets cannot be cancelled. LogStore should be
+// preferred
+type CSVStore struct {
+	path string
+
+	mu        sync.Mutex
+	file      *os.File
+	writer    *csv.Writer
+	sequences map[uint8]uint32
+	counts    map[uint8]int
+}
+
+// OpenCSVStore Opens the file at path, creating it if needed. Bets already
+// stored are kept
+func OpenCSVStore(path string) (*CSVStore, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &CSVStore{path: path, file: file, writer: csv.NewWriter(file), sequences: make(map[uint8]uint32), counts: make(map[uint8]int)}
+	// Ids follow the bets already in the file
+	err = s.Load(func(bet StoredBet) error {
+		s.counts[bet.Agency]++
+		return nil
+	})
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Store Appends the bets to the file. If the write fails the file and the
+// ids are left as they were. Safe to call from several goroutines
+func (s *CSVStore) Store(bets []StoredBet) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	added := make(map[uint8]int)
+	for i := range bets {
+		added[bets[i].Agency]++
+		bets[i].ID = uint32(s.counts[bets[i].Agency] + added[bets[i].Agency])
+	}
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+	if err := s.write(bets); err != nil {
+		// Rows written before the failure would take the ids of the next bets
+		// once the file is loaded again
+		if truncErr := s.file.Truncate(info.Size()); truncErr != nil {
+			log.Errorf("action: descartar_apuestas | result: fail | path: %v | error: %v", s.path, truncErr)
+		}
+		// The writer keeps failing once it failed
+		s.writer = csv.NewWriter(s.file)
+		return err
+	}
+	for agency, n := range added {
+		s.counts[agency] += n
+	}
+	for _, bet := range bets {
+		if bet.Sequence > s.sequences[bet.Agency] {
+			s.sequences[bet.Agency] = bet.Sequence
+		}
+	}
+	return nil
+}
+
+// write Writes the bets and waits for them to reach the disk
+func (s *CSVStore) write(bets []StoredBet) error {
+	for _, bet := range bets {
+		if err := s.writer.Write(betRecord(bet)); err != nil {
+			return err
+		}
+	}
+	s.writer.Flush()
+	if err := s.writer.Error(); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+// Cancel Not supported: the file has no room for the bets cancelled
+func (s *CSVStore) Cancel(agency uint8, id uint32) error {
+	return ErrCancelUnsupported
+}
+
+// Sequences Returns the sequences stored since the file was opened
+func (s *CSVStore) Sequences() map[uint8]uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return copySequences(s.sequences)
+}
+
+func copySequences(sequences map[uint8]uint32) map[uint8]uint32 {
+	copied := make(map[uint8]uint32, len(sequences))
+	for agency, sequence := range sequences {
+		copied[agency] = sequence
+	}
+	return copied
+}
+
+// Load Reads the whole file from the beginning
+func (s *CSVStore) Load(fn func(bet StoredBet) error) error {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	// Bets without stake have one column less
+	reader.FieldsPerRecord = -1
+	ids := make(map[uint8]uint32)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if n := len(betRecord(StoredBet{})); len(record) != n && len(record) != n+1 {
+			line, _ := reader.FieldPos(0)
+			return errors.Errorf("%s:%d: %d fields", s.path, line, len(record))
+		}
+		bet, err := parseBetRecord(record)
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			return errors.Wrapf(err, "%s:%d", s.path, line)
+		}
+		ids[bet.Agency]++
+		bet.ID = ids[bet.Agency]
+		if err := fn(bet); err != nil {
+			return err
+		}
+	}
+}
+
+// LoadAgency Reads the whole file, skipping the bets of other agencies
+func (s *CSVStore) LoadAgency(agency uint8, fn func(bet StoredBet) error) error {
+	return s.Load(func(bet StoredBet) error {
+		if bet.Agency != agency {
+			return nil
+		}
+		return fn(bet)
+	})
+}
+
+// Count Reads the whole file counting the bets of the agency
+func (s *CSVStore) Count(agency uint8) (int, error) {
+	count := 0
+	err := s.LoadAgency(agency, func(StoredBet) error {
+		count++
+		return nil
+	})
+	return count, err
+}
+
+// ExportCSV Writes every bet of the store not cancelled to out in the format
+// of bets.csv
+func ExportCSV(store BetStore, out io.Writer) error {
+	writer := csv.NewWriter(out)
+	err := store.Load(func(bet StoredBet) error {
+		if bet.Cancelled {
+			return nil
+		}
+		return writer.Write(betRecord(bet))
+	})
+	if err != nil {
+		return err
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// Close Closes the file
+func (s *CSVStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+func betRecord(bet StoredBet) []string {
+	record := []string{
+		strconv.Itoa(int(bet.Agency)),
+		bet.FirstName,
+		bet.LastName,
+		strconv.FormatUint(bet.Document, 10),
+		bet.Birthdate,
+		protocol.FormatNumbers(bet.Bet),
+	}
+	if bet.Stake != 0 {
+		record = append(record, protocol.FormatAmount(uint64(bet.Stake)))
+	}
+	return record
+}
+
+func parseBetRecord(record []string) (StoredBet, error) {
+	agency, err := strconv.ParseUint(record[0], 10, 8)
+	if err != nil {
+		return StoredBet{}, errors.Wrap(err, "agency")
+	}
+	document, err := strconv.ParseUint(record[3], 10, 64)
+	if err != nil {
+		return StoredBet{}, errors.Wrap(err, "document")
+	}
+	var numbers []uint16
+	for _, field := range strings.Split(record[5], protocol.NumbersSeparator) {
+		number, err := strconv.ParseUint(field, 10, 16)
+		if err != nil {
+			return StoredBet{}, errors.Wrap(err, "number")
+		}
+		numbers = append(numbers, uint16(number))
+	}
+	var stake uint32
+	if len(record) > 6 {
+		if stake, err = protocol.ParseAmount(record[6]); err != nil {
+			return StoredBet{}, errors.Wrap(err, "stake")
+		}
+	}
+	bet := StoredBet{
+		Agency: uint8(agency),
+		Bet: protocol.Bet{
+			FirstName: record[1],
+			LastName:  record[2],
+			Document:  document,
+			Birthdate: record[4],
+			Stake:     stake,
+		},
+	}
+	if len(numbers) == 1 {
+		bet.Number = numbers[0]
+	} else {
+		bet.Type = protocol.BetCombined
+		bet.Numbers = numbers
+	}
+	return bet, nil
+}
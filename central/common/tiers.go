@@ -0,0 +1,95 @@
+package common
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// PrizeTier Prize of the bets whose number matches the last Digits digits of
+// the winning number. protocol.NumberDigits digits means an exact match
+type PrizeTier struct {
+	Digits uint8 `json:"digits"`
+	// Payout Times the stake the tier pays. The winners share the prize pool
+	// in proportion to their stake times the payout of their tier
+	Payout uint32 `json:"payout"`
+}
+
+// DefaultPrizeTiers Only an exact match wins, like in the original server
+var DefaultPrizeTiers = []PrizeTier{{Digits: protocol.NumberDigits, Payout: 3500}}
+
+// ParsePrizeTiers Parses a comma separated list of digits:payout tiers, like
+// "4:3500,3:600,2:70,1:7". The tiers are returned from the most to the least
+// digits
+func ParsePrizeTiers(s string) ([]PrizeTier, error) {
+	var tiers []PrizeTier
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		fields := strings.Split(part, ":")
+		if len(fields) != 2 {
+			return nil, errors.Errorf("invalid prize tier %q, must be digits:payout", part)
+		}
+		digits, err := strconv.ParseUint(fields[0], 10, 8)
+		if err != nil {
+			return nil, errors.Errorf("invalid digits in prize tier %q", part)
+		}
+		payout, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil {
+			return nil, errors.Errorf("invalid payout in prize tier %q", part)
+		}
+		tiers = append(tiers, PrizeTier{Digits: uint8(digits), Payout: uint32(payout)})
+	}
+	return sortPrizeTiers(tiers)
+}
+
+// sortPrizeTiers Validates the tiers and returns a copy sorted from the most
+// to the least digits
+func sortPrizeTiers(tiers []PrizeTier) ([]PrizeTier, error) {
+	if len(tiers) == 0 {
+		return nil, errors.New("no prize tiers")
+	}
+	sorted := make([]PrizeTier, len(tiers))
+	copy(sorted, tiers)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Digits > sorted[j].Digits })
+	for i, tier := range sorted {
+		if tier.Digits < 1 || tier.Digits > protocol.NumberDigits {
+			return nil, errors.Errorf("prize tier of %d digits, must be between 1 and %d", tier.Digits, protocol.NumberDigits)
+		}
+		if tier.Payout == 0 {
+			return nil, errors.Errorf("prize tier of %d digits pays nothing", tier.Digits)
+		}
+		if i > 0 && sorted[i-1].Digits == tier.Digits {
+			return nil, errors.Errorf("prize tier of %d digits given twice", tier.Digits)
+		}
+	}
+	return sorted, nil
+}
+
+// matchTier Returns the best tier the number wins, if any. The tiers must be
+// sorted from the most to the least digits. An exact match requires the
+// whole number to be equal, so numbers out of range never win it
+func matchTier(tiers []PrizeTier, number uint16, winning uint16) (PrizeTier, bool) {
+	for _, tier := range tiers {
+		if tier.Digits >= protocol.NumberDigits {
+			if number == winning {
+				return tier, true
+			}
+			continue
+		}
+		modulo := uint16(1)
+		for i := uint8(0); i < tier.Digits; i++ {
+			modulo *= 10
+		}
+		if number%modulo == winning%modulo {
+			return tier, true
+		}
+	}
+	return PrizeTier{}, false
+}
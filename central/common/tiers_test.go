@@ -0,0 +1,74 @@
+package common
+
+import (
+	"crypto/ed25519"
+	"reflect"
+	"testing"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+func TestParsePrizeTiers(t *testing.T) {
+	tiers, err := ParsePrizeTiers("1:7, 4:3500,3:600,2:70")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PrizeTier{{4, 3500}, {3, 600}, {2, 70}, {1, 7}}
+	if !reflect.DeepEqual(tiers, want) {
+		t.Errorf("got %v, want %v", tiers, want)
+	}
+	for _, invalid := range []string{"", "4", "5:10", "0:10", "3:0", "3:10,3:20", "x:1"} {
+		if _, err := ParsePrizeTiers(invalid); err == nil {
+			t.Errorf("%q parsed", invalid)
+		}
+	}
+}
+
+func TestMatchTierPicksTheBestTier(t *testing.T) {
+	tiers, _ := ParsePrizeTiers("4:3500,3:600,1:7")
+	tests := []struct {
+		number uint16
+		digits uint8
+		won    bool
+	}{
+		{5164, 4, true},
+		{1164, 3, true},
+		// Two digits is not a tier, one digit is
+		{9964, 1, true},
+		{9994, 1, true},
+		{5165, 0, false},
+		// Out of range numbers never match exactly
+		{15164, 3, true},
+	}
+	for _, test := range tests {
+		tier, won := matchTier(tiers, test.number, 5164)
+		if won != test.won || tier.Digits != test.digits {
+			t.Errorf("%d: got %v %v, want %v %v", test.number, tier.Digits, won, test.digits, test.won)
+		}
+	}
+}
+
+func TestServerSendsTheTierOfEveryWinner(t *testing.T) {
+	tiers, _ := ParsePrizeTiers("4:3500,3:600,2:70")
+	server := startServerWith(t, ServerConfig{Agencies: 1, PrizeTiers: tiers})
+	batch := sequencedBatch(1, 1, 4)
+	// Thousands, hundreds and units digit changed
+	batch.Bets[1].Number = (testNumber + 1000) % protocol.NumberRange
+	batch.Bets[2].Number = testNumber/1000*1000 + (testNumber+100)%1000
+	batch.Bets[3].Number = testNumber/10*10 + (testNumber+1)%10
+	ackStatus(t, server, batch)
+	ackStatus(t, server, &protocol.EndOfBets{Agency: 1})
+	waitDraw(t, server)
+
+	winners, _, err := defaultContest(server).lottery.winnersOf(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	documents := []uint64{batch.Bets[0].Document, batch.Bets[1].Document, batch.Bets[2].Document}
+	if !reflect.DeepEqual(winners.Documents, documents) || !reflect.DeepEqual(winners.Tiers, []uint8{4, 3, 2}) {
+		t.Errorf("got %v with tiers %v, want %v with tiers 4, 3, 2", winners.Documents, winners.Tiers, documents)
+	}
+	if err := protocol.VerifyWinners(testKey.Public().(ed25519.PublicKey), "1", 1, winners); err != nil {
+		t.Errorf("signature: %v", err)
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/central/common"
+)
+
+var log = logging.MustGetLogger("log")
+
+// InitConfig Function that uses viper library to parse configuration parameters.
+// Viper is configured to read variables from both environment variables and the
+// config file ./config.yaml. Environment variables takes precedence over parameters
+// defined in the configuration file. If some of the variables cannot be parsed,
+// an error is returned
+func InitConfig() (*viper.Viper, error) {
+	v := viper.New()
+
+	// Configure viper to read env variables with the CENTRAL_ prefix
+	v.AutomaticEnv()
+	v.SetEnvPrefix("central")
+	// Use a replacer to replace env variables underscores with points. This let us
+	// use nested configurations in the config file and at the same time define
+	// env variables for the nested configurations
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+
+	// Add env variables supported
+	v.BindEnv("server", "address")
+	v.BindEnv("log", "level")
+	v.BindEnv("lottery", "agencies")
+	v.BindEnv("lottery", "roster")
+	v.BindEnv("lottery", "quorum")
+	v.BindEnv("lottery", "deadline")
+	v.BindEnv("lottery", "seed")
+	v.BindEnv("lottery", "contest")
+	v.BindEnv("lottery", "contests")
+	v.BindEnv("lottery", "tiers")
+	v.BindEnv("lottery", "houseCut")
+	v.BindEnv("signing", "key")
+	v.BindEnv("storage", "engine")
+	v.BindEnv("storage", "path")
+	v.BindEnv("storage", "sync")
+	v.BindEnv("storage", "syncInterval")
+	v.BindEnv("state", "path")
+	v.BindEnv("admin", "address")
+	v.BindEnv("admin", "token")
+
+	v.SetDefault("server.address", ":12345")
+	v.SetDefault("log.level", "INFO")
+	v.SetDefault("lottery.agencies", 5)
+	v.SetDefault("lottery.quorum", "all")
+	v.SetDefault("lottery.deadline", "0s")
+	v.SetDefault("lottery.contest", "1")
+	v.SetDefault("lottery.tiers", "4:3500")
+	v.SetDefault("lottery.houseCut", "0")
+	v.SetDefault("storage.engine", "log")
+	v.SetDefault("storage.path", "./bets.log")
+	v.SetDefault("storage.sync", "batch")
+	v.SetDefault("storage.syncInterval", "100ms")
+	v.SetDefault("state.path", "./contest.json")
+	v.SetDefault("admin.address", "127.0.0.1:8080")
+
+	// Try to read configuration from config file. If config file
+	// does not exists then ReadInConfig will fail but configuration
+	// can be loaded from the environment variables so we shouldn't
+	// return an error in that case
+	v.SetConfigFile("./config.yaml")
+	if err := v.ReadInConfig(); err != nil {
+		fmt.Printf("Configuration could not be read from config file. Using env variables instead")
+	}
+
+	if v.GetInt("lottery.agencies") < 1 {
+		return nil, errors.Errorf("Invalid CENTRAL_LOTTERY_AGENCIES %q, must be a positive number.", v.GetString("lottery.agencies"))
+	}
+	if _, err := parseRoster(v.GetString("lottery.roster")); err != nil {
+		return nil, errors.Wrapf(err, "Could not parse CENTRAL_LOTTERY_ROSTER env var.")
+	}
+	if _, err := parseQuorum(v.GetString("lottery.quorum")); err != nil {
+		return nil, errors.Wrapf(err, "Could not parse CENTRAL_LOTTERY_QUORUM env var.")
+	}
+	if _, err := time.ParseDuration(v.GetString("lottery.deadline")); err != nil {
+		return nil, errors.Wrapf(err, "Could not parse CENTRAL_LOTTERY_DEADLINE env var as time.Duration.")
+	}
+	if _, err := common.ParsePrizeTiers(v.GetString("lottery.tiers")); err != nil {
+		return nil, errors.Wrapf(err, "Could not parse CENTRAL_LOTTERY_TIERS env var.")
+	}
+	if _, err := common.ParseHouseCut(v.GetString("lottery.houseCut")); err != nil {
+		return nil, errors.Wrapf(err, "Could not parse CENTRAL_LOTTERY_HOUSECUT env var.")
+	}
+	if _, err := hex.DecodeString(v.GetString("lottery.seed")); err != nil {
+		return nil, errors.Wrapf(err, "Could not parse CENTRAL_LOTTERY_SEED env var as hex.")
+	}
+	if engine := v.GetString("storage.engine"); engine != "log" && engine != "csv" {
+		return nil, errors.Errorf("Invalid CENTRAL_STORAGE_ENGINE %q, must be log or csv.", engine)
+	}
+	if _, err := common.ParseSyncPolicy(v.GetString("storage.sync")); err != nil {
+		return nil, errors.Wrapf(err, "Could not parse CENTRAL_STORAGE_SYNC env var.")
+	}
+	if _, err := time.ParseDuration(v.GetString("storage.syncInterval")); err != nil {
+		return nil, errors.Wrapf(err, "Could not parse CENTRAL_STORAGE_SYNCINTERVAL env var as time.Duration.")
+	}
+	if _, err := signingKey(v); err != nil {
+		return nil, errors.Wrapf(err, "Could not parse CENTRAL_SIGNING_KEY env var.")
+	}
+
+	return v, nil
+}
+
+// InitLogger Receives the log level to be set in go-logging as a string. This method
+// parses the string and set the level to the logger. If the level string is not
+// valid an error is returned
+func InitLogger(logLevel string) error {
+	baseBackend := logging.NewLogBackend(os.Stdout, "", 0)
+	format := logging.MustStringFormatter(
+		`%{time:2006-01-02 15:04:05} %{level:.5s}     %{message}`,
+	)
+	backendFormatter := logging.NewBackendFormatter(baseBackend, format)
+
+	backendLeveled := logging.AddModuleLevel(backendFormatter)
+	logLevelCode, err := logging.LogLevel(logLevel)
+	if err != nil {
+		return err
+	}
+	backendLeveled.SetLevel(logLevelCode, "")
+
+	// Set the backends to be used.
+	logging.SetBackend(backendLeveled)
+	return nil
+}
+
+// PrintConfig Print all the configuration parameters of the program.
+// For debugging purposes only
+func PrintConfig(v *viper.Viper) {
+	log.Infof("action: config | result: success | server_address: %s | log_level: %s | agencies: %v | storage_engine: %s | storage_path: %s | storage_sync: %s",
+		v.GetString("server.address"),
+		v.GetString("log.level"),
+		v.GetInt("lottery.agencies"),
+		v.GetString("storage.engine"),
+		v.GetString("storage.path"),
+		v.GetString("storage.sync"),
+	)
+}
+
+func main() {
+	v, err := InitConfig()
+	if err != nil {
+		log.Criticalf("%s", err)
+		os.Exit(1)
+	}
+
+	if err := InitLogger(v.GetString("log.level")); err != nil {
+		log.Criticalf("%s", err)
+		os.Exit(1)
+	}
+
+	// Print program config with debugging purposes
+	PrintConfig(v)
+
+	// Already validated by InitConfig
+	seed, _ := hex.DecodeString(v.GetString("lottery.seed"))
+	key, _ := signingKey(v)
+	syncPolicy, _ := common.ParseSyncPolicy(v.GetString("storage.sync"))
+	roster, _ := parseRoster(v.GetString("lottery.roster"))
+	quorum, _ := parseQuorum(v.GetString("lottery.quorum"))
+	tiers, _ := common.ParsePrizeTiers(v.GetString("lottery.tiers"))
+	houseCut, _ := common.ParseHouseCut(v.GetString("lottery.houseCut"))
+	server, err := common.NewServer(common.ServerConfig{
+		ListenAddress: v.GetString("server.address"),
+		Agencies:      v.GetInt("lottery.agencies"),
+		Roster:        roster,
+		Quorum:        quorum,
+		Deadline:      v.GetDuration("lottery.deadline"),
+		Seed:          seed,
+		Contest:       v.GetString("lottery.contest"),
+		Contests:      parseContests(v.GetString("lottery.contests")),
+		PrizeTiers:    tiers,
+		HouseCut:      houseCut,
+		SigningKey:    key,
+		StorageEngine: v.GetString("storage.engine"),
+		StoragePath:   v.GetString("storage.path"),
+		Storage: common.LogOptions{
+			Sync:         syncPolicy,
+			SyncInterval: v.GetDuration("storage.syncInterval"),
+		},
+		StatePath:    v.GetString("state.path"),
+		AdminAddress: v.GetString("admin.address"),
+		AdminToken:   v.GetString("admin.token"),
+	})
+	if err != nil {
+		log.Criticalf("action: start_server | result: fail | error: %v", err)
+		os.Exit(1)
+	}
+
+	// Stop the server gracefully when docker sends SIGTERM
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-sigChan
+		log.Infof("action: shutdown | result: in_progress | signal: %v", sig)
+		server.Shutdown()
+	}()
+
+	if err := server.Run(); err != nil {
+		log.Errorf("action: shutdown | result: fail | error: %v", err)
+		os.Exit(1)
+	}
+	log.Infof("action: shutdown | result: success")
+}
+
+// signingKey Returns the key the winners are signed with, defined by its hex
+// encoded 32 bytes seed. Nil if not configured, so the central generates one
+func signingKey(v *viper.Viper) (ed25519.PrivateKey, error) {
+	if v.GetString("signing.key") == "" {
+		return nil, nil
+	}
+	seed, err := hex.DecodeString(v.GetString("signing.key"))
+	if err != nil {
+		return nil, err
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, errors.Errorf("key must be %d bytes long", ed25519.SeedSize)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// parseContests Parses a comma separated list of contest ids. They are
+// validated by the server
+func parseContests(s string) []string {
+	var ids []string
+	for _, id := range strings.Split(s, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// parseRoster Parses a comma separated list of agency ids and ranges such as
+// "1-3,5". Empty means agencies 1 to lottery.agencies
+func parseRoster(s string) ([]uint8, error) {
+	var roster []uint8
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		bounds := strings.SplitN(part, "-", 2)
+		first, err := strconv.ParseUint(strings.TrimSpace(bounds[0]), 10, 8)
+		if err != nil {
+			return nil, errors.Errorf("invalid agency %q", part)
+		}
+		last := first
+		if len(bounds) == 2 {
+			last, err = strconv.ParseUint(strings.TrimSpace(bounds[1]), 10, 8)
+			if err != nil || last < first {
+				return nil, errors.Errorf("invalid range %q", part)
+			}
+		}
+		for id := first; id <= last; id++ {
+			roster = append(roster, uint8(id))
+		}
+	}
+	return roster, nil
+}
+
+// parseQuorum Parses "all", returned as 0, or a positive amount of agencies
+func parseQuorum(s string) (int, error) {
+	if strings.EqualFold(s, "all") {
+		return 0, nil
+	}
+	quorum, err := strconv.Atoi(s)
+	if err != nil || quorum < 1 {
+		return 0, errors.Errorf("quorum %q must be all or a positive number", s)
+	}
+	return quorum, nil
+}
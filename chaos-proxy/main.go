@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/op/go-logging"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/chaos"
+)
+
+var log = logging.MustGetLogger("log")
+
+// faultFlags Registers the flags of one direction using the given prefix
+func faultFlags(faults *chaos.Faults, prefix string, direction string) {
+	flag.IntVar(&faults.MaxFragment, prefix+"fragment", 0, "split "+direction+" writes in random fragments of up to this many bytes")
+	flag.DurationVar(&faults.Latency, prefix+"latency", 0, "latency added to every "+direction+" fragment")
+	flag.DurationVar(&faults.Jitter, prefix+"jitter", 0, "max random latency added on top of -"+prefix+"latency")
+	flag.IntVar(&faults.Bandwidth, prefix+"bandwidth", 0, "max "+direction+" bytes per second (0 is unlimited)")
+	flag.Int64Var(&faults.ResetAfter, prefix+"reset-after", 0, "reset the connection after forwarding this many "+direction+" bytes")
+	flag.BoolVar(&faults.Blackhole, prefix+"blackhole", false, "swallow every "+direction+" byte")
+}
+
+func main() {
+	var config chaos.Config
+	flag.StringVar(&config.ListenAddress, "listen", ":12346", "address the proxy listens on")
+	flag.StringVar(&config.TargetAddress, "target", "central:12345", "address of the server")
+	flag.Int64Var(&config.Seed, "seed", 1, "seed of every random decision")
+	faultFlags(&config.Upstream, "up-", "client to server")
+	faultFlags(&config.Downstream, "down-", "server to client")
+	logLevel := flag.String("log-level", "INFO", "log level")
+	flag.Parse()
+
+	if err := initLogger(*logLevel); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	proxy, err := chaos.NewProxy(config)
+	if err != nil {
+		log.Criticalf("action: proxy_listen | result: fail | address: %v | error: %v", config.ListenAddress, err)
+		os.Exit(1)
+	}
+	log.Infof("action: proxy_listen | result: success | address: %v | target: %v | seed: %v | upstream: %+v | downstream: %+v",
+		proxy.Addr(), config.TargetAddress, config.Seed, config.Upstream, config.Downstream)
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		<-sigChan
+		log.Infof("action: shutdown | result: in_progress")
+		proxy.Close()
+	}()
+
+	if err := proxy.Serve(); err != nil {
+		log.Errorf("action: proxy_serve | result: fail | error: %v", err)
+		os.Exit(1)
+	}
+	log.Infof("action: shutdown | result: success")
+}
+
+func initLogger(level string) error {
+	backend := logging.NewBackendFormatter(
+		logging.NewLogBackend(os.Stdout, "", 0),
+		logging.MustStringFormatter(`%{time:2006-01-02 15:04:05} %{level:.5s}     %{message}`),
+	)
+	leveled := logging.AddModuleLevel(backend)
+	code, err := logging.LogLevel(level)
+	if err != nil {
+		return err
+	}
+	leveled.SetLevel(code, "")
+	logging.SetBackend(leveled)
+	return nil
+}
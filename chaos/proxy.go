@@ -0,0 +1,268 @@
+// Package chaos Fault-injecting TCP proxy to test how clients and servers
+// behave on a hostile network. Faults are configured per direction and every
+// random decision is taken from a seeded source, so a run can be reproduced.
+package chaos
+
+import (
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/op/go-logging"
+)
+
+var log = logging.MustGetLogger("log")
+
+// bufferSize Max bytes read from a connection at once
+const bufferSize = 32 * 1024
+
+// Faults Misbehaviours applied to the bytes flowing in one direction
+type Faults struct {
+	// MaxFragment Splits every write into fragments of 1 to MaxFragment bytes
+	// chosen at random. Zero forwards the bytes as they were read
+	MaxFragment int
+	// Latency Delay added before forwarding each fragment
+	Latency time.Duration
+	// Jitter Max random delay added on top of Latency
+	Jitter time.Duration
+	// Bandwidth Max bytes per second forwarded. Zero means unlimited
+	Bandwidth int
+	// ResetAfter Resets both connections once this amount of bytes was
+	// forwarded. Zero never resets
+	ResetAfter int64
+	// Blackhole Swallows every byte without forwarding it
+	Blackhole bool
+}
+
+// Config Configuration of a proxy
+type Config struct {
+	ListenAddress string
+	TargetAddress string
+	Seed          int64
+	// Upstream Faults applied to the bytes sent by the client to the server
+	Upstream Faults
+	// Downstream Faults applied to the bytes sent by the server to the client
+	Downstream Faults
+}
+
+// Proxy Forwards every accepted connection to the target address injecting
+// the configured faults
+type Proxy struct {
+	config   Config
+	listener net.Listener
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	nextID int64
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// NewProxy Starts listening on the configured address. Serve must be called
+// to start forwarding connections
+func NewProxy(config Config) (*Proxy, error) {
+	listener, err := net.Listen("tcp", config.ListenAddress)
+	if err != nil {
+		return nil, err
+	}
+	return &Proxy{
+		config:   config,
+		listener: listener,
+		conns:    make(map[net.Conn]struct{}),
+	}, nil
+}
+
+// Addr Address the proxy is listening on
+func (p *Proxy) Addr() string {
+	return p.listener.Addr().String()
+}
+
+// Serve Accepts connections until Close is called
+func (p *Proxy) Serve() error {
+	for {
+		client, err := p.listener.Accept()
+		if err != nil {
+			p.mu.Lock()
+			closed := p.closed
+			p.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+
+		p.mu.Lock()
+		id := p.nextID
+		p.nextID++
+		p.mu.Unlock()
+
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			p.handle(id, client)
+		}()
+	}
+}
+
+// Close Stops accepting connections, closes the forwarded ones and waits
+// for their goroutines to finish
+func (p *Proxy) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	for conn := range p.conns {
+		conn.Close()
+	}
+	p.mu.Unlock()
+	err := p.listener.Close()
+	p.wg.Wait()
+	return err
+}
+
+func (p *Proxy) track(conn net.Conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return false
+	}
+	p.conns[conn] = struct{}{}
+	return true
+}
+
+func (p *Proxy) untrack(conn net.Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.conns, conn)
+}
+
+// handle Connects to the target and pumps bytes in both directions until one
+// of the sides closes or a reset is injected
+func (p *Proxy) handle(id int64, client net.Conn) {
+	defer client.Close()
+	if !p.track(client) {
+		return
+	}
+	defer p.untrack(client)
+
+	server, err := net.Dial("tcp", p.config.TargetAddress)
+	if err != nil {
+		log.Errorf("action: proxy_connect | result: fail | conn: %v | error: %v", id, err)
+		return
+	}
+	defer server.Close()
+	if !p.track(server) {
+		return
+	}
+	defer p.untrack(server)
+	log.Infof("action: proxy_connect | result: success | conn: %v | client: %v", id, client.RemoteAddr())
+
+	// Each connection and direction gets its own source so the faults do not
+	// depend on how goroutines are scheduled
+	up := newPipe(client, server, p.config.Upstream, p.config.Seed+2*id)
+	down := newPipe(server, client, p.config.Downstream, p.config.Seed+2*id+1)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { defer wg.Done(); up.run() }()
+	go func() { defer wg.Done(); down.run() }()
+	wg.Wait()
+
+	log.Infof("action: proxy_close | result: success | conn: %v | upstream_bytes: %v | downstream_bytes: %v",
+		id, up.forwarded, down.forwarded)
+}
+
+// pipe Forwards the bytes read from src to dst applying the faults
+type pipe struct {
+	src       net.Conn
+	dst       net.Conn
+	faults    Faults
+	rng       *rand.Rand
+	forwarded int64
+}
+
+func newPipe(src, dst net.Conn, faults Faults, seed int64) *pipe {
+	return &pipe{src: src, dst: dst, faults: faults, rng: rand.New(rand.NewSource(seed))}
+}
+
+func (p *pipe) run() {
+	buf := make([]byte, bufferSize)
+	for {
+		n, err := p.src.Read(buf)
+		if n > 0 && !p.faults.Blackhole {
+			if !p.forward(buf[:n]) {
+				return
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				// Propagate the half-close so the other side sees the EOF
+				// once every byte was forwarded
+				if tcp, ok := p.dst.(*net.TCPConn); ok {
+					tcp.CloseWrite()
+					return
+				}
+			}
+			p.dst.Close()
+			return
+		}
+	}
+}
+
+// forward Writes data to dst in fragments. Returns false if the connections
+// were reset or dst failed
+func (p *pipe) forward(data []byte) bool {
+	for len(data) > 0 {
+		n := len(data)
+		if p.faults.MaxFragment > 0 {
+			n = 1 + p.rng.Intn(p.faults.MaxFragment)
+			if n > len(data) {
+				n = len(data)
+			}
+		}
+
+		reset := false
+		if p.faults.ResetAfter > 0 && p.forwarded+int64(n) >= p.faults.ResetAfter {
+			n = int(p.faults.ResetAfter - p.forwarded)
+			reset = true
+		}
+
+		p.delay(n)
+		if _, err := p.dst.Write(data[:n]); err != nil {
+			return false
+		}
+		p.forwarded += int64(n)
+		data = data[n:]
+
+		if reset {
+			p.reset()
+			return false
+		}
+	}
+	return true
+}
+
+// delay Sleeps the latency, jitter and bandwidth time of a fragment
+func (p *pipe) delay(n int) {
+	d := p.faults.Latency
+	if p.faults.Jitter > 0 {
+		d += time.Duration(p.rng.Int63n(int64(p.faults.Jitter)))
+	}
+	if p.faults.Bandwidth > 0 {
+		d += time.Duration(n) * time.Second / time.Duration(p.faults.Bandwidth)
+	}
+	if d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// reset Closes both connections sending a TCP RST instead of a FIN
+func (p *pipe) reset() {
+	for _, conn := range []net.Conn{p.src, p.dst} {
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			tcp.SetLinger(0)
+		}
+		conn.Close()
+	}
+	log.Infof("action: proxy_reset | result: success | bytes: %v", p.forwarded)
+}
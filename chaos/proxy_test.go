@@ -0,0 +1,134 @@
+package chaos
+
+import (
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/servertest"
+)
+
+func startProxy(t *testing.T, config Config) *Proxy {
+	t.Helper()
+	config.ListenAddress = "127.0.0.1:0"
+	proxy, err := NewProxy(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go proxy.Serve()
+	t.Cleanup(func() { proxy.Close() })
+	return proxy
+}
+
+func TestFragmentedFramesArriveIntact(t *testing.T) {
+	server, err := servertest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	server.On(protocol.MsgWinnersQuery, servertest.Winners(1, 2, 3))
+
+	fragments := Faults{MaxFragment: 3, Jitter: time.Millisecond}
+	proxy := startProxy(t, Config{
+		TargetAddress: server.Addr(),
+		Seed:          7,
+		Upstream:      fragments,
+		Downstream:    fragments,
+	})
+
+	conn, err := net.Dial("tcp", proxy.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	batch := &protocol.BetBatch{Agency: 1, Bets: []protocol.Bet{
+		{FirstName: "Santiago Lionel", LastName: "Lorca", Document: 30904465, Birthdate: "1999-03-17", Number: 7574},
+	}}
+	if err := protocol.WriteMessage(conn, batch); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := protocol.ReadMessage(conn); err != nil {
+		t.Fatalf("reading ack: %v", err)
+	}
+	if err := protocol.WriteMessage(conn, &protocol.WinnersQuery{Agency: 1}); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := protocol.ReadMessage(conn)
+	if err != nil {
+		t.Fatalf("reading winners: %v", err)
+	}
+	if winners, ok := msg.(*protocol.Winners); !ok || len(winners.Documents) != 3 {
+		t.Errorf("got %+v", msg)
+	}
+
+	received := server.Messages(protocol.MsgBetBatch)
+	if len(received) != 1 || !reflect.DeepEqual(received[0].(*protocol.BetBatch).Bets[0], batch.Bets[0]) {
+		t.Errorf("server received %+v", received)
+	}
+}
+
+func TestResetAfterBytes(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	received := make(chan int64, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		n, _ := io.Copy(io.Discard, conn)
+		received <- n
+	}()
+
+	proxy := startProxy(t, Config{
+		TargetAddress: listener.Addr().String(),
+		Upstream:      Faults{MaxFragment: 10, ResetAfter: 25},
+	})
+	conn, err := net.Dial("tcp", proxy.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write(make([]byte, 100))
+
+	select {
+	case n := <-received:
+		if n != 25 {
+			t.Errorf("server received %d bytes, want 25", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection was not reset")
+	}
+}
+
+func TestBlackholeSwallowsBytes(t *testing.T) {
+	server, err := servertest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	proxy := startProxy(t, Config{TargetAddress: server.Addr(), Upstream: Faults{Blackhole: true}})
+	conn, err := net.Dial("tcp", proxy.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	protocol.WriteMessage(conn, &protocol.EndOfBets{Agency: 1})
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := protocol.ReadMessage(conn); err == nil {
+		t.Error("expected no response")
+	}
+	if n := len(server.Frames()); n != 0 {
+		t.Errorf("server received %d frames", n)
+	}
+}
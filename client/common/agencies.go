@@ -0,0 +1,163 @@
+package common
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// AgencyConfig Identity and data source of one of the agencies driven by a
+// MultiClient. Rejected rows and winners are not reported if RejectsPath and
+// ReportPath are empty
+type AgencyConfig struct {
+	ID          string
+	DatasetPath string
+	RejectsPath string
+	ReportPath  string
+}
+
+// AgencyResult Outcome of one of the agencies driven by a MultiClient
+type AgencyResult struct {
+	ID      string
+	Metrics Metrics
+	Elapsed time.Duration
+	Err     error
+}
+
+// LoggerModule Name of the go-logging module used by the client of an agency.
+// Formats including %{module} tag every line with the agency that logged it
+func LoggerModule(id string) string {
+	return "agency-" + id
+}
+
+// Add Accumulates the counters of other into m
+func (m *Metrics) Add(other Metrics) {
+	m.BetsSent += other.BetsSent
+	m.Resumed += other.Resumed
+	m.Rejected += other.Rejected
+	m.Batches += other.Batches
+	m.BatchRTTs = append(m.BatchRTTs, other.BatchRTTs...)
+	m.Reconnects += other.Reconnects
+	m.Errors += other.Errors
+	m.Winners += other.Winners
+}
+
+// MultiClient Drives several agencies concurrently from a single process, one
+// Client goroutine per agency. Agencies start Stagger apart so they do not
+// reach the server at the same time, and all of them stop on Shutdown
+type MultiClient struct {
+	config  ClientConfig
+	clients []*Client
+
+	quit     chan struct{}
+	quitOnce sync.Once
+}
+
+// NewMultiClient Initializes a client for every agency of config.Agencies
+func NewMultiClient(config ClientConfig) *MultiClient {
+	m := &MultiClient{config: config, quit: make(chan struct{})}
+	for _, agency := range config.Agencies {
+		agencyConfig := config
+		agencyConfig.Agencies = nil
+		agencyConfig.ID = agency.ID
+		agencyConfig.DatasetPath = agency.DatasetPath
+		agencyConfig.RejectsPath = agency.RejectsPath
+		agencyConfig.ReportPath = agency.ReportPath
+		m.clients = append(m.clients, NewClient(agencyConfig))
+	}
+	return m
+}
+
+// Shutdown Stops every agency. Safe to call more than once and from any
+// goroutine
+func (m *MultiClient) Shutdown() {
+	m.quitOnce.Do(func() {
+		close(m.quit)
+		for _, client := range m.clients {
+			client.Shutdown()
+		}
+	})
+}
+
+// Run Runs the loop of every agency and waits for all of them. Results are
+// returned in the order of config.Agencies, along with an error if any of the
+// agencies failed
+func (m *MultiClient) Run() ([]AgencyResult, error) {
+	if len(m.clients) == 0 {
+		return nil, errors.New("no agencies configured")
+	}
+	seen := make(map[string]bool)
+	for _, agency := range m.config.Agencies {
+		if seen[agency.ID] {
+			return nil, errors.Errorf("agency %q configured more than once", agency.ID)
+		}
+		seen[agency.ID] = true
+	}
+
+	results := make([]AgencyResult, len(m.clients))
+	var wg sync.WaitGroup
+	for i, client := range m.clients {
+		wg.Add(1)
+		go func(i int, client *Client) {
+			defer wg.Done()
+			results[i] = m.runAgency(client, time.Duration(i)*m.config.Stagger)
+		}(i, client)
+	}
+	wg.Wait()
+
+	total := Total(results)
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+		}
+	}
+	outcome := "success"
+	if failed > 0 {
+		outcome = "fail"
+	}
+	log.Infof("action: agencias_finalizadas | result: %v | agencias: %v/%v | apuestas: %v | rechazadas: %v | reconexiones: %v | ganadores: %v",
+		outcome,
+		len(results)-failed,
+		len(results),
+		total.BetsSent,
+		total.Rejected,
+		total.Reconnects,
+		total.Winners,
+	)
+	if failed > 0 {
+		return results, errors.Errorf("%d of %d agencies failed", failed, len(results))
+	}
+	return results, nil
+}
+
+// runAgency Waits delay and runs the loop of a single agency
+func (m *MultiClient) runAgency(client *Client, delay time.Duration) AgencyResult {
+	result := AgencyResult{ID: client.config.ID}
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-m.quit:
+			result.Err = ErrShutdown
+			return result
+		case <-timer.C:
+		}
+	}
+
+	start := time.Now()
+	result.Err = client.StartClientLoop()
+	result.Elapsed = time.Since(start)
+	result.Metrics = client.Metrics()
+	return result
+}
+
+// Total Returns the counters of every agency added up
+func Total(results []AgencyResult) Metrics {
+	var total Metrics
+	for _, result := range results {
+		total.Add(result.Metrics)
+	}
+	return total
+}
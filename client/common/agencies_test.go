@@ -0,0 +1,93 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/servertest"
+)
+
+func TestMultiClientRunsEveryAgency(t *testing.T) {
+	server := startServer(t)
+	server.On(protocol.MsgWinnersQuery, servertest.Winners(30000001))
+
+	config := testConfig(server, "")
+	config.Stagger = 20 * time.Millisecond
+	config.Agencies = []AgencyConfig{
+		{ID: "1", DatasetPath: writeDataset(t, 15)},
+		{ID: "2", DatasetPath: writeDataset(t, 5)},
+		{ID: "3", DatasetPath: writeDataset(t, 25)},
+	}
+	results, err := NewMultiClient(config).Run()
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	bets := make(map[uint8]int)
+	for _, msg := range server.Messages(protocol.MsgBetBatch) {
+		batch := msg.(*protocol.BetBatch)
+		bets[batch.Agency] += len(batch.Bets)
+	}
+	if bets[1] != 15 || bets[2] != 5 || bets[3] != 25 {
+		t.Errorf("bets received per agency %v", bets)
+	}
+	for i, want := range []string{"1", "2", "3"} {
+		if results[i].ID != want || results[i].Err != nil {
+			t.Errorf("result %d: %+v", i, results[i])
+		}
+	}
+	total := Total(results)
+	if total.BetsSent != 45 || total.Winners != 3 || total.Batches != 6 {
+		t.Errorf("total %+v", total)
+	}
+	if server.Connections() != 3 {
+		t.Errorf("%d connections, want one per agency", server.Connections())
+	}
+}
+
+func TestMultiClientReportsFailedAgencies(t *testing.T) {
+	server := startServer(t)
+	config := testConfig(server, "")
+	config.Agencies = []AgencyConfig{
+		{ID: "1", DatasetPath: writeDataset(t, 5)},
+		{ID: "2", DatasetPath: "missing.csv"},
+	}
+	results, err := NewMultiClient(config).Run()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if results[0].Err != nil || results[1].Err == nil {
+		t.Errorf("results %+v", results)
+	}
+
+	config.Agencies = []AgencyConfig{{ID: "1"}, {ID: "1"}}
+	if _, err := NewMultiClient(config).Run(); err == nil {
+		t.Error("expected an error for a duplicated agency")
+	}
+}
+
+func TestMultiClientShutdownStopsStaggeredAgencies(t *testing.T) {
+	server := startServer(t)
+	config := testConfig(server, "")
+	config.Stagger = time.Hour
+	config.Agencies = []AgencyConfig{
+		{ID: "1", DatasetPath: writeDataset(t, 5)},
+		{ID: "2", DatasetPath: writeDataset(t, 5)},
+	}
+	multi := NewMultiClient(config)
+	time.AfterFunc(50*time.Millisecond, multi.Shutdown)
+
+	results, err := multi.Run()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if results[1].Err != ErrShutdown {
+		t.Errorf("staggered agency finished with %v", results[1].Err)
+	}
+	for _, msg := range server.Messages(protocol.MsgBetBatch) {
+		if msg.(*protocol.BetBatch).Agency == 2 {
+			t.Fatal("agency 2 started after the shutdown")
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package common
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/op/go-logging"
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/dataset"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// dateLayout Format of the birthdates in the agency files
+const dateLayout = "2006-01-02"
+
+// minBirthdate Earliest birthdate considered plausible. The latest is today
+var minBirthdate = time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// InvalidBetError is returned when a row of the agency file cannot be sent
+// to the server
+type InvalidBetError struct {
+	Line   int
+	Reason string
+}
+
+func (e *InvalidBetError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Reason)
+}
+
+func invalidBet(row dataset.Row, format string, args ...interface{}) *InvalidBetError {
+	return &InvalidBetError{Line: row.Line, Reason: fmt.Sprintf(format, args...)}
+}
+
+// parseBet Validates a row of the agency file and converts it into the bet
+// sent to the server. Names are normalized as the encoder would do it, so the
+// bet holds exactly what the server is going to store. Rows the server would
+// reject return an *InvalidBetError. Truncated names are reported to logger
+func parseBet(row dataset.Row, encoder protocol.Encoder, logger *logging.Logger) (protocol.Bet, error) {
+	firstName, err := normalizeName(row, "first name", row.FirstName, encoder, logger)
+	if err != nil {
+		return protocol.Bet{}, err
+	}
+	lastName, err := normalizeName(row, "last name", row.LastName, encoder, logger)
+	if err != nil {
+		return protocol.Bet{}, err
+	}
+
+	if !isDigits(row.Document) {
+		return protocol.Bet{}, invalidBet(row, "document %q is not numeric", row.Document)
+	}
+	document, err := strconv.ParseUint(row.Document, 10, 64)
+	if err != nil {
+		return protocol.Bet{}, invalidBet(row, "document %q does not fit in 8 bytes", row.Document)
+	}
+
+	birthdate, err := time.Parse(dateLayout, row.Birthdate)
+	if err != nil || len(row.Birthdate) != protocol.BirthdateSize {
+		return protocol.Bet{}, invalidBet(row, "birthdate %q is not a valid YYYY-MM-DD date", row.Birthdate)
+	}
+	if birthdate.Before(minBirthdate) || birthdate.After(time.Now()) {
+		return protocol.Bet{}, invalidBet(row, "birthdate %q is out of range", row.Birthdate)
+	}
+
+	numbers, err := parseNumbers(row)
+	if err != nil {
+		return protocol.Bet{}, err
+	}
+
+	var stake uint32
+	if row.Stake != "" {
+		if stake, err = protocol.ParseAmount(row.Stake); err != nil {
+			return protocol.Bet{}, invalidBet(row, "stake: %v", err)
+		}
+	}
+
+	bet := protocol.Bet{
+		FirstName: firstName,
+		LastName:  lastName,
+		Document:  document,
+		Birthdate: row.Birthdate,
+		Stake:     stake,
+	}
+	if len(numbers) == 1 {
+		bet.Number = numbers[0]
+	} else {
+		bet.Type = protocol.BetCombined
+		bet.Numbers = numbers
+	}
+	return bet, nil
+}
+
+// parseNumbers Parses the number column. Several numbers separated by
+// protocol.NumbersSeparator make a combined bet, whose numbers must be
+// different and in range
+func parseNumbers(row dataset.Row) ([]uint16, error) {
+	fields := strings.Split(row.Number, protocol.NumbersSeparator)
+	if len(fields) > protocol.MaxCombinedNumbers {
+		return nil, invalidBet(row, "%d numbers, max is %d", len(fields), protocol.MaxCombinedNumbers)
+	}
+	numbers := make([]uint16, 0, len(fields))
+	seen := make(map[uint64]bool, len(fields))
+	for _, field := range fields {
+		if !isDigits(field) {
+			return nil, invalidBet(row, "number %q is not numeric", field)
+		}
+		number, err := strconv.ParseUint(field, 10, 16)
+		if err != nil {
+			return nil, invalidBet(row, "number %q does not fit in 2 bytes", field)
+		}
+		if number == protocol.TypedBetMarker {
+			return nil, invalidBet(row, "number %q is reserved by the protocol", field)
+		}
+		if len(fields) > 1 {
+			if number >= protocol.NumberRange {
+				return nil, invalidBet(row, "number %q of a combined bet is out of range", field)
+			}
+			if seen[number] {
+				return nil, invalidBet(row, "number %q is played twice", field)
+			}
+			seen[number] = true
+		}
+		numbers = append(numbers, uint16(number))
+	}
+	return numbers, nil
+}
+
+func normalizeName(row dataset.Row, field string, name string, encoder protocol.Encoder, logger *logging.Logger) (string, error) {
+	if strings.TrimSpace(name) == "" {
+		return "", invalidBet(row, "%s is empty", field)
+	}
+	normalized, err := protocol.NormalizeName(name, encoder.NameLimit, encoder.NamePolicy)
+	if err != nil {
+		return "", invalidBet(row, "%s: %v", field, err)
+	}
+	if normalized != norm.NFC.String(name) {
+		logger.Warningf("action: truncar_nombre | result: success | line: %v | %s: %q | sent: %q",
+			row.Line,
+			strings.Replace(field, " ", "_", -1),
+			name,
+			normalized,
+		)
+	}
+	return normalized, nil
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
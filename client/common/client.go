@@ -1,28 +1,108 @@
 package common
 
 import (
-	"bufio"
-	"fmt"
+	"crypto/ed25519"
+	"io"
 	"net"
+	"os"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/op/go-logging"
+	"github.com/pkg/errors"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/dataset"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
 )
 
 var log = logging.MustGetLogger("log")
 
+// ErrShutdown is returned when the client is stopped before finishing
+var ErrShutdown = errors.New("client shutdown")
+
+// ErrContestClosed is returned when the central closed the contest before the
+// agency finished, so its bets were left out of the draw
+var ErrContestClosed = errors.New("contest closed")
+
 // ClientConfig Configuration used by the client
 type ClientConfig struct {
-	ID            string
-	ServerAddress string
-	LoopAmount    int
-	LoopPeriod    time.Duration
+	ID             string
+	ServerAddress  string
+	LoopAmount     int
+	LoopPeriod     time.Duration
+	BatchMaxAmount int
+	DatasetPath    string
+	RejectsPath    string
+	NameLimit      int
+	NamePolicy     protocol.NamePolicy
+	Timeout        time.Duration
+	MaxRetries     int
+	RetryPeriod    time.Duration
+	// PushWinners Wait on the open connection for the central to push the
+	// winners once the draw happens, instead of polling for them. Up to
+	// PushTimeout, then the client falls back to polling
+	PushWinners bool
+	PushTimeout time.Duration
+	// VerifyDraw Ask for the commitment to the seed of the draw before
+	// sending bets, and check the revealed seed and winning number against it
+	VerifyDraw bool
+	// PublicKey Key of the central. If set, winners that are not signed with
+	// its private half are refused
+	PublicKey ed25519.PublicKey
+	// Contest Contest the agency takes part in. Sent in every request, so
+	// successive contests run on the same central, and written in the
+	// winners report. The default contest of the central if empty
+	Contest string
+	// ReportPath File where the winners report is written, in ReportFormat.
+	// No report is written if empty
+	ReportPath   string
+	ReportFormat ReportFormat
+
+	// Agencies driven concurrently by a MultiClient. Each one runs with the
+	// rest of this configuration and its own ID and data source
+	Agencies []AgencyConfig
+	// Stagger Delay between the start of consecutive agencies
+	Stagger time.Duration
 }
 
-// Client Entity that encapsulates how
+// Metrics Counters collected while the client runs. They must only be read
+// once StartClientLoop returned
+type Metrics struct {
+	BetsSent int
+	// Resumed Bets not sent again because the central already stored them
+	// before a restart of the agency
+	Resumed    int
+	Rejected   int
+	Batches    int
+	BatchRTTs  []time.Duration
+	Reconnects int
+	Errors     int
+	Winners    int
+}
+
+// Client Entity that encapsulates how an agency registers its bets in the
+// lottery central and asks for its winners
 type Client struct {
-	config ClientConfig
-	conn   net.Conn
+	config  ClientConfig
+	encoder protocol.Encoder
+	agency  uint8
+	metrics Metrics
+	rejects *rejectsFile
+	log     *logging.Logger
+	// commitment Published by the central before bets open, nil if the draw
+	// is not verified
+	commitment *[protocol.CommitmentSize]byte
+	// sequence Last batch sequence sent, resumed from the central
+	sequence uint32
+	resumeAt uint32
+
+	mu    sync.Mutex
+	conn  net.Conn
+	dials int
+
+	quit     chan struct{}
+	quitOnce sync.Once
 }
 
 // NewClient Initializes a new client receiving the configuration
@@ -30,60 +110,750 @@ type Client struct {
 func NewClient(config ClientConfig) *Client {
 	client := &Client{
 		config: config,
+		encoder: protocol.Encoder{
+			NameLimit:  config.NameLimit,
+			NamePolicy: config.NamePolicy,
+		},
+		log:  logging.MustGetLogger(LoggerModule(config.ID)),
+		quit: make(chan struct{}),
 	}
 	return client
 }
 
+// Metrics Returns the counters collected by the client
+func (c *Client) Metrics() Metrics {
+	return c.metrics
+}
+
+// Shutdown Stops the client. Any blocking operation is interrupted by closing
+// the connection to the server. Safe to call more than once and from any
+// goroutine
+func (c *Client) Shutdown() {
+	c.quitOnce.Do(func() {
+		close(c.quit)
+		c.closeClientSocket()
+	})
+}
+
+func (c *Client) stopped() bool {
+	select {
+	case <-c.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// sleep Waits d or until the client is stopped. Returns false in the latter
+func (c *Client) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-c.quit:
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
 // CreateClientSocket Initializes client socket. In case of
-// failure, error is printed in stdout/stderr and exit 1
-// is returned
+// failure, error is printed in stdout/stderr and returned
 func (c *Client) createClientSocket() error {
 	conn, err := net.Dial("tcp", c.config.ServerAddress)
 	if err != nil {
-		log.Criticalf(
+		c.log.Errorf(
 			"action: connect | result: fail | client_id: %v | error: %v",
 			c.config.ID,
 			err,
 		)
+		return err
 	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopped() {
+		conn.Close()
+		return ErrShutdown
+	}
+	if c.dials > 0 {
+		c.metrics.Reconnects++
+	}
+	c.dials++
 	c.conn = conn
 	return nil
 }
 
-// StartClientLoop Send messages to the client until some time threshold is met
-func (c *Client) StartClientLoop() {
-	// There is an autoincremental msgID to identify every message sent
-	// Messages if the message amount threshold has not been surpassed
-	for msgID := 1; msgID <= c.config.LoopAmount; msgID++ {
-		// Create the connection the server in every loop iteration. Send an
-		c.createClientSocket()
+func (c *Client) closeClientSocket() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+		c.log.Debugf("action: close_connection | result: success | client_id: %v", c.config.ID)
+	}
+}
+
+func (c *Client) currentConn() net.Conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn
+}
 
-		// TODO: Modify the send to avoid short-write
-		fmt.Fprintf(
-			c.conn,
-			"[CLIENT %v] Message N°%v\n",
+// exchange Sends a message over the current connection and waits up to
+// timeout for the response
+func (c *Client) exchange(msg protocol.Message, timeout time.Duration) (protocol.Message, error) {
+	conn := c.currentConn()
+	if conn == nil {
+		return nil, ErrShutdown
+	}
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+	if err := c.encoder.WriteMessage(conn, msg); err != nil {
+		return nil, err
+	}
+	return protocol.ReadMessage(conn)
+}
+
+// request Sends a message and waits for the response. If the connection fails
+// it is re-established and the message is sent again, up to MaxRetries times
+func (c *Client) request(msg protocol.Message) (protocol.Message, error) {
+	var lastErr error
+	for attempt := 0; attempt <= c.config.MaxRetries; attempt++ {
+		if attempt > 0 && !c.sleep(c.config.RetryPeriod) {
+			return nil, ErrShutdown
+		}
+		if c.stopped() {
+			return nil, ErrShutdown
+		}
+		if c.currentConn() == nil {
+			if lastErr = c.createClientSocket(); lastErr != nil {
+				c.metrics.Errors++
+				continue
+			}
+		}
+
+		response, err := c.exchange(msg, c.config.Timeout)
+		if err == nil {
+			return response, nil
+		}
+		if c.stopped() {
+			return nil, ErrShutdown
+		}
+		c.metrics.Errors++
+		lastErr = err
+		c.log.Warningf("action: %v | result: fail | client_id: %v | attempt: %v | error: %v",
+			msg.Type(),
 			c.config.ID,
-			msgID,
+			attempt+1,
+			err,
 		)
-		msg, err := bufio.NewReader(c.conn).ReadString('\n')
-		c.conn.Close()
+		c.closeClientSocket()
+	}
+	return nil, lastErr
+}
+
+// StartClientLoop Sends every bet of the agency file in batches, notifies the
+// server that the agency finished and asks for its winners until the draw
+// happens or LoopAmount queries were made
+func (c *Client) StartClientLoop() error {
+	defer c.closeClientSocket()
+
+	if err := c.parseAgency(); err != nil {
+		return err
+	}
+	if c.config.BatchMaxAmount < 1 || c.config.BatchMaxAmount > protocol.MaxBatchAmount {
+		return errors.Errorf("batch max amount must be between 1 and %d", protocol.MaxBatchAmount)
+	}
+
+	if c.config.VerifyDraw {
+		if err := c.fetchCommitment(); err != nil {
+			return err
+		}
+	}
+	finished, err := c.resume()
+	if err != nil {
+		return err
+	}
+	if !finished {
+		if err := c.sendBets(); err != nil {
+			return err
+		}
+		if err := c.sendEndOfBets(); err != nil {
+			return err
+		}
+	}
+	if c.config.PushWinners {
+		if done, err := c.awaitWinners(); done || err != nil {
+			return err
+		}
+	}
+	return c.queryWinners()
+}
+
+// SendBet Validates a single bet and registers it in the server. This is the
+// mode in which the agency submits the bet of one person, read from the
+// NOMBRE, APELLIDO, DOCUMENTO, NACIMIENTO, NUMERO and MONTO environment
+// variables
+func (c *Client) SendBet(row dataset.Row) error {
+	defer c.closeClientSocket()
+
+	if err := c.parseAgency(); err != nil {
+		c.log.Errorf("action: apuesta_enviada | result: fail | dni: %v | numero: %v | error: %v",
+			row.Document,
+			row.Number,
+			err,
+		)
+		return err
+	}
+	bet, err := parseBet(row, c.encoder, c.log)
+	if err != nil {
+		c.metrics.Rejected++
+		c.log.Errorf("action: apuesta_enviada | result: fail | dni: %v | numero: %v | error: %v",
+			row.Document,
+			row.Number,
+			err,
+		)
+		return err
+	}
+
+	// The bet is sent as the next batch of the agency, so the central does not
+	// store it twice if the ack is lost and the request is retried
+	if _, err := c.resume(); err != nil {
+		c.log.Errorf("action: apuesta_enviada | result: fail | dni: %v | numero: %v | error: %v",
+			bet.Document,
+			protocol.FormatNumbers(bet),
+			err,
+		)
+		return err
+	}
+	c.sequence = c.resumeAt + 1
+	batch := &protocol.BetBatch{Agency: c.agency, Contest: c.config.Contest, Sequence: c.sequence, Bets: []protocol.Bet{bet}}
+	response, err := c.request(batch)
+	ack, ok := response.(*protocol.Ack)
+	if err == nil && (!ok || ack.Status != protocol.StatusOK) {
+		err = errors.Errorf("bet rejected by the server: %v", describe(response))
+	}
+	if err != nil {
+		c.log.Errorf("action: apuesta_enviada | result: fail | dni: %v | numero: %v | error: %v",
+			bet.Document,
+			protocol.FormatNumbers(bet),
+			err,
+		)
+		return err
+	}
+
+	c.metrics.Batches++
+	c.metrics.BetsSent++
+	c.log.Infof("action: apuesta_enviada | result: success | dni: %v | numero: %v", bet.Document, protocol.FormatNumbers(bet))
+	c.log.Debugf("action: apuesta_enviada | result: success | client_id: %v | cantidad: 1 | ids: %v-%v", c.config.ID, ack.BetID, ack.BetID)
+	return nil
+}
+
+// CancelBet Voids a bet the agency sent to the contest while it is open. The
+// bet is given by the id the central answered with when it was stored or, if
+// id is zero, by the document and number of the row
+func (c *Client) CancelBet(id uint32, row dataset.Row) error {
+	defer c.closeClientSocket()
+
+	cancel, err := c.cancelRequest(id, row)
+	if err == nil {
+		var response protocol.Message
+		if response, err = c.request(cancel); err == nil {
+			ack, ok := response.(*protocol.Ack)
+			switch {
+			case contestClosed(response):
+				err = ErrContestClosed
+			case ok && ack.Status == protocol.StatusNotFound:
+				err = errors.New("the agency has no such bet")
+			case !ok || ack.Status != protocol.StatusOK:
+				err = errors.Errorf("cancellation rejected by the server: %v", describe(response))
+			default:
+				id = ack.BetID
+			}
+		}
+	}
+	if err != nil {
+		c.log.Errorf("action: anular_apuesta | result: fail | client_id: %v | id: %v | dni: %v | numero: %v | error: %v",
+			c.config.ID,
+			id,
+			row.Document,
+			row.Number,
+			err,
+		)
+		return err
+	}
+	c.log.Infof("action: anular_apuesta | result: success | client_id: %v | id: %v", c.config.ID, id)
+	return nil
+}
+
+// BetStatus Asks the central for the bets of the agency placed by the
+// document and logs the state of every one of them
+func (c *Client) BetStatus(document string) ([]protocol.BetState, error) {
+	defer c.closeClientSocket()
+
+	states, more, err := c.queryBetStatus(document)
+	if err != nil {
+		c.log.Errorf("action: consulta_apuestas | result: fail | client_id: %v | dni: %v | error: %v", c.config.ID, document, err)
+		return nil, err
+	}
+	for _, state := range states {
+		c.log.Infof("action: estado_apuesta | result: success | client_id: %v | id: %v | dni: %v | numero: %v | monto: %v | estado: %v | a_pagar: %v",
+			c.config.ID,
+			state.ID,
+			state.Bet.Document,
+			protocol.FormatNumbers(state.Bet),
+			protocol.FormatAmount(uint64(state.Bet.Stake)),
+			state.Status,
+			protocol.FormatAmount(state.Payout),
+		)
+	}
+	c.log.Infof("action: consulta_apuestas | result: success | client_id: %v | dni: %v | apuestas: %v | incompleta: %v", c.config.ID, document, len(states), more)
+	return states, nil
+}
+
+func (c *Client) queryBetStatus(document string) ([]protocol.BetState, bool, error) {
+	if err := c.parseAgency(); err != nil {
+		return nil, false, err
+	}
+	if !isDigits(document) {
+		return nil, false, errors.Errorf("document %q is not numeric", document)
+	}
+	parsed, err := strconv.ParseUint(document, 10, 64)
+	if err != nil {
+		return nil, false, errors.Errorf("document %q does not fit in 8 bytes", document)
+	}
+	response, err := c.request(&protocol.BetStatusQuery{Agency: c.agency, Contest: c.config.Contest, Document: parsed})
+	if err != nil {
+		return nil, false, err
+	}
+	statuses, ok := response.(*protocol.BetStatuses)
+	if !ok {
+		return nil, false, errors.Errorf("unexpected response %v", describe(response))
+	}
+	return statuses.Bets, statuses.More, nil
+}
+
+// cancelRequest Returns the message that cancels the bet
+func (c *Client) cancelRequest(id uint32, row dataset.Row) (*protocol.CancelBet, error) {
+	if err := c.parseAgency(); err != nil {
+		return nil, err
+	}
+	cancel := &protocol.CancelBet{Agency: c.agency, Contest: c.config.Contest, BetID: id}
+	if id != 0 {
+		return cancel, nil
+	}
+	if !isDigits(row.Document) {
+		return nil, invalidBet(row, "document %q is not numeric", row.Document)
+	}
+	document, err := strconv.ParseUint(row.Document, 10, 64)
+	if err != nil {
+		return nil, invalidBet(row, "document %q does not fit in 8 bytes", row.Document)
+	}
+	numbers, err := parseNumbers(row)
+	if err != nil {
+		return nil, err
+	}
+	cancel.Document = document
+	cancel.Numbers = numbers
+	return cancel, nil
+}
 
+func (c *Client) parseAgency() error {
+	agency, err := strconv.ParseUint(c.config.ID, 10, 8)
+	if err != nil {
+		return errors.Wrapf(err, "invalid agency id %q", c.config.ID)
+	}
+	c.agency = uint8(agency)
+	return nil
+}
+
+// sendBets Reads the agency file and sends its bets in batches of at most
+// BatchMaxAmount bets. Invalid rows are skipped and reported in the rejects
+// file, if one is configured
+func (c *Client) sendBets() error {
+	file, err := os.Open(c.config.DatasetPath)
+	if err != nil {
+		c.log.Errorf("action: open_dataset | result: fail | client_id: %v | error: %v", c.config.ID, err)
+		return err
+	}
+	defer file.Close()
+
+	if c.config.RejectsPath != "" {
+		rejects, err := createRejectsFile(c.config.RejectsPath)
+		if err != nil {
+			c.log.Errorf("action: open_rejects | result: fail | client_id: %v | error: %v", c.config.ID, err)
+			return err
+		}
+		c.rejects = rejects
+		defer func() {
+			if err := rejects.close(); err != nil {
+				c.log.Errorf("action: close_rejects | result: fail | client_id: %v | error: %v", c.config.ID, err)
+			}
+			c.rejects = nil
+		}()
+	}
+
+	reader := dataset.NewReader(file)
+	batch := &protocol.BetBatch{Agency: c.agency, Contest: c.config.Contest}
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var malformed *dataset.MalformedRowError
+		if errors.As(err, &malformed) {
+			if err := c.reject(malformed.Line, malformed.Reason, nil); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			c.log.Errorf("action: read_dataset | result: fail | client_id: %v | error: %v", c.config.ID, err)
+			return err
+		}
+
+		bet, err := parseBet(row, c.encoder, c.log)
+		var invalid *InvalidBetError
+		if errors.As(err, &invalid) {
+			if err := c.reject(invalid.Line, invalid.Reason, row.Fields()); err != nil {
+				return err
+			}
+			continue
+		}
+
+		batch.Bets = append(batch.Bets, bet)
+		if len(batch.Bets) >= c.config.BatchMaxAmount {
+			if err := c.sendBatch(batch); err != nil {
+				return err
+			}
+			batch.Bets = batch.Bets[:0]
+		}
+	}
+	if len(batch.Bets) > 0 {
+		if err := c.sendBatch(batch); err != nil {
+			return err
+		}
+	}
+	if c.metrics.Rejected > 0 {
+		c.log.Warningf("action: validar_apuestas | result: fail | client_id: %v | rechazadas: %v",
+			c.config.ID,
+			c.metrics.Rejected,
+		)
+	}
+	return nil
+}
+
+// reject Skips an invalid row of the agency file
+func (c *Client) reject(line int, reason string, fields []string) error {
+	c.metrics.Rejected++
+	c.log.Warningf("action: validar_apuesta | result: fail | client_id: %v | line: %v | reason: %v",
+		c.config.ID,
+		line,
+		reason,
+	)
+	if c.rejects == nil {
+		return nil
+	}
+	if err := c.rejects.write(line, reason, fields); err != nil {
+		c.log.Errorf("action: write_rejects | result: fail | client_id: %v | error: %v", c.config.ID, err)
+		return err
+	}
+	return nil
+}
+
+// sendBatch Sends the next batch of the agency. Batches the central stored
+// before a restart are skipped, since the file yields the same batches again
+func (c *Client) sendBatch(batch *protocol.BetBatch) error {
+	c.sequence++
+	batch.Sequence = c.sequence
+	if batch.Sequence <= c.resumeAt {
+		c.metrics.Resumed += len(batch.Bets)
+		return nil
+	}
+
+	start := time.Now()
+	response, err := c.request(batch)
+	if err != nil {
+		c.log.Errorf("action: apuesta_enviada | result: fail | client_id: %v | cantidad: %v | error: %v",
+			c.config.ID,
+			len(batch.Bets),
+			err,
+		)
+		return err
+	}
+	c.metrics.BatchRTTs = append(c.metrics.BatchRTTs, time.Since(start))
+
+	if contestClosed(response) {
+		c.log.Errorf("action: apuesta_enviada | result: fail | client_id: %v | cantidad: %v | error: %v",
+			c.config.ID,
+			len(batch.Bets),
+			ErrContestClosed,
+		)
+		return ErrContestClosed
+	}
+	ack, ok := response.(*protocol.Ack)
+	if !ok || ack.Status != protocol.StatusOK {
+		c.metrics.Errors++
+		c.log.Errorf("action: apuesta_enviada | result: fail | client_id: %v | cantidad: %v | response: %v",
+			c.config.ID,
+			len(batch.Bets),
+			describe(response),
+		)
+		return errors.Errorf("batch rejected by the server: %v", describe(response))
+	}
+
+	c.metrics.Batches++
+	c.metrics.BetsSent += len(batch.Bets)
+	c.log.Debugf("action: apuesta_enviada | result: success | client_id: %v | cantidad: %v | ids: %v-%v",
+		c.config.ID,
+		len(batch.Bets),
+		ack.BetID,
+		ack.BetID+uint32(len(batch.Bets))-1,
+	)
+	return nil
+}
+
+func (c *Client) sendEndOfBets() error {
+	response, err := c.request(&protocol.EndOfBets{Agency: c.agency, Contest: c.config.Contest})
+	if err == nil && contestClosed(response) {
+		err = ErrContestClosed
+	} else if err == nil {
+		if ack, ok := response.(*protocol.Ack); !ok || ack.Status != protocol.StatusOK {
+			err = errors.Errorf("unexpected response %v", describe(response))
+		}
+	}
+	if err != nil {
+		c.log.Errorf("action: fin_apuestas | result: fail | client_id: %v | error: %v", c.config.ID, err)
+		return err
+	}
+	c.log.Infof("action: fin_apuestas | result: success | client_id: %v", c.config.ID)
+	return nil
+}
+
+// awaitWinners Waits on the open connection for the central to push the
+// winners of the agency. Returns false if they did not arrive within
+// PushTimeout or the central does not support it, so the client falls back to
+// polling
+func (c *Client) awaitWinners() (bool, error) {
+	if c.currentConn() == nil {
+		if err := c.createClientSocket(); err != nil {
+			if c.stopped() {
+				return false, ErrShutdown
+			}
+			c.metrics.Errors++
+			return false, nil
+		}
+	}
+
+	response, err := c.exchange(&protocol.AwaitWinners{Agency: c.agency, Contest: c.config.Contest}, c.config.PushTimeout)
+	if c.stopped() {
+		return false, ErrShutdown
+	}
+	if err == nil {
+		if winners, ok := response.(*protocol.Winners); ok {
+			return true, c.receiveWinners(winners)
+		}
+		if contestClosed(response) {
+			c.log.Errorf("action: esperar_ganadores | result: fail | client_id: %v | error: %v", c.config.ID, ErrContestClosed)
+			return true, ErrContestClosed
+		}
+		err = errors.Errorf("unexpected response %v", describe(response))
+	}
+
+	// The connection may still hold the late response, so it is not reused
+	c.metrics.Errors++
+	c.log.Warningf("action: esperar_ganadores | result: fail | client_id: %v | fallback: polling | error: %v", c.config.ID, err)
+	c.closeClientSocket()
+	return false, nil
+}
+
+// queryWinners Asks for the winners of the agency every LoopPeriod until the
+// server answers with them
+func (c *Client) queryWinners() error {
+	for attempt := 1; attempt <= c.config.LoopAmount; attempt++ {
+		response, err := c.request(&protocol.WinnersQuery{Agency: c.agency, Contest: c.config.Contest})
 		if err != nil {
-			log.Errorf("action: receive_message | result: fail | client_id: %v | error: %v",
-				c.config.ID,
-				err,
-			)
-			return
+			c.log.Errorf("action: consulta_ganadores | result: fail | client_id: %v | error: %v", c.config.ID, err)
+			return err
 		}
 
-		log.Infof("action: receive_message | result: success | client_id: %v | msg: %v",
+		switch r := response.(type) {
+		case *protocol.Winners:
+			return c.receiveWinners(r)
+		case *protocol.Ack:
+			if r.Status == protocol.StatusContestClosed {
+				c.log.Errorf("action: consulta_ganadores | result: fail | client_id: %v | error: %v", c.config.ID, ErrContestClosed)
+				return ErrContestClosed
+			}
+			if r.Status != protocol.StatusDrawNotReady {
+				return c.unexpectedWinnersResponse(response)
+			}
+		default:
+			return c.unexpectedWinnersResponse(response)
+		}
+
+		c.log.Debugf("action: consulta_ganadores | result: in_progress | client_id: %v | attempt: %v", c.config.ID, attempt)
+		// Wait a time between asking for the winners and asking again
+		if !c.sleep(c.config.LoopPeriod) {
+			return ErrShutdown
+		}
+	}
+
+	err := errors.Errorf("draw did not happen after %d queries", c.config.LoopAmount)
+	c.log.Errorf("action: consulta_ganadores | result: fail | client_id: %v | error: %v", c.config.ID, err)
+	return err
+}
+
+// resume Asks the central where the agency left off. Returns true if the
+// agency already finished sending bets
+func (c *Client) resume() (bool, error) {
+	response, err := c.request(&protocol.ResumeQuery{Agency: c.agency, Contest: c.config.Contest})
+	if err == nil && contestClosed(response) {
+		err = ErrContestClosed
+	} else if err == nil {
+		if resume, ok := response.(*protocol.Resume); ok {
+			c.resumeAt = resume.Sequence
+			if resume.Sequence > 0 || resume.Finished {
+				c.log.Infof("action: reanudar | result: success | client_id: %v | secuencia: %v | finalizada: %v",
+					c.config.ID,
+					resume.Sequence,
+					resume.Finished,
+				)
+			}
+			return resume.Finished, nil
+		}
+		err = errors.Errorf("unexpected response %v", describe(response))
+	}
+	c.log.Errorf("action: reanudar | result: fail | client_id: %v | error: %v", c.config.ID, err)
+	return false, err
+}
+
+// fetchCommitment Asks for the commitment to the seed of the draw. It must be
+// known before any bet is sent, so the central cannot choose the seed once it
+// knows the bets
+func (c *Client) fetchCommitment() error {
+	response, err := c.request(&protocol.CommitmentQuery{Agency: c.agency, Contest: c.config.Contest})
+	if err == nil {
+		if commitment, ok := response.(*protocol.Commitment); ok {
+			c.commitment = &commitment.Hash
+			c.log.Infof("action: commitment | result: success | client_id: %v | hash: %x", c.config.ID, commitment.Hash)
+			return nil
+		}
+		err = errors.Errorf("unexpected response %v", describe(response))
+	}
+	c.log.Errorf("action: commitment | result: fail | client_id: %v | error: %v", c.config.ID, err)
+	return err
+}
+
+// verifyDraw Checks that the revealed seed matches the commitment and that
+// the winning number was derived from it
+func (c *Client) verifyDraw(winners *protocol.Winners) error {
+	if len(winners.Seed) == 0 {
+		return errors.New("seed was not revealed")
+	}
+	if protocol.Commit(winners.Seed) != *c.commitment {
+		return errors.Errorf("seed %x does not match commitment %x", winners.Seed, *c.commitment)
+	}
+	if number := protocol.WinningNumber(winners.Seed); number != winners.WinningNumber {
+		return errors.Errorf("winning number %d was not derived from the seed, want %d", winners.WinningNumber, number)
+	}
+	return nil
+}
+
+// receiveWinners Records the winners of the agency and writes the winners
+// report, if one is configured. Winners of a draw that cannot be verified or
+// that are not signed by the central are refused, as are those of another
+// contest. Without a configured contest the agency takes part in the default
+// one of the central, whose id is only known from its answer
+func (c *Client) receiveWinners(winners *protocol.Winners) error {
+	if c.config.Contest != "" && winners.Contest != c.config.Contest {
+		err := errors.Errorf("winners of contest %q, not %q", winners.Contest, c.config.Contest)
+		c.log.Errorf("action: consulta_ganadores | result: fail | client_id: %v | error: %v", c.config.ID, err)
+		return err
+	}
+	if c.commitment != nil {
+		if err := c.verifyDraw(winners); err != nil {
+			c.log.Errorf("action: verify_draw | result: fail | client_id: %v | error: %v", c.config.ID, err)
+			return err
+		}
+		c.log.Infof("action: verify_draw | result: success | client_id: %v | numero_ganador: %v", c.config.ID, winners.WinningNumber)
+	}
+	if c.config.PublicKey != nil {
+		if err := protocol.VerifyWinners(c.config.PublicKey, winners.Contest, c.agency, winners); err != nil {
+			c.log.Errorf("action: verify_signature | result: fail | client_id: %v | error: %v", c.config.ID, err)
+			return err
+		}
+		c.log.Infof("action: verify_signature | result: success | client_id: %v", c.config.ID)
+	}
+	c.metrics.Winners = len(winners.Documents)
+	c.log.Infof("action: consulta_ganadores | result: success | cant_ganadores: %v", len(winners.Documents))
+	counts := tierCounts(winners)
+	for digits := protocol.NumberDigits; digits >= 1; digits-- {
+		if count, ok := counts[uint8(digits)]; ok {
+			c.log.Infof("action: ganadores_por_premio | result: success | client_id: %v | cifras: %v | cant_ganadores: %v", c.config.ID, digits, count)
+		}
+	}
+	if winners.Pool > 0 {
+		paid := uint64(0)
+		for i := range winners.Documents {
+			paid += winners.Payout(i)
+		}
+		c.log.Infof("action: premios | result: success | client_id: %v | pozo: %v | a_pagar: %v",
 			c.config.ID,
-			msg,
+			protocol.FormatAmount(winners.Pool),
+			protocol.FormatAmount(paid),
 		)
+	}
+	if c.config.ReportPath == "" {
+		return nil
+	}
 
-		// Wait a time between sending one message and the next one
-		time.Sleep(c.config.LoopPeriod)
+	var report *WinnersReport
+	cancelled, err := c.cancelledBets(winners)
+	if err == nil {
+		report, err = newWinnersReport(c.config, winners, cancelled)
+	}
+	if err == nil {
+		err = report.write(c.config.ReportPath, c.config.ReportFormat)
+	}
+	if err != nil {
+		c.log.Errorf("action: reporte_ganadores | result: fail | client_id: %v | error: %v", c.config.ID, err)
+		return err
+	}
+	if missing := report.missing(); missing > 0 {
+		c.log.Warningf("action: reporte_ganadores | result: fail | client_id: %v | no_encontrados: %v", c.config.ID, missing)
+	}
+	c.log.Infof("action: reporte_ganadores | result: success | client_id: %v | path: %v", c.config.ID, c.config.ReportPath)
+	return nil
+}
+
+// tierCounts Returns how many winners there are in every prize tier
+func tierCounts(winners *protocol.Winners) map[uint8]int {
+	counts := make(map[uint8]int)
+	for i := range winners.Documents {
+		counts[winners.Tier(i)]++
+	}
+	return counts
+}
+
+func (c *Client) unexpectedWinnersResponse(response protocol.Message) error {
+	err := errors.Errorf("unexpected response %v", describe(response))
+	c.log.Errorf("action: consulta_ganadores | result: fail | client_id: %v | error: %v", c.config.ID, err)
+	return err
+}
+
+// contestClosed Returns whether the central answered that the contest was
+// closed before the agency finished
+func contestClosed(response protocol.Message) bool {
+	ack, ok := response.(*protocol.Ack)
+	return ok && ack.Status == protocol.StatusContestClosed
+}
 
+// describe Returns a short representation of a response for logging
+func describe(msg protocol.Message) string {
+	if ack, ok := msg.(*protocol.Ack); ok {
+		return ack.Status.String()
 	}
-	log.Infof("action: loop_finished | result: success | client_id: %v", c.config.ID)
+	return msg.Type().String()
 }
@@ -0,0 +1,628 @@
+package common
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/dataset"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/servertest"
+)
+
+// writeDataset Writes an agency file with n valid bets and returns its path
+func writeDataset(t *testing.T, n int) string {
+	t.Helper()
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "Nombre %d,Apellido,%d,1999-03-17,%d\n", i, 30000000+i, i)
+	}
+	path := filepath.Join(t.TempDir(), "agency-1.csv")
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func startServer(t *testing.T) *servertest.Server {
+	t.Helper()
+	server, err := servertest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+	return server
+}
+
+func testConfig(server *servertest.Server, datasetPath string) ClientConfig {
+	return ClientConfig{
+		ID:             "1",
+		ServerAddress:  server.Addr(),
+		LoopAmount:     5,
+		LoopPeriod:     time.Millisecond,
+		BatchMaxAmount: 10,
+		DatasetPath:    datasetPath,
+		Timeout:        time.Second,
+		MaxRetries:     2,
+		RetryPeriod:    time.Millisecond,
+	}
+}
+
+func TestClientSendsBetsInBatches(t *testing.T) {
+	server := startServer(t)
+	server.On(protocol.MsgWinnersQuery, servertest.Winners(30000003, 30000007))
+
+	client := NewClient(testConfig(server, writeDataset(t, 25)))
+	if err := client.StartClientLoop(); err != nil {
+		t.Fatalf("StartClientLoop: %v", err)
+	}
+
+	var sizes []int
+	for _, msg := range server.Messages(protocol.MsgBetBatch) {
+		batch := msg.(*protocol.BetBatch)
+		if batch.Agency != 1 {
+			t.Errorf("batch sent with agency %d", batch.Agency)
+		}
+		sizes = append(sizes, len(batch.Bets))
+	}
+	if fmt.Sprint(sizes) != "[10 10 5]" {
+		t.Errorf("batch sizes %v, want [10 10 5]", sizes)
+	}
+
+	frames := server.Frames()
+	if last := frames[len(frames)-1].Message.Type(); last != protocol.MsgWinnersQuery {
+		t.Errorf("last message was %v", last)
+	}
+	if len(server.Messages(protocol.MsgEndOfBets)) != 1 {
+		t.Error("end of bets was not sent exactly once")
+	}
+	metrics := client.Metrics()
+	if metrics.BetsSent != 25 || metrics.Winners != 2 || server.Connections() != 1 {
+		t.Errorf("metrics %+v over %d connections", metrics, server.Connections())
+	}
+}
+
+func TestClientRetriesBatchAfterDroppedConnection(t *testing.T) {
+	server := startServer(t)
+	server.Script(protocol.MsgBetBatch,
+		servertest.Drop(),
+		servertest.Truncated(&protocol.Ack{}, 2),
+	)
+
+	client := NewClient(testConfig(server, writeDataset(t, 5)))
+	if err := client.StartClientLoop(); err != nil {
+		t.Fatalf("StartClientLoop: %v", err)
+	}
+
+	batches := server.Messages(protocol.MsgBetBatch)
+	if len(batches) != 3 {
+		t.Fatalf("batch sent %d times, want 3", len(batches))
+	}
+	metrics := client.Metrics()
+	if metrics.Reconnects != 2 || metrics.BetsSent != 5 || server.Connections() != 3 {
+		t.Errorf("metrics %+v over %d connections", metrics, server.Connections())
+	}
+}
+
+func TestClientGivesUpAfterMaxRetries(t *testing.T) {
+	server := startServer(t)
+	server.On(protocol.MsgBetBatch, servertest.Drop())
+
+	client := NewClient(testConfig(server, writeDataset(t, 5)))
+	if err := client.StartClientLoop(); err == nil {
+		t.Fatal("expected an error")
+	}
+	if n := len(server.Messages(protocol.MsgBetBatch)); n != 3 {
+		t.Errorf("batch sent %d times, want 3", n)
+	}
+}
+
+func TestClientStopsOnRejectedBatch(t *testing.T) {
+	server := startServer(t)
+	server.Script(protocol.MsgBetBatch, servertest.Fail())
+
+	client := NewClient(testConfig(server, writeDataset(t, 15)))
+	if err := client.StartClientLoop(); err == nil {
+		t.Fatal("expected an error")
+	}
+	if n := len(server.Messages(protocol.MsgBetBatch)); n != 1 {
+		t.Errorf("%d batches sent after a rejection", n)
+	}
+	if n := len(server.Messages(protocol.MsgEndOfBets)); n != 0 {
+		t.Error("end of bets sent after a rejection")
+	}
+}
+
+func TestClientPollsWinnersUntilDraw(t *testing.T) {
+	server := startServer(t)
+	server.Script(protocol.MsgWinnersQuery,
+		servertest.DrawNotReady(),
+		servertest.Delay(10*time.Millisecond, servertest.DrawNotReady()),
+	)
+	server.On(protocol.MsgWinnersQuery, servertest.Fragmented(&protocol.Winners{Documents: []uint64{1, 2, 3}}, 3, time.Millisecond))
+
+	client := NewClient(testConfig(server, writeDataset(t, 1)))
+	if err := client.StartClientLoop(); err != nil {
+		t.Fatalf("StartClientLoop: %v", err)
+	}
+	if n := len(server.Messages(protocol.MsgWinnersQuery)); n != 3 {
+		t.Errorf("winners asked %d times, want 3", n)
+	}
+	if client.Metrics().Winners != 3 {
+		t.Errorf("got %d winners, want 3", client.Metrics().Winners)
+	}
+}
+
+func TestClientFailsWhenDrawNeverHappens(t *testing.T) {
+	server := startServer(t)
+	server.On(protocol.MsgWinnersQuery, servertest.DrawNotReady())
+
+	config := testConfig(server, writeDataset(t, 1))
+	client := NewClient(config)
+	if err := client.StartClientLoop(); err == nil {
+		t.Fatal("expected an error")
+	}
+	if n := len(server.Messages(protocol.MsgWinnersQuery)); n != config.LoopAmount {
+		t.Errorf("winners asked %d times, want %d", n, config.LoopAmount)
+	}
+}
+
+func TestClientSkipsInvalidRowsAndReportsThem(t *testing.T) {
+	server := startServer(t)
+	dir := t.TempDir()
+	datasetPath := filepath.Join(dir, "agency-1.csv")
+	rows := strings.Join([]string{
+		"Santiago Lionel,Lorca,30904465,1999-03-17,7574",
+		"Agustin Emanuel,Zambrano,2168919A,2000-05-10,9325",
+		"Tiago Nicolás,Rivera,34407251,2001-02-30,1033",
+		"solo,dos campos",
+		",Perez,30000000,1980-01-01,1",
+		"Juan,Perez,30000001,1980-01-01,70000,150",
+		"Juana,Perez,30000002,1980-01-01,1",
+	}, "\n")
+	if err := os.WriteFile(datasetPath, []byte(rows), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config := testConfig(server, datasetPath)
+	config.RejectsPath = filepath.Join(dir, "rejects.csv")
+	client := NewClient(config)
+	if err := client.StartClientLoop(); err != nil {
+		t.Fatalf("StartClientLoop: %v", err)
+	}
+
+	if metrics := client.Metrics(); metrics.BetsSent != 2 || metrics.Rejected != 5 {
+		t.Errorf("sent %d bets and rejected %d, want 2 and 5", metrics.BetsSent, metrics.Rejected)
+	}
+	rejects, err := os.ReadFile(config.RejectsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(rejects)), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("rejects file:\n%s", rejects)
+	}
+	// Every record has the columns of the header
+	records, err := csv.NewReader(bytes.NewReader(rejects)).ReadAll()
+	if err != nil {
+		t.Fatalf("rejects file is not a valid CSV: %v", err)
+	}
+	if got := records[5][len(records[5])-1]; records[0][len(records[0])-1] != "stake" || got != "150" {
+		t.Errorf("stake column %q holds %q, want stake 150", records[0][len(records[0])-1], got)
+	}
+	for i, line := range []string{"2,", "3,", "4,", "5,", "6,"} {
+		if !strings.HasPrefix(lines[i+1], line) {
+			t.Errorf("reject %d is %q, want line %s", i, lines[i+1], line)
+		}
+	}
+}
+
+func TestClientSendsCombinedBets(t *testing.T) {
+	server := startServer(t)
+	datasetPath := filepath.Join(t.TempDir(), "agency-1.csv")
+	rows := strings.Join([]string{
+		"Santiago Lionel,Lorca,30904465,1999-03-17,7574/1033/12,100",
+		"Juan,Perez,30000001,1980-01-01,7574/7574",
+		"Juana,Perez,30000002,1980-01-01,1/10000",
+		"Ana,Perez,30000003,1980-01-01,1/2/3/4/5/6/7/8/9/10/11",
+		"Pedro,Perez,30000004,1980-01-01,65535",
+	}, "\n")
+	if err := os.WriteFile(datasetPath, []byte(rows), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	client := NewClient(testConfig(server, datasetPath))
+	if err := client.StartClientLoop(); err != nil {
+		t.Fatalf("StartClientLoop: %v", err)
+	}
+	if metrics := client.Metrics(); metrics.BetsSent != 1 || metrics.Rejected != 4 {
+		t.Errorf("sent %d bets and rejected %d, want 1 and 4", metrics.BetsSent, metrics.Rejected)
+	}
+	want := protocol.Bet{
+		FirstName: "Santiago Lionel",
+		LastName:  "Lorca",
+		Document:  30904465,
+		Birthdate: "1999-03-17",
+		Type:      protocol.BetCombined,
+		Numbers:   []uint16{7574, 1033, 12},
+		Stake:     10000,
+	}
+	batches := server.Messages(protocol.MsgBetBatch)
+	if len(batches) != 1 || !reflect.DeepEqual(batches[0].(*protocol.BetBatch).Bets, []protocol.Bet{want}) {
+		t.Errorf("sent %+v, want %+v", batches, want)
+	}
+}
+
+func TestClientSendsSingleBet(t *testing.T) {
+	server := startServer(t)
+	client := NewClient(testConfig(server, ""))
+
+	row := dataset.Row{FirstName: "Santiago Lionel", LastName: "Lorca", Document: "30904465", Birthdate: "1999-03-17", Number: "7574", Stake: "150.50"}
+	if err := client.SendBet(row); err != nil {
+		t.Fatalf("SendBet: %v", err)
+	}
+	batches := server.Messages(protocol.MsgBetBatch)
+	if len(batches) != 1 {
+		t.Fatalf("%d frames sent, want 1", len(batches))
+	}
+	want := protocol.Bet{FirstName: "Santiago Lionel", LastName: "Lorca", Document: 30904465, Birthdate: "1999-03-17", Number: 7574, Stake: 15050}
+	if bets := batches[0].(*protocol.BetBatch).Bets; len(bets) != 1 || !reflect.DeepEqual(bets[0], want) {
+		t.Errorf("sent %+v, want %+v", bets, want)
+	}
+
+	row.Stake = "150,50"
+	if err := client.SendBet(row); err == nil {
+		t.Error("expected an invalid stake error")
+	}
+	row.Stake = ""
+	row.Birthdate = "1999-13-17"
+	if err := client.SendBet(row); err == nil {
+		t.Error("expected an invalid bet error")
+	}
+	server.Script(protocol.MsgBetBatch, servertest.Fail())
+	row.Birthdate = "1999-03-17"
+	if err := client.SendBet(row); err == nil {
+		t.Error("expected a rejected bet error")
+	}
+	if n := len(server.Messages(protocol.MsgBetBatch)); n != 2 {
+		t.Errorf("%d frames sent, want 2", n)
+	}
+}
+
+func TestClientRetriesSingleBetWithItsSequence(t *testing.T) {
+	server := startServer(t)
+	server.On(protocol.MsgResumeQuery, servertest.Reply(&protocol.Resume{Sequence: 4}))
+	// The ack of the first attempt is lost
+	server.Script(protocol.MsgBetBatch, servertest.Drop())
+	client := NewClient(testConfig(server, ""))
+
+	row := dataset.Row{FirstName: "Santiago Lionel", LastName: "Lorca", Document: "30904465", Birthdate: "1999-03-17", Number: "7574"}
+	if err := client.SendBet(row); err != nil {
+		t.Fatalf("SendBet: %v", err)
+	}
+	batches := server.Messages(protocol.MsgBetBatch)
+	if len(batches) != 2 {
+		t.Fatalf("%d frames sent, want 2", len(batches))
+	}
+	for i, batch := range batches {
+		if sequence := batch.(*protocol.BetBatch).Sequence; sequence != 5 {
+			t.Errorf("attempt %d sent with sequence %d, want 5", i+1, sequence)
+		}
+	}
+}
+
+func TestClientCancelsBets(t *testing.T) {
+	server := startServer(t)
+	server.Script(protocol.MsgCancelBet,
+		servertest.Reply(&protocol.Ack{Status: protocol.StatusOK, BetID: 12}),
+		servertest.Reply(&protocol.Ack{Status: protocol.StatusOK, BetID: 3}),
+		servertest.Ack(protocol.StatusNotFound),
+	)
+	client := NewClient(testConfig(server, ""))
+
+	if err := client.CancelBet(12, dataset.Row{}); err != nil {
+		t.Fatalf("CancelBet by id: %v", err)
+	}
+	row := dataset.Row{Document: "30904465", Number: "7574/1033"}
+	if err := client.CancelBet(0, row); err != nil {
+		t.Fatalf("CancelBet by document: %v", err)
+	}
+	if err := client.CancelBet(50, dataset.Row{}); err == nil {
+		t.Error("cancel of a bet the central does not have succeeded")
+	}
+	if err := client.CancelBet(0, dataset.Row{Document: "3090446a", Number: "7574"}); err == nil {
+		t.Error("cancel with an invalid document succeeded")
+	}
+
+	want := []protocol.Message{
+		&protocol.CancelBet{Agency: 1, BetID: 12},
+		&protocol.CancelBet{Agency: 1, Document: 30904465, Numbers: []uint16{7574, 1033}},
+		&protocol.CancelBet{Agency: 1, BetID: 50},
+	}
+	if sent := server.Messages(protocol.MsgCancelBet); !reflect.DeepEqual(sent, want) {
+		t.Errorf("sent %+v, want %+v", sent, want)
+	}
+}
+
+func TestClientQueriesTheStatusOfBets(t *testing.T) {
+	server := startServer(t)
+	states := []protocol.BetState{
+		{ID: 1, Status: protocol.BetCancelled, Bet: protocol.Bet{FirstName: "Santiago Lionel", LastName: "Lorca", Document: 30904465, Birthdate: "1999-03-17", Number: 7574}},
+		{ID: 4, Status: protocol.BetWon, Tier: protocol.NumberDigits, Payout: 15050, Bet: protocol.Bet{FirstName: "Santiago Lionel", LastName: "Lorca", Document: 30904465, Birthdate: "1999-03-17", Number: 1033, Stake: 100}},
+	}
+	server.Script(protocol.MsgBetStatusQuery,
+		servertest.Reply(&protocol.BetStatuses{Bets: states}),
+		servertest.Ack(protocol.StatusFail),
+	)
+	client := NewClient(testConfig(server, ""))
+
+	got, err := client.BetStatus("30904465")
+	if err != nil {
+		t.Fatalf("BetStatus: %v", err)
+	}
+	if !reflect.DeepEqual(got, states) {
+		t.Errorf("got %+v, want %+v", got, states)
+	}
+	if _, err := client.BetStatus("30904465"); err == nil {
+		t.Error("rejected query succeeded")
+	}
+	if _, err := client.BetStatus("3090446a"); err == nil {
+		t.Error("query with an invalid document succeeded")
+	}
+	want := &protocol.BetStatusQuery{Agency: 1, Document: 30904465}
+	if sent := server.Messages(protocol.MsgBetStatusQuery); len(sent) != 2 || !reflect.DeepEqual(sent[0], want) {
+		t.Errorf("sent %+v, want two of %+v", sent, want)
+	}
+}
+
+func TestClientFallsBackToPollingWhenPushFails(t *testing.T) {
+	server := startServer(t)
+	server.Script(protocol.MsgAwaitWinners, servertest.Delay(100*time.Millisecond, servertest.Winners(1)))
+	server.On(protocol.MsgWinnersQuery, servertest.Winners(1, 2))
+
+	config := testConfig(server, writeDataset(t, 1))
+	config.PushWinners = true
+	config.PushTimeout = 20 * time.Millisecond
+	client := NewClient(config)
+	if err := client.StartClientLoop(); err != nil {
+		t.Fatalf("StartClientLoop: %v", err)
+	}
+	if client.Metrics().Winners != 2 {
+		t.Errorf("got %d winners, want the 2 of the polled query", client.Metrics().Winners)
+	}
+	if n := len(server.Messages(protocol.MsgWinnersQuery)); n != 1 {
+		t.Errorf("winners asked %d times after the push timed out, want 1", n)
+	}
+}
+
+func TestClientWritesWinnersReport(t *testing.T) {
+	server := startServer(t)
+	drawnAt := time.Date(2026, 10, 18, 21, 0, 0, 0, time.UTC)
+	server.On(protocol.MsgWinnersQuery, servertest.Reply(&protocol.Winners{
+		Contest:       "2026-42",
+		DrawnAt:       drawnAt.UnixNano() / int64(time.Millisecond),
+		WinningNumber: 1002,
+		Documents:     []uint64{30000007, 30000002, 99},
+		Tiers:         []uint8{4, 3, 4},
+		Pool:          1000000,
+		Payouts:       []uint64{150000, 2550, 0},
+	}))
+
+	dir := t.TempDir()
+	path := writeDataset(t, 10)
+	// Document 30000007 won with its second bet, the first one on line 8 lost
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintln(file, "Nombre 7,Apellido,30000007,1999-03-17,1002")
+	// Reaches the tier document 30000002 won, but was cancelled
+	fmt.Fprintln(file, "Nombre 2,Apellido,30000002,1999-03-17,5002")
+	file.Close()
+	server.On(protocol.MsgBetStatusQuery, servertest.Reply(&protocol.BetStatuses{Bets: []protocol.BetState{
+		{ID: 12, Status: protocol.BetCancelled, Bet: protocol.Bet{FirstName: "Nombre 2", LastName: "Apellido", Document: 30000002, Birthdate: "1999-03-17", Number: 5002}},
+	}}))
+	config := testConfig(server, path)
+	config.Contest = "2026-42"
+	config.ReportPath = filepath.Join(dir, "winners.csv")
+	if err := NewClient(config).StartClientLoop(); err != nil {
+		t.Fatalf("StartClientLoop: %v", err)
+	}
+	report, err := os.ReadFile(config.ReportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		"contest,agency,drawn_at,line,first_name,last_name,document,birthdate,number,stake,payout",
+		"2026-42,1,2026-10-18T21:00:00Z,3,Nombre 2,Apellido,30000002,1999-03-17,2,,25.50",
+		"2026-42,1,2026-10-18T21:00:00Z,11,Nombre 7,Apellido,30000007,1999-03-17,1002,,1500.00",
+		"2026-42,1,2026-10-18T21:00:00Z,,,,99,,,,0.00",
+	}, "\n") + "\n"
+	if string(report) != want {
+		t.Errorf("report:\n%s\nwant:\n%s", report, want)
+	}
+
+	config.ReportPath = filepath.Join(dir, "winners.json")
+	config.ReportFormat = ReportJSON
+	if err := NewClient(config).StartClientLoop(); err != nil {
+		t.Fatalf("StartClientLoop: %v", err)
+	}
+	data, err := os.ReadFile(config.ReportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded WinnersReport
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("invalid JSON report: %v", err)
+	}
+	if decoded.Contest != "2026-42" || !decoded.DrawnAt.Equal(drawnAt) || len(decoded.Winners) != 3 || decoded.Winners[0].FirstName != "Nombre 2" {
+		t.Errorf("JSON report %+v", decoded)
+	}
+}
+
+func TestClientVerifiesDrawAgainstCommitment(t *testing.T) {
+	seed := []byte("secret seed of the draw")
+	reveal := func(seed []byte, number uint16) servertest.Action {
+		return servertest.Reply(&protocol.Winners{WinningNumber: number, Seed: seed, Documents: []uint64{30000001}})
+	}
+	tests := []struct {
+		name    string
+		winners servertest.Action
+		ok      bool
+	}{
+		{"valid", reveal(seed, protocol.WinningNumber(seed)), true},
+		{"another seed", reveal([]byte("another seed"), protocol.WinningNumber([]byte("another seed"))), false},
+		{"another number", reveal(seed, protocol.WinningNumber(seed)+1), false},
+		{"no seed", servertest.Winners(30000001), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := startServer(t)
+			server.On(protocol.MsgCommitmentQuery, servertest.Reply(&protocol.Commitment{Hash: protocol.Commit(seed)}))
+			server.On(protocol.MsgWinnersQuery, test.winners)
+
+			config := testConfig(server, writeDataset(t, 3))
+			config.VerifyDraw = true
+			err := NewClient(config).StartClientLoop()
+			if (err == nil) != test.ok {
+				t.Errorf("StartClientLoop: %v", err)
+			}
+			frames := server.Frames()
+			if frames[0].Message.Type() != protocol.MsgCommitmentQuery {
+				t.Errorf("first message was %v, the commitment must be known before sending bets", frames[0].Message.Type())
+			}
+		})
+	}
+}
+
+func TestClientRefusesUnsignedWinners(t *testing.T) {
+	public, private, _ := ed25519.GenerateKey(nil)
+	_, other, _ := ed25519.GenerateKey(nil)
+	signed := func(key ed25519.PrivateKey) servertest.Action {
+		winners := &protocol.Winners{Contest: "7", Documents: []uint64{30000001}}
+		protocol.SignWinners(key, "7", 1, winners)
+		return servertest.Reply(winners)
+	}
+	tests := []struct {
+		name    string
+		winners servertest.Action
+		ok      bool
+	}{
+		{"signed", signed(private), true},
+		{"unsigned", servertest.Winners(30000001), false},
+		{"another key", signed(other), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := startServer(t)
+			server.On(protocol.MsgWinnersQuery, test.winners)
+			config := testConfig(server, writeDataset(t, 3))
+			config.Contest = "7"
+			config.PublicKey = public
+			config.ReportPath = filepath.Join(t.TempDir(), "winners.csv")
+			err := NewClient(config).StartClientLoop()
+			if (err == nil) != test.ok {
+				t.Errorf("StartClientLoop: %v", err)
+			}
+			_, statErr := os.Stat(config.ReportPath + ".sig")
+			if test.ok == os.IsNotExist(statErr) {
+				t.Errorf("signature file: %v", statErr)
+			}
+		})
+	}
+}
+
+func TestClientTakesPartInItsContest(t *testing.T) {
+	server := startServer(t)
+	config := testConfig(server, writeDataset(t, 3))
+	config.Contest = "2026-42"
+	if err := NewClient(config).StartClientLoop(); err != nil {
+		t.Fatalf("StartClientLoop: %v", err)
+	}
+	requests := 0
+	for _, frame := range server.Frames() {
+		if contest, ok := requestContest(frame.Message); ok {
+			requests++
+			if contest != "2026-42" {
+				t.Errorf("%v sent for contest %q", frame.Message.Type(), contest)
+			}
+		}
+	}
+	if requests < 3 {
+		t.Errorf("only %d requests sent", requests)
+	}
+
+	// Winners of another contest are refused
+	server.On(protocol.MsgWinnersQuery, servertest.Reply(&protocol.Winners{Contest: "2026-41"}))
+	if err := NewClient(config).StartClientLoop(); err == nil {
+		t.Error("winners of another contest accepted")
+	}
+}
+
+func TestClientWithoutContestTakesTheDefaultOne(t *testing.T) {
+	public, private, _ := ed25519.GenerateKey(nil)
+	winners := &protocol.Winners{Contest: servertest.DefaultContest, Documents: []uint64{30000001}}
+	if err := protocol.SignWinners(private, servertest.DefaultContest, 1, winners); err != nil {
+		t.Fatal(err)
+	}
+	server := startServer(t)
+	server.On(protocol.MsgWinnersQuery, servertest.Reply(winners))
+	config := testConfig(server, writeDataset(t, 3))
+	config.PublicKey = public
+	config.ReportPath = filepath.Join(t.TempDir(), "winners.json")
+	config.ReportFormat = ReportJSON
+	client := NewClient(config)
+	if err := client.StartClientLoop(); err != nil {
+		t.Fatalf("StartClientLoop: %v", err)
+	}
+	if winners := client.Metrics().Winners; winners != 1 {
+		t.Errorf("%d winners, want 1", winners)
+	}
+
+	// The report names the contest the central answered for
+	data, err := os.ReadFile(config.ReportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report WinnersReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("invalid JSON report: %v", err)
+	}
+	if report.Contest != servertest.DefaultContest {
+		t.Errorf("report of contest %q, want %q", report.Contest, servertest.DefaultContest)
+	}
+}
+
+func requestContest(msg protocol.Message) (string, bool) {
+	switch m := msg.(type) {
+	case *protocol.BetBatch:
+		return m.Contest, true
+	case *protocol.EndOfBets:
+		return m.Contest, true
+	case *protocol.WinnersQuery:
+		return m.Contest, true
+	case *protocol.ResumeQuery:
+		return m.Contest, true
+	}
+	return "", false
+}
+
+func TestTierCountsDefaultToExactMatches(t *testing.T) {
+	counts := tierCounts(&protocol.Winners{Documents: []uint64{1, 2, 3}, Tiers: []uint8{4, 2, 2}})
+	if counts[4] != 1 || counts[2] != 2 || len(counts) != 2 {
+		t.Errorf("got %v", counts)
+	}
+	// Winners without tiers only matched exactly
+	counts = tierCounts(&protocol.Winners{Documents: []uint64{1, 2}})
+	if counts[protocol.NumberDigits] != 2 || len(counts) != 1 {
+		t.Errorf("got %v", counts)
+	}
+}
@@ -0,0 +1,52 @@
+package common
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+)
+
+// rejectsHeader Columns of the rejects file. The original row follows the
+// line number and the reason
+var rejectsHeader = []string{"line", "reason", "first_name", "last_name", "document", "birthdate", "number", "stake"}
+
+// rejectsFile Report of the rows of the agency file that were not sent
+type rejectsFile struct {
+	file   *os.File
+	writer *csv.Writer
+}
+
+// createRejectsFile Creates (or truncates) the rejects file so rows rejected
+// by a previous run are not mixed with the current ones
+func createRejectsFile(path string) (*rejectsFile, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &rejectsFile{file: file, writer: csv.NewWriter(file)}
+	if err := r.writer.Write(rejectsHeader); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// write Appends a rejected row. fields holds the original columns, if the
+// row could be split into them. Missing columns, like the stake of rows
+// without one, are left empty so every record has those of the header
+func (r *rejectsFile) write(line int, reason string, fields []string) error {
+	record := append([]string{strconv.Itoa(line), reason}, fields...)
+	for len(record) < len(rejectsHeader) {
+		record = append(record, "")
+	}
+	return r.writer.Write(record)
+}
+
+func (r *rejectsFile) close() error {
+	r.writer.Flush()
+	err := r.writer.Error()
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
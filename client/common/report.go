@@ -0,0 +1,327 @@
+package common
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/dataset"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// ReportFormat Format of the winners report
+type ReportFormat uint8
+
+const (
+	ReportCSV ReportFormat = iota
+	ReportJSON
+)
+
+func (f ReportFormat) String() string {
+	switch f {
+	case ReportCSV:
+		return "csv"
+	case ReportJSON:
+		return "json"
+	}
+	return "unknown"
+}
+
+// ParseReportFormat Parses the name of a format as returned by String
+func ParseReportFormat(s string) (ReportFormat, error) {
+	switch strings.ToLower(s) {
+	case "csv":
+		return ReportCSV, nil
+	case "json":
+		return ReportJSON, nil
+	}
+	return 0, errors.Errorf("unknown report format %q", s)
+}
+
+// WinnersReport Winners of an agency, matched back to the rows of its file so
+// the agency can pay them out
+type WinnersReport struct {
+	Contest       string    `json:"contest"`
+	Agency        string    `json:"agency"`
+	DrawnAt       time.Time `json:"drawn_at"`
+	WinningNumber uint16    `json:"winning_number"`
+	// Pool Prize pool of the contest shared by the winners of every agency
+	Pool string `json:"pool"`
+	// Signature Hex encoded signature of the central over the winners, see
+	// protocol.SignWinners. Also written next to the report in a .sig file
+	Signature string         `json:"signature,omitempty"`
+	Winners   []WinningEntry `json:"winners"`
+}
+
+// WinningEntry A winning bet as it was read from the agency file. Only the
+// document is known for winners that could not be found in the file
+type WinningEntry struct {
+	Line      int    `json:"line,omitempty"`
+	FirstName string `json:"first_name,omitempty"`
+	LastName  string `json:"last_name,omitempty"`
+	Document  string `json:"document"`
+	Birthdate string `json:"birthdate,omitempty"`
+	Number    string `json:"number,omitempty"`
+	Stake     string `json:"stake,omitempty"`
+	// Payout Amount paid to the document. Only the first row of a document
+	// carries it when the document won with several bets
+	Payout string `json:"payout,omitempty"`
+}
+
+// reportHeader Columns of the CSV report. Contest, agency and draw time are
+// repeated in every row so the file can be merged with other agencies'
+var reportHeader = []string{"contest", "agency", "drawn_at", "line", "first_name", "last_name", "document", "birthdate", "number", "stake", "payout"}
+
+// newWinnersReport Looks up the winning documents in the agency file. The
+// rows of a winning document whose numbers reach the lowest tier it won are
+// reported, in file order, followed by the documents that were not found.
+// Other bets of the same document lost, so they are left out, as are the rows
+// of its cancelled bets
+func newWinnersReport(config ClientConfig, winners *protocol.Winners, cancelled cancelledBets) (*WinnersReport, error) {
+	report := &WinnersReport{
+		Contest:       winners.Contest,
+		Agency:        config.ID,
+		DrawnAt:       time.Now().UTC(),
+		WinningNumber: winners.WinningNumber,
+		Pool:          protocol.FormatAmount(winners.Pool),
+		Signature:     hex.EncodeToString(winners.Signature),
+		Winners:       []WinningEntry{},
+	}
+	if winners.DrawnAt != 0 {
+		report.DrawnAt = time.Unix(0, winners.DrawnAt*int64(time.Millisecond)).UTC()
+	}
+
+	// Lowest tier each winning document won, any bet of it that reaches the
+	// tier won too
+	pending := make(map[uint64]uint8, len(winners.Documents))
+	payouts := make(map[uint64]uint64, len(winners.Documents))
+	for i, document := range winners.Documents {
+		if tier, ok := pending[document]; !ok || winners.Tier(i) < tier {
+			pending[document] = winners.Tier(i)
+		}
+		payouts[document] += winners.Payout(i)
+	}
+	found := make(map[uint64]bool, len(pending))
+	// payout Returns what is paid to the document the first time it is
+	// reported
+	payout := func(document uint64) string {
+		if found[document] {
+			return ""
+		}
+		return protocol.FormatAmount(payouts[document])
+	}
+	if len(pending) == 0 {
+		return report, nil
+	}
+
+	file, err := os.Open(config.DatasetPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader := dataset.NewReader(file)
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var malformed *dataset.MalformedRowError
+		if errors.As(err, &malformed) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		document, err := strconv.ParseUint(row.Document, 10, 64)
+		if err != nil {
+			continue
+		}
+		if tier, ok := pending[document]; !ok || !reachesTier(row, winners.WinningNumber, tier) {
+			continue
+		}
+		if cancelled.take(document, row) {
+			continue
+		}
+		report.Winners = append(report.Winners, WinningEntry{
+			Line:      row.Line,
+			FirstName: row.FirstName,
+			LastName:  row.LastName,
+			Document:  row.Document,
+			Birthdate: row.Birthdate,
+			Number:    row.Number,
+			Stake:     row.Stake,
+			Payout:    payout(document),
+		})
+		found[document] = true
+	}
+
+	for _, document := range winners.Documents {
+		if !found[document] {
+			report.Winners = append(report.Winners, WinningEntry{Document: strconv.FormatUint(document, 10), Payout: payout(document)})
+			found[document] = true
+		}
+	}
+	return report, nil
+}
+
+// cancelledBets Cancelled bets of the winning documents: how many bets of
+// every document play each set of numbers, see numbersKey
+type cancelledBets map[uint64]map[string]int
+
+// cancelledBets Asks the central for the bets of every winning document and
+// returns those the agency cancelled. They took no part in the draw, but their
+// rows may reach the tier of another bet of the same document that won
+func (c *Client) cancelledBets(winners *protocol.Winners) (cancelledBets, error) {
+	cancelled := make(cancelledBets)
+	asked := make(map[uint64]bool, len(winners.Documents))
+	for _, document := range winners.Documents {
+		if asked[document] {
+			continue
+		}
+		asked[document] = true
+		states, _, err := c.queryBetStatus(strconv.FormatUint(document, 10))
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not get the bets of document %v", document)
+		}
+		for _, state := range states {
+			if state.Status != protocol.BetCancelled {
+				continue
+			}
+			if cancelled[document] == nil {
+				cancelled[document] = make(map[string]int)
+			}
+			cancelled[document][numbersKey(state.Bet.Played())]++
+		}
+	}
+	return cancelled, nil
+}
+
+// take Checks if the row is one of the cancelled bets of the document. Each
+// cancelled bet matches a single row
+func (b cancelledBets) take(document uint64, row dataset.Row) bool {
+	numbers, err := parseNumbers(row)
+	if err != nil {
+		return false
+	}
+	key := numbersKey(numbers)
+	if b[document][key] == 0 {
+		return false
+	}
+	b[document][key]--
+	return true
+}
+
+// numbersKey Identifies the numbers a bet plays, in any order, as the central
+// does when cancelling a bet by its numbers
+func numbersKey(numbers []uint16) string {
+	sorted := append([]uint16(nil), numbers...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	fields := make([]string, len(sorted))
+	for i, number := range sorted {
+		fields[i] = strconv.Itoa(int(number))
+	}
+	return strings.Join(fields, protocol.NumbersSeparator)
+}
+
+// reachesTier Checks if any number of the row matches at least tier
+// trailing digits of the winning number. Rows that were never sent do not
+func reachesTier(row dataset.Row, winning uint16, tier uint8) bool {
+	numbers, err := parseNumbers(row)
+	if err != nil {
+		return false
+	}
+	for _, number := range numbers {
+		if matchedDigits(number, winning) >= tier {
+			return true
+		}
+	}
+	return false
+}
+
+// matchedDigits Returns how many trailing digits of the winning number the
+// number matches, protocol.NumberDigits only if they are equal
+func matchedDigits(number uint16, winning uint16) uint8 {
+	if number == winning {
+		return protocol.NumberDigits
+	}
+	digits := uint8(0)
+	for digits < protocol.NumberDigits-1 && number%10 == winning%10 {
+		number /= 10
+		winning /= 10
+		digits++
+	}
+	return digits
+}
+
+// missing Amount of winners that could not be found in the agency file
+func (r *WinnersReport) missing() int {
+	n := 0
+	for _, winner := range r.Winners {
+		if winner.Line == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// write Writes the report to path in the given format, replacing the report
+// of a previous run. The signature, if any, is written to path + ".sig"
+func (r *WinnersReport) write(path string, format ReportFormat) error {
+	if r.Signature != "" {
+		if err := os.WriteFile(path+".sig", []byte(r.Signature+"\n"), 0644); err != nil {
+			return err
+		}
+	} else if err := os.Remove(path + ".sig"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if format == ReportJSON {
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(r)
+	} else {
+		err = r.writeCSV(file)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (r *WinnersReport) writeCSV(out io.Writer) error {
+	writer := csv.NewWriter(out)
+	writer.Write(reportHeader)
+	drawnAt := r.DrawnAt.Format(time.RFC3339Nano)
+	for _, winner := range r.Winners {
+		line := ""
+		if winner.Line > 0 {
+			line = strconv.Itoa(winner.Line)
+		}
+		writer.Write([]string{
+			r.Contest,
+			r.Agency,
+			drawnAt,
+			line,
+			winner.FirstName,
+			winner.LastName,
+			winner.Document,
+			winner.Birthdate,
+			winner.Number,
+			winner.Stake,
+			winner.Payout,
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -1,28 +1,73 @@
 package main
 
 import (
+	"crypto/ed25519"
+	"encoding/hex"
 	"fmt"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/op/go-logging"
 	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 
 	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/dataset"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
 )
 
 var log = logging.MustGetLogger("log")
 
+// Client modes. In single mode the client sends the bet of one person, in
+// batch mode every bet of the agency file, in cancel mode it voids a bet
+// already sent and in status mode it shows the bets of a document. The mode
+// can also be given as the first argument, like "client cancel --apuesta 12"
+const (
+	modeAuto   = "auto"
+	modeSingle = "single"
+	modeBatch  = "batch"
+	modeCancel = "cancel"
+	modeStatus = "status"
+)
+
 // InitConfig Function that uses viper library to parse configuration parameters.
-// Viper is configured to read variables from both environment variables and the
-// config file ./config.yaml. Environment variables takes precedence over parameters
-// defined in the configuration file. If some of the variables cannot be parsed,
-// an error is returned
+// Viper is configured to read variables from command line flags, environment
+// variables and the config file ./config.yaml. Flags take precedence over
+// environment variables, which take precedence over parameters defined in the
+// configuration file. If some of the variables cannot be parsed, an error is
+// returned
 func InitConfig() (*viper.Viper, error) {
 	v := viper.New()
 
+	// Flags of the single bet mode. They are named after the environment
+	// variables used by the course to define the bet
+	flags := pflag.NewFlagSet(os.Args[0], pflag.ExitOnError)
+	flags.String("mode", modeAuto, "client mode: single, batch, cancel, status or auto (single if a document is given)")
+	flags.String("nombre", "", "first name of the bettor (single mode)")
+	flags.String("apellido", "", "last name of the bettor (single mode)")
+	flags.String("documento", "", "document of the bettor (single, cancel and status modes)")
+	flags.String("nacimiento", "", "birthdate of the bettor, YYYY-MM-DD (single mode)")
+	flags.String("numero", "", "number bet (single and cancel modes)")
+	flags.String("monto", "", "amount bet, like 150.50 (single mode, optional)")
+	flags.String("apuesta", "", "id of the bet to cancel, or the document and number of the bet if not given (cancel mode)")
+	flags.Parse(os.Args[1:])
+	if flags.NArg() > 0 {
+		flags.Set("mode", flags.Arg(0))
+	}
+	v.BindPFlag("mode", flags.Lookup("mode"))
+	v.BindPFlag("bet.firstName", flags.Lookup("nombre"))
+	v.BindPFlag("bet.lastName", flags.Lookup("apellido"))
+	v.BindPFlag("bet.document", flags.Lookup("documento"))
+	v.BindPFlag("bet.birthdate", flags.Lookup("nacimiento"))
+	v.BindPFlag("bet.number", flags.Lookup("numero"))
+	v.BindPFlag("bet.stake", flags.Lookup("monto"))
+	v.BindPFlag("bet.id", flags.Lookup("apuesta"))
+
 	// Configure viper to read env variables with the CLI_ prefix
 	v.AutomaticEnv()
 	v.SetEnvPrefix("cli")
@@ -37,6 +82,54 @@ func InitConfig() (*viper.Viper, error) {
 	v.BindEnv("loop", "period")
 	v.BindEnv("loop", "amount")
 	v.BindEnv("log", "level")
+	v.BindEnv("batch", "maxAmount")
+	v.BindEnv("dataset", "path")
+	v.BindEnv("dataset", "rejectsPath")
+	v.BindEnv("server", "timeout")
+	v.BindEnv("retry", "amount")
+	v.BindEnv("retry", "period")
+	v.BindEnv("names", "limit")
+	v.BindEnv("names", "policy")
+	v.BindEnv("mode")
+	v.BindEnv("winners", "push")
+	v.BindEnv("winners", "pushTimeout")
+	v.BindEnv("winners", "reportPath")
+	v.BindEnv("winners", "reportFormat")
+	v.BindEnv("contest", "id")
+	v.BindEnv("draw", "verify")
+	v.BindEnv("draw", "publicKey")
+	v.BindEnv("agencies", "ids")
+	v.BindEnv("agencies", "dataset")
+	v.BindEnv("agencies", "rejects")
+	v.BindEnv("agencies", "report")
+	v.BindEnv("agencies", "stagger")
+
+	// The bet of the single mode is read from the variables used by the course,
+	// which do not have the CLI_ prefix
+	v.BindEnv("bet.firstName", "NOMBRE")
+	v.BindEnv("bet.lastName", "APELLIDO")
+	v.BindEnv("bet.document", "DOCUMENTO")
+	v.BindEnv("bet.birthdate", "NACIMIENTO")
+	v.BindEnv("bet.number", "NUMERO")
+	v.BindEnv("bet.stake", "MONTO")
+	v.BindEnv("bet.id", "APUESTA")
+
+	// Defaults for the variables that older config files do not define
+	v.SetDefault("dataset.path", "./agency.csv")
+	v.SetDefault("dataset.rejectsPath", "./rejects.csv")
+	v.SetDefault("server.timeout", "10s")
+	v.SetDefault("retry.amount", 3)
+	v.SetDefault("retry.period", "1s")
+	v.SetDefault("names.limit", 0)
+	v.SetDefault("names.policy", "reject")
+	v.SetDefault("winners.push", false)
+	v.SetDefault("winners.pushTimeout", "60s")
+	v.SetDefault("winners.reportFormat", "csv")
+	v.SetDefault("contest.id", "1")
+	v.SetDefault("draw.verify", true)
+	v.SetDefault("agencies.dataset", "./agency-{id}.csv")
+	v.SetDefault("agencies.rejects", "./rejects-{id}.csv")
+	v.SetDefault("agencies.stagger", "500ms")
 
 	// Try to read configuration from config file. If config file
 	// does not exists then ReadInConfig will fail but configuration
@@ -52,18 +145,54 @@ func InitConfig() (*viper.Viper, error) {
 	if _, err := time.ParseDuration(v.GetString("loop.period")); err != nil {
 		return nil, errors.Wrapf(err, "Could not parse CLI_LOOP_PERIOD env var as time.Duration.")
 	}
+	if _, err := time.ParseDuration(v.GetString("server.timeout")); err != nil {
+		return nil, errors.Wrapf(err, "Could not parse CLI_SERVER_TIMEOUT env var as time.Duration.")
+	}
+	if _, err := time.ParseDuration(v.GetString("retry.period")); err != nil {
+		return nil, errors.Wrapf(err, "Could not parse CLI_RETRY_PERIOD env var as time.Duration.")
+	}
+	if _, err := time.ParseDuration(v.GetString("winners.pushTimeout")); err != nil {
+		return nil, errors.Wrapf(err, "Could not parse CLI_WINNERS_PUSHTIMEOUT env var as time.Duration.")
+	}
+	if _, err := time.ParseDuration(v.GetString("agencies.stagger")); err != nil {
+		return nil, errors.Wrapf(err, "Could not parse CLI_AGENCIES_STAGGER env var as time.Duration.")
+	}
+	if _, err := protocol.ParseNamePolicy(v.GetString("names.policy")); err != nil {
+		return nil, errors.Wrapf(err, "Could not parse CLI_NAMES_POLICY env var.")
+	}
+	if _, err := publicKey(v); err != nil {
+		return nil, errors.Wrapf(err, "Could not parse CLI_DRAW_PUBLICKEY env var.")
+	}
+	format, err := common.ParseReportFormat(v.GetString("winners.reportFormat"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not parse CLI_WINNERS_REPORTFORMAT env var.")
+	}
+	// Reports are named after their format unless a path is given
+	v.SetDefault("winners.reportPath", "./winners."+format.String())
+	v.SetDefault("agencies.report", "./winners-{id}."+format.String())
+	switch v.GetString("mode") {
+	case modeAuto, modeSingle, modeBatch, modeCancel, modeStatus:
+	default:
+		return nil, errors.Errorf("Invalid CLI_MODE %q, must be single, batch, cancel, status or auto.", v.GetString("mode"))
+	}
+	if _, err := strconv.ParseUint(v.GetString("bet.id"), 10, 32); v.GetString("bet.id") != "" && err != nil {
+		return nil, errors.Wrapf(err, "Could not parse APUESTA env var as a bet id.")
+	}
 
 	return v, nil
 }
 
 // InitLogger Receives the log level to be set in go-logging as a string. This method
 // parses the string and set the level to the logger. If the level string is not
-// valid an error is returned
-func InitLogger(logLevel string) error {
+// valid an error is returned. When tagged, every line starts with the module
+// that logged it, which is the agency in multi-agency mode
+func InitLogger(logLevel string, tagged bool) error {
 	baseBackend := logging.NewLogBackend(os.Stdout, "", 0)
-	format := logging.MustStringFormatter(
-		`%{time:2006-01-02 15:04:05} %{level:.5s}     %{message}`,
-	)
+	layout := `%{time:2006-01-02 15:04:05} %{level:.5s}     %{message}`
+	if tagged {
+		layout = `%{time:2006-01-02 15:04:05} %{level:.5s}     [%{module}] %{message}`
+	}
+	format := logging.MustStringFormatter(layout)
 	backendFormatter := logging.NewBackendFormatter(baseBackend, format)
 
 	backendLeveled := logging.AddModuleLevel(backendFormatter)
@@ -81,12 +210,14 @@ func InitLogger(logLevel string) error {
 // PrintConfig Print all the configuration parameters of the program.
 // For debugging purposes only
 func PrintConfig(v *viper.Viper) {
-	log.Infof("action: config | result: success | client_id: %s | server_address: %s | loop_amount: %v | loop_period: %v | log_level: %s",
+	log.Infof("action: config | result: success | client_id: %s | server_address: %s | loop_amount: %v | loop_period: %v | log_level: %s | batch_max_amount: %v | dataset_path: %s",
 		v.GetString("id"),
 		v.GetString("server.address"),
 		v.GetInt("loop.amount"),
 		v.GetDuration("loop.period"),
 		v.GetString("log.level"),
+		v.GetInt("batch.maxAmount"),
+		v.GetString("dataset.path"),
 	)
 }
 
@@ -96,7 +227,18 @@ func main() {
 		log.Criticalf("%s", err)
 	}
 
-	if err := InitLogger(v.GetString("log.level")); err != nil {
+	agencies, err := agenciesFromConfig(v)
+	if err != nil {
+		log.Criticalf("%s", err)
+		os.Exit(1)
+	}
+	mode := v.GetString("mode")
+	if mode == modeAuto && v.IsSet("bet.document") {
+		mode = modeSingle
+	}
+	multi := (mode == modeAuto || mode == modeBatch) && len(agencies) > 0
+
+	if err := InitLogger(v.GetString("log.level"), multi); err != nil {
 		log.Criticalf("%s", err)
 	}
 
@@ -104,12 +246,219 @@ func main() {
 	PrintConfig(v)
 
 	clientConfig := common.ClientConfig{
-		ServerAddress: v.GetString("server.address"),
-		ID:            v.GetString("id"),
-		LoopAmount:    v.GetInt("loop.amount"),
-		LoopPeriod:    v.GetDuration("loop.period"),
+		ServerAddress:  v.GetString("server.address"),
+		ID:             v.GetString("id"),
+		LoopAmount:     v.GetInt("loop.amount"),
+		LoopPeriod:     v.GetDuration("loop.period"),
+		BatchMaxAmount: v.GetInt("batch.maxAmount"),
+		DatasetPath:    v.GetString("dataset.path"),
+		RejectsPath:    v.GetString("dataset.rejectsPath"),
+		Timeout:        v.GetDuration("server.timeout"),
+		MaxRetries:     v.GetInt("retry.amount"),
+		RetryPeriod:    v.GetDuration("retry.period"),
+		NameLimit:      v.GetInt("names.limit"),
+		PushWinners:    v.GetBool("winners.push"),
+		PushTimeout:    v.GetDuration("winners.pushTimeout"),
+		VerifyDraw:     v.GetBool("draw.verify"),
+		Contest:        v.GetString("contest.id"),
+		ReportPath:     v.GetString("winners.reportPath"),
+		Agencies:       agencies,
+		Stagger:        v.GetDuration("agencies.stagger"),
+	}
+	// Already validated by InitConfig
+	clientConfig.NamePolicy, _ = protocol.ParseNamePolicy(v.GetString("names.policy"))
+	clientConfig.ReportFormat, _ = common.ParseReportFormat(v.GetString("winners.reportFormat"))
+	clientConfig.PublicKey, _ = publicKey(v)
+
+	if multi {
+		runAgencies(clientConfig)
+		return
 	}
 
 	client := common.NewClient(clientConfig)
-	client.StartClientLoop()
+	stopOnSignal(clientConfig.ID, client.Shutdown)
+
+	if mode == modeSingle {
+		if err := client.SendBet(betFromConfig(v)); err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+	if mode == modeCancel {
+		// Already validated by InitConfig
+		id, _ := strconv.ParseUint(v.GetString("bet.id"), 10, 32)
+		if err := client.CancelBet(uint32(id), betFromConfig(v)); err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+	if mode == modeStatus {
+		if _, err := client.BetStatus(v.GetString("bet.document")); err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := client.StartClientLoop(); err != nil {
+		log.Errorf("action: loop_finished | result: fail | client_id: %v | error: %v", clientConfig.ID, err)
+		os.Exit(1)
+	}
+	log.Infof("action: loop_finished | result: success | client_id: %v", clientConfig.ID)
+}
+
+// runAgencies Runs the loop of every configured agency concurrently. The
+// process exits with an error if any of them failed
+func runAgencies(config common.ClientConfig) {
+	multi := common.NewMultiClient(config)
+	stopOnSignal("*", multi.Shutdown)
+
+	results, err := multi.Run()
+	for _, result := range results {
+		if result.Err != nil {
+			log.Errorf("action: loop_finished | result: fail | client_id: %v | error: %v", result.ID, result.Err)
+		}
+	}
+	if err != nil {
+		log.Errorf("action: loop_finished | result: fail | error: %v", err)
+		os.Exit(1)
+	}
+	log.Infof("action: loop_finished | result: success | agencias: %v", len(results))
+}
+
+// stopOnSignal Calls shutdown when docker sends SIGTERM, so the client stops
+// gracefully
+func stopOnSignal(id string, shutdown func()) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-sigChan
+		log.Infof("action: shutdown | result: in_progress | client_id: %v | signal: %v", id, sig)
+		shutdown()
+	}()
+}
+
+// agencyEntry Agency of the agencies.list section of the config file
+type agencyEntry struct {
+	ID      string `mapstructure:"id"`
+	Dataset string `mapstructure:"dataset"`
+	Rejects string `mapstructure:"rejects"`
+	Report  string `mapstructure:"report"`
+}
+
+// agenciesFromConfig Returns the agencies driven in multi-agency mode. They
+// are listed in agencies.list, or given as ids (CLI_AGENCIES_IDS, e.g.
+// "1-3,5") whose files are named after the agencies.dataset,
+// agencies.rejects and agencies.report templates, where {id} is replaced by
+// the agency id. An
+// empty result means a single agency, defined by id and dataset.path
+func agenciesFromConfig(v *viper.Viper) ([]common.AgencyConfig, error) {
+	datasetTemplate := v.GetString("agencies.dataset")
+	rejectsTemplate := v.GetString("agencies.rejects")
+	reportTemplate := v.GetString("agencies.report")
+	expand := func(template string, id string) string {
+		return strings.Replace(template, "{id}", id, -1)
+	}
+
+	var agencies []common.AgencyConfig
+	if v.IsSet("agencies.list") {
+		var entries []agencyEntry
+		if err := v.UnmarshalKey("agencies.list", &entries); err != nil {
+			return nil, errors.Wrapf(err, "Could not parse agencies.list.")
+		}
+		for _, entry := range entries {
+			if entry.ID == "" {
+				return nil, errors.New("Every agency of agencies.list must have an id.")
+			}
+			agency := common.AgencyConfig{
+				ID:          entry.ID,
+				DatasetPath: entry.Dataset,
+				RejectsPath: entry.Rejects,
+				ReportPath:  entry.Report,
+			}
+			if agency.DatasetPath == "" {
+				agency.DatasetPath = expand(datasetTemplate, entry.ID)
+			}
+			if agency.RejectsPath == "" {
+				agency.RejectsPath = expand(rejectsTemplate, entry.ID)
+			}
+			if agency.ReportPath == "" {
+				agency.ReportPath = expand(reportTemplate, entry.ID)
+			}
+			agencies = append(agencies, agency)
+		}
+		return agencies, nil
+	}
+
+	ids, err := parseAgencyIDs(v.GetString("agencies.ids"))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not parse CLI_AGENCIES_IDS env var.")
+	}
+	for _, id := range ids {
+		agencies = append(agencies, common.AgencyConfig{
+			ID:          id,
+			DatasetPath: expand(datasetTemplate, id),
+			RejectsPath: expand(rejectsTemplate, id),
+			ReportPath:  expand(reportTemplate, id),
+		})
+	}
+	return agencies, nil
+}
+
+// parseAgencyIDs Parses a comma separated list of agency ids and ranges, like
+// "1-3,5"
+func parseAgencyIDs(s string) ([]string, error) {
+	var ids []string
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		bounds := strings.SplitN(part, "-", 2)
+		if len(bounds) == 1 {
+			ids = append(ids, part)
+			continue
+		}
+		first, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+		if err != nil {
+			return nil, errors.Errorf("invalid range %q", part)
+		}
+		last, err := strconv.Atoi(strings.TrimSpace(bounds[1]))
+		if err != nil || last < first {
+			return nil, errors.Errorf("invalid range %q", part)
+		}
+		for id := first; id <= last; id++ {
+			ids = append(ids, strconv.Itoa(id))
+		}
+	}
+	return ids, nil
+}
+
+// publicKey Returns the hex encoded public key of the central the winners
+// must be signed with, nil if not configured
+func publicKey(v *viper.Viper) (ed25519.PublicKey, error) {
+	if v.GetString("draw.publicKey") == "" {
+		return nil, nil
+	}
+	key, err := hex.DecodeString(v.GetString("draw.publicKey"))
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, errors.Errorf("key must be %d bytes long", ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// betFromConfig Returns the bet of the single mode, defined by the NOMBRE,
+// APELLIDO, DOCUMENTO, NACIMIENTO, NUMERO and the optional MONTO variables,
+// the matching flags or the bet section of the config file
+func betFromConfig(v *viper.Viper) dataset.Row {
+	return dataset.Row{
+		FirstName: v.GetString("bet.firstName"),
+		LastName:  v.GetString("bet.lastName"),
+		Document:  v.GetString("bet.document"),
+		Birthdate: v.GetString("bet.birthdate"),
+		Number:    v.GetString("bet.number"),
+		Stake:     v.GetString("bet.stake"),
+	}
 }
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/dataset"
+)
+
+func main() {
+	defaults := dataset.DefaultGeneratorConfig()
+	out := flag.String("out", ".data/synthetic", "directory where agency-N.csv files are written")
+	agencies := flag.Int("agencies", 5, "amount of agency files to generate")
+	rows := flag.Int("rows", defaults.Rows, "rows per agency file")
+	seed := flag.Int64("seed", defaults.Seed, "base seed, agency N uses seed+N")
+	minName := flag.Int("min-name", defaults.MinNameLength, "min characters of first and last names")
+	maxName := flag.Int("max-name", defaults.MaxNameLength, "max characters of first and last names")
+	charsets := flag.String("charsets", "ascii=1", "weights of the name charsets (ascii, latin, decomposed, cjk, emoji)")
+	winnerFraction := flag.Float64("winner-fraction", defaults.WinnerFraction, "fraction of the bets on the winner number")
+	winnerNumber := flag.Uint("winner-number", uint(defaults.WinnerNumber), "winner number of the contest")
+	flag.Parse()
+
+	config := defaults
+	config.Rows = *rows
+	config.MinNameLength = *minName
+	config.MaxNameLength = *maxName
+	config.WinnerFraction = *winnerFraction
+	config.WinnerNumber = uint16(*winnerNumber)
+	var err error
+	if config.Charsets, err = dataset.ParseCharsets(*charsets); err != nil {
+		fmt.Fprintf(os.Stderr, "action: generate | result: fail | error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err := os.MkdirAll(*out, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "action: generate | result: fail | error: %v\n", err)
+		os.Exit(1)
+	}
+
+	for agency := 1; agency <= *agencies; agency++ {
+		config.Seed = *seed + int64(agency)
+		name := filepath.Join(*out, dataset.FileName(agency))
+		winners, err := generateFile(name, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "action: generate | result: fail | file: %s | error: %v\n", name, err)
+			os.Exit(1)
+		}
+		fmt.Printf("action: generate | result: success | file: %s | rows: %d | winners: %d\n", name, config.Rows, winners)
+	}
+}
+
+// generateFile Writes a synthetic agency file and returns the amount of bets
+// on the winner number it contains
+func generateFile(name string, config dataset.GeneratorConfig) (int, error) {
+	generator, err := dataset.NewGenerator(config)
+	if err != nil {
+		return 0, err
+	}
+	file, err := os.Create(name)
+	if err != nil {
+		return 0, err
+	}
+	if err := generator.Generate(dataset.NewWriter(file)); err != nil {
+		file.Close()
+		return 0, err
+	}
+	return generator.ExpectedWinners(), file.Close()
+}
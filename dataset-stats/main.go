@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/dataset"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// defaultBudget Packets must not exceed 8kB, see README (Ejercicio 6)
+const defaultBudget = 8000
+
+// Report Output of the command
+type Report struct {
+	Files        []*dataset.Profile `json:"files"`
+	Total        *dataset.Profile   `json:"total"`
+	Distribution []dataset.Bucket   `json:"distribution"`
+	WorstBetSize int                `json:"worst_bet_size"`
+	Budget       int                `json:"budget"`
+	MaxAmount    int                `json:"max_amount"`
+}
+
+func main() {
+	budget := flag.Int("budget", defaultBudget, "max size in bytes of a bet batch frame")
+	bucketWidth := flag.Int("bucket", 1000, "width of the number distribution buckets")
+	contest := flag.String("contest", "1", "contest id sent in every batch")
+	asJSON := flag.Bool("json", false, "print the report as JSON")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [csv file | directory | zip]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	src := ".data/dataset.zip"
+	if flag.NArg() > 0 {
+		src = flag.Arg(0)
+	}
+
+	report, err := buildReport(src, *contest, *budget, *bucketWidth)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "action: dataset_stats | result: fail | source: %s | error: %v\n", src, err)
+		os.Exit(1)
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(report)
+	} else {
+		err = printReport(os.Stdout, report)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "action: dataset_stats | result: fail | error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// buildReport Profiles every agency file of src and computes the largest
+// batch.maxAmount that keeps the worst possible batch of the contest under
+// budget bytes
+func buildReport(src string, contest string, budget int, bucketWidth int) (*Report, error) {
+	report := &Report{Total: dataset.NewProfile("total", bucketWidth), Budget: budget}
+	err := dataset.Walk(src, func(name string, r io.Reader) error {
+		profile, err := dataset.ReadProfile(name, dataset.NewReader(r), bucketWidth)
+		if err != nil {
+			return err
+		}
+		report.Files = append(report.Files, profile)
+		report.Total.Merge(profile)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	report.Distribution = report.Total.Distribution()
+
+	// The longest first and last names and the most numbers played may
+	// belong to different rows, combining them gives an upper bound for the
+	// size of any bet of the dataset
+	worst := protocol.Bet{
+		FirstName: strings.Repeat("x", report.Total.FirstName.MaxBytes),
+		LastName:  strings.Repeat("x", report.Total.LastName.MaxBytes),
+		Birthdate: "2006-01-02",
+	}
+	if report.Total.MaxPlayed > 1 {
+		worst.Type = protocol.BetCombined
+		worst.Numbers = make([]uint16, report.Total.MaxPlayed)
+	}
+	single, err := protocol.Encode(&protocol.BetBatch{Bets: []protocol.Bet{worst}})
+	if err != nil {
+		return nil, err
+	}
+	empty, err := protocol.Encode(&protocol.BetBatch{})
+	if err != nil {
+		return nil, err
+	}
+	report.WorstBetSize = len(single) - len(empty)
+
+	report.MaxAmount, err = protocol.MaxBatchAmountFor(worst, contest, budget)
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func printReport(out io.Writer, report *Report) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "FILE\tROWS\tMALFORMED\tFIRST NAME (B/R)\tLAST NAME (B/R)\tMAX DOCUMENT\tNUMBERS")
+	for _, p := range append(report.Files, report.Total) {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d/%d\t%d/%d\t%d\t%d-%d\n",
+			p.Name, p.Rows, p.Malformed,
+			p.FirstName.MaxBytes, p.FirstName.MaxRunes,
+			p.LastName.MaxBytes, p.LastName.MaxRunes,
+			p.MaxDocument, p.MinNumber, p.MaxNumber,
+		)
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintf(w, "longest first name\t%q\n", report.Total.FirstName.Longest)
+	fmt.Fprintf(w, "longest last name\t%q\n", report.Total.LastName.Longest)
+	for _, s := range report.Total.Samples {
+		fmt.Fprintf(w, "malformed\t%s\n", s)
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, "NUMBERS\tBETS")
+	for _, b := range report.Distribution {
+		fmt.Fprintf(w, "%d-%d\t%d\n", b.From, b.To, b.Count)
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintf(w, "worst bet size\t%d bytes\n", report.WorstBetSize)
+	fmt.Fprintf(w, "frame budget\t%d bytes\n", report.Budget)
+	fmt.Fprintf(w, "batch.maxAmount\t%d\n", report.MaxAmount)
+	return w.Flush()
+}
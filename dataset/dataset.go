@@ -0,0 +1,120 @@
+// Package dataset Reading of the agency-N.csv files provided by the course.
+// Each line of those files is a bet with the following columns:
+//
+//	first name,last name,document,birthdate (YYYY-MM-DD),number[,stake]
+//
+// The stake, the amount bet written in units with up to two decimals, is
+// optional: rows without it carry no money
+package dataset
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// FieldCount Amount of columns of every row of an agency file, without the
+// optional stake
+const FieldCount = 5
+
+// Row A single line of an agency file, without any validation
+type Row struct {
+	Line      int
+	FirstName string
+	LastName  string
+	Document  string
+	Birthdate string
+	Number    string
+	// Stake Empty if the row has no stake column
+	Stake string
+}
+
+// Fields Returns the row columns in the same order as they are written. The
+// stake is left out if empty
+func (r Row) Fields() []string {
+	fields := []string{r.FirstName, r.LastName, r.Document, r.Birthdate, r.Number}
+	if r.Stake != "" {
+		fields = append(fields, r.Stake)
+	}
+	return fields
+}
+
+// MalformedRowError is returned by Reader.Read when a line cannot be parsed
+// as a row. Reading can continue after this error
+type MalformedRowError struct {
+	Line   int
+	Reason string
+}
+
+func (e *MalformedRowError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Reason)
+}
+
+// Reader Reads rows from an agency file
+type Reader struct {
+	csv *csv.Reader
+}
+
+// NewReader Initializes a Reader over an agency file
+func NewReader(r io.Reader) *Reader {
+	c := csv.NewReader(r)
+	// The amount of fields is checked by Read so malformed lines can be
+	// reported and skipped instead of aborting the whole file
+	c.FieldsPerRecord = -1
+	return &Reader{csv: c}
+}
+
+// Read Returns the next row of the file, io.EOF once the file was consumed
+// or a *MalformedRowError if the line is not a valid row
+func (r *Reader) Read() (Row, error) {
+	record, err := r.csv.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return Row{}, &MalformedRowError{Line: parseErr.Line, Reason: parseErr.Err.Error()}
+		}
+		return Row{}, err
+	}
+	line, _ := r.csv.FieldPos(0)
+	if len(record) != FieldCount && len(record) != FieldCount+1 {
+		return Row{}, &MalformedRowError{
+			Line:   line,
+			Reason: fmt.Sprintf("expected %d or %d fields, found %d", FieldCount, FieldCount+1, len(record)),
+		}
+	}
+	row := Row{
+		Line:      line,
+		FirstName: record[0],
+		LastName:  record[1],
+		Document:  record[2],
+		Birthdate: record[3],
+		Number:    record[4],
+	}
+	if len(record) > FieldCount {
+		row.Stake = record[FieldCount]
+	}
+	return row, nil
+}
+
+// Writer Writes rows in the agency file format
+type Writer struct {
+	csv *csv.Writer
+}
+
+// NewWriter Initializes a Writer over w. Flush must be called once done
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{csv: csv.NewWriter(w)}
+}
+
+// Write Appends a row. Row.Line is ignored
+func (w *Writer) Write(row Row) error {
+	return w.csv.Write(row.Fields())
+}
+
+// Flush Writes any buffered row and returns the first error found
+func (w *Writer) Flush() error {
+	w.csv.Flush()
+	return w.csv.Error()
+}
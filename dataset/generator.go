@@ -0,0 +1,238 @@
+package dataset
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultWinnerNumber Winner number used by the course server
+// (LOTTERY_WINNER_NUMBER in server/common/utils.py)
+const DefaultWinnerNumber = 7574
+
+// maxNumber Numbers in the course dataset go from 0 to 9999
+const maxNumber = 9999
+
+// Charset Family of characters used to build names
+type Charset string
+
+const (
+	// CharsetASCII Plain a-z letters, 1 byte each
+	CharsetASCII Charset = "ascii"
+	// CharsetLatin Precomposed accented letters such as "á", 2 bytes each
+	CharsetLatin Charset = "latin"
+	// CharsetDecomposed Letters followed by a combining accent (NFD), 3 bytes each
+	CharsetDecomposed Charset = "decomposed"
+	// CharsetCJK CJK ideographs, 3 bytes each
+	CharsetCJK Charset = "cjk"
+	// CharsetEmoji Emoji, 4 bytes each
+	CharsetEmoji Charset = "emoji"
+)
+
+var latinLetters = []rune("áéíóúñüÁÉÍÓÚÑÜ")
+
+// GeneratorConfig Parameters of a synthetic agency file
+type GeneratorConfig struct {
+	Seed int64
+	Rows int
+	// Names have between MinNameLength and MaxNameLength characters (a
+	// decomposed letter counts as a single character)
+	MinNameLength int
+	MaxNameLength int
+	// Charsets Weight of every charset when picking each character of a name
+	Charsets map[Charset]float64
+	// WinnerFraction Exact fraction of the rows betting on WinnerNumber. No
+	// other row bets on it, so the amount of winners can be predicted
+	WinnerFraction float64
+	WinnerNumber   uint16
+}
+
+// DefaultGeneratorConfig Returns a configuration that resembles the course
+// dataset: short ASCII names and roughly one winner every 2000 bets
+func DefaultGeneratorConfig() GeneratorConfig {
+	return GeneratorConfig{
+		Seed:           1,
+		Rows:           1000,
+		MinNameLength:  3,
+		MaxNameLength:  23,
+		Charsets:       map[Charset]float64{CharsetASCII: 1},
+		WinnerFraction: 0.0005,
+		WinnerNumber:   DefaultWinnerNumber,
+	}
+}
+
+// ParseCharsets Parses a charset distribution such as "ascii=0.9,latin=0.1"
+func ParseCharsets(s string) (map[Charset]float64, error) {
+	charsets := make(map[Charset]float64)
+	for _, entry := range strings.Split(s, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		charset := Charset(parts[0])
+		switch charset {
+		case CharsetASCII, CharsetLatin, CharsetDecomposed, CharsetCJK, CharsetEmoji:
+		default:
+			return nil, errors.Errorf("unknown charset %q", parts[0])
+		}
+		weight := 1.0
+		if len(parts) == 2 {
+			var err error
+			if weight, err = strconv.ParseFloat(parts[1], 64); err != nil || weight < 0 {
+				return nil, errors.Errorf("invalid weight %q for charset %s", parts[1], charset)
+			}
+		}
+		charsets[charset] = weight
+	}
+	return charsets, nil
+}
+
+// Generator Produces reproducible synthetic rows in the dataset format
+type Generator struct {
+	config   GeneratorConfig
+	rng      *rand.Rand
+	charsets []Charset
+	weights  []float64
+	total    float64
+	line     int
+	winners  int
+	minDate  int64
+	maxDate  int64
+}
+
+// NewGenerator Initializes a generator. The same configuration always
+// produces the same rows
+func NewGenerator(config GeneratorConfig) (*Generator, error) {
+	if config.Rows < 0 {
+		return nil, errors.Errorf("invalid amount of rows %d", config.Rows)
+	}
+	if config.MinNameLength < 1 || config.MaxNameLength < config.MinNameLength {
+		return nil, errors.Errorf("invalid name length range [%d, %d]", config.MinNameLength, config.MaxNameLength)
+	}
+	if config.WinnerFraction < 0 || config.WinnerFraction > 1 {
+		return nil, errors.Errorf("invalid winner fraction %v", config.WinnerFraction)
+	}
+
+	g := &Generator{
+		config:  config,
+		rng:     rand.New(rand.NewSource(config.Seed)),
+		minDate: time.Date(1930, 1, 1, 0, 0, 0, 0, time.UTC).Unix(),
+		maxDate: time.Date(2005, 12, 31, 0, 0, 0, 0, time.UTC).Unix(),
+	}
+	// Iterate in a fixed order so the same seed picks the same charsets
+	for _, c := range []Charset{CharsetASCII, CharsetLatin, CharsetDecomposed, CharsetCJK, CharsetEmoji} {
+		if w := config.Charsets[c]; w > 0 {
+			g.charsets = append(g.charsets, c)
+			g.weights = append(g.weights, w)
+			g.total += w
+		}
+	}
+	if g.total == 0 {
+		return nil, errors.New("at least one charset must have a positive weight")
+	}
+	return g, nil
+}
+
+// ExpectedWinners Amount of rows that bet on the winner number
+func (g *Generator) ExpectedWinners() int {
+	return int(g.config.WinnerFraction*float64(g.config.Rows) + 0.5)
+}
+
+// Next Returns the next row, or false once Rows rows were generated
+func (g *Generator) Next() (Row, bool) {
+	if g.line >= g.config.Rows {
+		return Row{}, false
+	}
+
+	// Selection sampling: every row is a winner with probability
+	// (winners left) / (rows left), which yields exactly ExpectedWinners
+	// winners without keeping the chosen positions in memory
+	remainingRows := g.config.Rows - g.line
+	remainingWinners := g.ExpectedWinners() - g.winners
+	winner := g.rng.Intn(remainingRows) < remainingWinners
+
+	number := int(g.config.WinnerNumber)
+	if winner {
+		g.winners++
+	} else {
+		for number == int(g.config.WinnerNumber) {
+			number = g.rng.Intn(maxNumber + 1)
+		}
+	}
+
+	g.line++
+	birthdate := time.Unix(g.minDate+g.rng.Int63n(g.maxDate-g.minDate), 0).UTC()
+	return Row{
+		Line:      g.line,
+		FirstName: g.name(),
+		LastName:  g.name(),
+		Document:  strconv.Itoa(10000000 + g.rng.Intn(30000000)),
+		Birthdate: birthdate.Format("2006-01-02"),
+		Number:    strconv.Itoa(number),
+	}, true
+}
+
+// Generate Writes all the remaining rows to w
+func (g *Generator) Generate(w *Writer) error {
+	for {
+		row, ok := g.Next()
+		if !ok {
+			return w.Flush()
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+}
+
+// name Builds a name of words separated by single spaces
+func (g *Generator) name() string {
+	length := g.config.MinNameLength + g.rng.Intn(g.config.MaxNameLength-g.config.MinNameLength+1)
+	var b strings.Builder
+	wordStart := true
+	for i := 0; i < length; i++ {
+		// A space is never the first or last character nor follows another one
+		if !wordStart && i < length-1 && g.rng.Intn(7) == 0 {
+			b.WriteByte(' ')
+			wordStart = true
+			continue
+		}
+		g.writeLetter(&b, wordStart)
+		wordStart = false
+	}
+	return b.String()
+}
+
+func (g *Generator) writeLetter(b *strings.Builder, upper bool) {
+	switch g.pickCharset() {
+	case CharsetASCII:
+		base := 'a'
+		if upper {
+			base = 'A'
+		}
+		b.WriteRune(base + rune(g.rng.Intn(26)))
+	case CharsetLatin:
+		b.WriteRune(latinLetters[g.rng.Intn(len(latinLetters))])
+	case CharsetDecomposed:
+		b.WriteRune('a' + rune(g.rng.Intn(26)))
+		b.WriteRune('\u0301') // combining acute accent
+	case CharsetCJK:
+		b.WriteRune(0x4E00 + rune(g.rng.Intn(0x5000)))
+	case CharsetEmoji:
+		b.WriteRune(0x1F600 + rune(g.rng.Intn(0x50)))
+	}
+}
+
+func (g *Generator) pickCharset() Charset {
+	if len(g.charsets) == 1 {
+		return g.charsets[0]
+	}
+	x := g.rng.Float64() * g.total
+	for i, w := range g.weights {
+		if x < w {
+			return g.charsets[i]
+		}
+		x -= w
+	}
+	return g.charsets[len(g.charsets)-1]
+}
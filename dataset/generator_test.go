@@ -0,0 +1,60 @@
+package dataset
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"testing"
+)
+
+func TestGeneratorProducesExactWinners(t *testing.T) {
+	config := DefaultGeneratorConfig()
+	config.Rows = 5000
+	config.WinnerFraction = 0.01
+	config.Charsets = map[Charset]float64{CharsetASCII: 1, CharsetLatin: 1, CharsetDecomposed: 1}
+
+	generator, err := NewGenerator(config)
+	if err != nil {
+		t.Fatalf("NewGenerator: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := generator.Generate(NewWriter(&buf)); err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+
+	rows, winners := 0, 0
+	reader := NewReader(&buf)
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("generated row %d cannot be read: %v", rows+1, err)
+		}
+		rows++
+		if row.Number == strconv.Itoa(int(config.WinnerNumber)) {
+			winners++
+		}
+	}
+	if rows != config.Rows || winners != 50 || generator.ExpectedWinners() != 50 {
+		t.Errorf("got %d rows and %d winners (expected %d), want %d rows and 50 winners",
+			rows, winners, generator.ExpectedWinners(), config.Rows)
+	}
+}
+
+func TestGeneratorIsReproducible(t *testing.T) {
+	config := DefaultGeneratorConfig()
+	first, _ := NewGenerator(config)
+	second, _ := NewGenerator(config)
+	for {
+		a, okA := first.Next()
+		b, okB := second.Next()
+		if a != b || okA != okB {
+			t.Fatalf("rows differ: %+v != %+v", a, b)
+		}
+		if !okA {
+			return
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package dataset
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// FilePattern Glob followed by the agency files, both on disk and inside the
+// dataset zip
+const FilePattern = "agency-*.csv"
+
+// FileName Returns the name of the file of the given agency
+func FileName(agency int) string {
+	return strings.Replace(FilePattern, "*", strconv.Itoa(agency), 1)
+}
+
+// Walk Calls fn once for every agency file found in src, which can be a single
+// CSV file, a directory holding agency-N.csv files or a zip file (such as
+// .data/dataset.zip) holding them. Files are visited in name order
+func Walk(src string, fn func(name string, r io.Reader) error) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	switch {
+	case info.IsDir():
+		return walkDir(src, fn)
+	case strings.EqualFold(filepath.Ext(src), ".zip"):
+		return walkZip(src, fn)
+	default:
+		return walkFile(src, fn)
+	}
+}
+
+func walkFile(name string, fn func(name string, r io.Reader) error) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return fn(filepath.Base(name), file)
+}
+
+func walkDir(dir string, fn func(name string, r io.Reader) error) error {
+	names, err := filepath.Glob(filepath.Join(dir, FilePattern))
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return errors.Errorf("no %s files found in %s", FilePattern, dir)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := walkFile(name, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkZip(name string, fn func(name string, r io.Reader) error) error {
+	archive, err := zip.OpenReader(name)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	var files []*zip.File
+	for _, f := range archive.File {
+		if ok, _ := path.Match(FilePattern, path.Base(f.Name)); ok {
+			files = append(files, f)
+		}
+	}
+	if len(files) == 0 {
+		return errors.Errorf("no %s files found in %s", FilePattern, name)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	for _, f := range files {
+		if err := walkZipEntry(f, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkZipEntry(f *zip.File, fn func(name string, r io.Reader) error) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return fn(path.Base(f.Name), rc)
+}
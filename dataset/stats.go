@@ -0,0 +1,214 @@
+package dataset
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// maxMalformedSamples Amount of malformed rows kept as examples in a Profile
+const maxMalformedSamples = 10
+
+// FieldStats Length statistics of a single column. Lengths are measured both
+// in UTF-8 bytes, which is what the protocol sends, and in runes, which is
+// what a person would count
+type FieldStats struct {
+	MaxBytes int    `json:"max_bytes"`
+	MaxRunes int    `json:"max_runes"`
+	Longest  string `json:"longest"`
+}
+
+func (s *FieldStats) add(v string) {
+	if len(v) > s.MaxBytes {
+		s.MaxBytes = len(v)
+		s.Longest = v
+	}
+	if n := utf8.RuneCountInString(v); n > s.MaxRunes {
+		s.MaxRunes = n
+	}
+}
+
+func (s *FieldStats) merge(o FieldStats) {
+	if o.MaxBytes > s.MaxBytes {
+		s.MaxBytes = o.MaxBytes
+		s.Longest = o.Longest
+	}
+	if o.MaxRunes > s.MaxRunes {
+		s.MaxRunes = o.MaxRunes
+	}
+}
+
+// Bucket Amount of bets whose number is in [From, To]
+type Bucket struct {
+	From  int `json:"from"`
+	To    int `json:"to"`
+	Count int `json:"count"`
+}
+
+// Profile Statistics of one or more agency files
+type Profile struct {
+	Name        string     `json:"name"`
+	Rows        int        `json:"rows"`
+	Malformed   int        `json:"malformed"`
+	Samples     []string   `json:"malformed_samples,omitempty"`
+	FirstName   FieldStats `json:"first_name"`
+	LastName    FieldStats `json:"last_name"`
+	Document    FieldStats `json:"document"`
+	Birthdate   FieldStats `json:"birthdate"`
+	Number      FieldStats `json:"number"`
+	MaxDocument uint64     `json:"max_document"`
+	MinNumber   int        `json:"min_number"`
+	MaxNumber   int        `json:"max_number"`
+	// MaxPlayed Most numbers played by a single row, more than one for
+	// combined bets
+	MaxPlayed int `json:"max_played"`
+
+	bucketWidth int
+	buckets     map[int]int
+}
+
+// NewProfile Initializes an empty profile. Numbers are grouped in buckets of
+// bucketWidth consecutive values
+func NewProfile(name string, bucketWidth int) *Profile {
+	if bucketWidth <= 0 {
+		bucketWidth = 1
+	}
+	return &Profile{
+		Name:        name,
+		MinNumber:   math.MaxInt32,
+		MaxNumber:   -1,
+		bucketWidth: bucketWidth,
+		buckets:     make(map[int]int),
+	}
+}
+
+// Add Accounts for a row. Rows whose document or numbers are not unsigned
+// integers are counted as malformed. Every number of a combined bet is
+// counted in the distribution
+func (p *Profile) Add(row Row) {
+	document, err := strconv.ParseUint(row.Document, 10, 64)
+	if err != nil {
+		p.AddMalformed(&MalformedRowError{Line: row.Line, Reason: fmt.Sprintf("invalid document %q", row.Document)})
+		return
+	}
+	fields := strings.Split(row.Number, protocol.NumbersSeparator)
+	numbers := make([]int, len(fields))
+	for i, field := range fields {
+		number, err := strconv.ParseUint(field, 10, 16)
+		if err != nil {
+			p.AddMalformed(&MalformedRowError{Line: row.Line, Reason: fmt.Sprintf("invalid number %q", row.Number)})
+			return
+		}
+		numbers[i] = int(number)
+	}
+
+	p.Rows++
+	p.FirstName.add(row.FirstName)
+	p.LastName.add(row.LastName)
+	p.Document.add(row.Document)
+	p.Birthdate.add(row.Birthdate)
+	p.Number.add(row.Number)
+	if document > p.MaxDocument {
+		p.MaxDocument = document
+	}
+	if len(numbers) > p.MaxPlayed {
+		p.MaxPlayed = len(numbers)
+	}
+	for _, n := range numbers {
+		if n < p.MinNumber {
+			p.MinNumber = n
+		}
+		if n > p.MaxNumber {
+			p.MaxNumber = n
+		}
+		p.buckets[n/p.bucketWidth]++
+	}
+}
+
+// AddMalformed Accounts for a row that could not be read
+func (p *Profile) AddMalformed(err *MalformedRowError) {
+	p.Malformed++
+	if len(p.Samples) < maxMalformedSamples {
+		p.Samples = append(p.Samples, err.Error())
+	}
+}
+
+// Merge Adds the statistics of o to p. Both profiles must use the same
+// bucket width
+func (p *Profile) Merge(o *Profile) {
+	p.Rows += o.Rows
+	p.Malformed += o.Malformed
+	for _, s := range o.Samples {
+		if len(p.Samples) >= maxMalformedSamples {
+			break
+		}
+		p.Samples = append(p.Samples, o.Name+": "+s)
+	}
+	p.FirstName.merge(o.FirstName)
+	p.LastName.merge(o.LastName)
+	p.Document.merge(o.Document)
+	p.Birthdate.merge(o.Birthdate)
+	p.Number.merge(o.Number)
+	if o.MaxDocument > p.MaxDocument {
+		p.MaxDocument = o.MaxDocument
+	}
+	if o.MinNumber < p.MinNumber {
+		p.MinNumber = o.MinNumber
+	}
+	if o.MaxNumber > p.MaxNumber {
+		p.MaxNumber = o.MaxNumber
+	}
+	if o.MaxPlayed > p.MaxPlayed {
+		p.MaxPlayed = o.MaxPlayed
+	}
+	for k, v := range o.buckets {
+		p.buckets[k] += v
+	}
+}
+
+// Distribution Returns the non empty number buckets sorted by number
+func (p *Profile) Distribution() []Bucket {
+	keys := make([]int, 0, len(p.buckets))
+	for k := range p.buckets {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+
+	buckets := make([]Bucket, 0, len(keys))
+	for _, k := range keys {
+		buckets = append(buckets, Bucket{
+			From:  k * p.bucketWidth,
+			To:    (k+1)*p.bucketWidth - 1,
+			Count: p.buckets[k],
+		})
+	}
+	return buckets
+}
+
+// ReadProfile Builds the profile of a single agency file
+func ReadProfile(name string, r *Reader, bucketWidth int) (*Profile, error) {
+	p := NewProfile(name, bucketWidth)
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			return p, nil
+		}
+		var malformed *MalformedRowError
+		if errors.As(err, &malformed) {
+			p.AddMalformed(malformed)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		p.Add(row)
+	}
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/op/go-logging"
+	"github.com/pkg/errors"
+
+	central "github.com/7574-sistemas-distribuidos/docker-compose-init/central/common"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/dataset"
+)
+
+// AgencyReport Result of a single simulated agency
+type AgencyReport struct {
+	ID         string  `json:"id"`
+	Bets       int     `json:"bets"`
+	Rejected   int     `json:"rejected"`
+	Batches    int     `json:"batches"`
+	Reconnects int     `json:"reconnects"`
+	Errors     int     `json:"errors"`
+	Winners    int     `json:"winners"`
+	Seconds    float64 `json:"seconds"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// Latencies Batch round trip time percentiles, in milliseconds
+type Latencies struct {
+	P50 float64 `json:"p50_ms"`
+	P90 float64 `json:"p90_ms"`
+	P99 float64 `json:"p99_ms"`
+	Max float64 `json:"max_ms"`
+}
+
+// Commits Writes made by an embedded central to its bet log
+type Commits struct {
+	Sync    string  `json:"sync"`
+	Commits int     `json:"commits"`
+	Batches int     `json:"batches"`
+	PerSync float64 `json:"batches_per_commit"`
+}
+
+// Report Aggregated result of the whole run
+type Report struct {
+	Address       string         `json:"address"`
+	Agencies      int            `json:"agencies"`
+	BatchAmount   int            `json:"batch_max_amount"`
+	Failed        int            `json:"failed_agencies"`
+	Seconds       float64        `json:"seconds"`
+	Bets          int            `json:"bets"`
+	Batches       int            `json:"batches"`
+	BetsPerSecond float64        `json:"bets_per_second"`
+	BatchRTT      Latencies      `json:"batch_rtt"`
+	Reconnects    int            `json:"reconnects"`
+	Errors        int            `json:"errors"`
+	Central       *Commits       `json:"central,omitempty"`
+	PerAgency     []AgencyReport `json:"per_agency"`
+}
+
+func main() {
+	address := flag.String("addr", "localhost:12345", "address of the lottery server")
+	agencies := flag.Int("agencies", 5, "amount of simulated agencies")
+	datasetDir := flag.String("dataset", "", "directory with agency-N.csv files, synthetic data is generated if empty")
+	rows := flag.Int("rows", 1000, "bets per agency when generating synthetic data")
+	seed := flag.Int64("seed", 1, "seed of the synthetic data")
+	batchList := flag.String("batch", "100", "max amount of bets per batch, a comma separated list runs once per amount")
+	embedded := flag.Bool("central", false, "run a fresh in-process central for every run instead of using -addr")
+	syncName := flag.String("sync", "batch", "sync policy of the in-process central: always, batch or interval")
+	pollAmount := flag.Int("poll-amount", 30, "max winners queries per agency")
+	pollPeriod := flag.Duration("poll-period", time.Second, "wait between winners queries")
+	timeout := flag.Duration("timeout", 10*time.Second, "timeout of every request")
+	retries := flag.Int("retries", 3, "reconnection attempts per request")
+	jsonPath := flag.String("json", "", "also write the report as JSON to this file (- for stdout)")
+	logLevel := flag.String("log-level", "WARNING", "log level of the simulated agencies")
+	flag.Parse()
+
+	if err := initLogger(*logLevel); err != nil {
+		fail(err)
+	}
+	batches, err := parseAmounts(*batchList)
+	if err != nil {
+		fail(errors.Wrap(err, "invalid -batch"))
+	}
+	if len(batches) > 1 && !*embedded {
+		fail(errors.New("several -batch amounts need -central, a remote central draws after the first run"))
+	}
+	syncPolicy, err := central.ParseSyncPolicy(*syncName)
+	if err != nil {
+		fail(err)
+	}
+
+	dir := *datasetDir
+	if dir == "" {
+		tmp, err := os.MkdirTemp("", "loadgen")
+		if err != nil {
+			fail(err)
+		}
+		defer os.RemoveAll(tmp)
+		if err := generate(tmp, *agencies, *rows, *seed); err != nil {
+			os.RemoveAll(tmp)
+			fail(err)
+		}
+		dir = tmp
+	}
+
+	config := common.ClientConfig{
+		ServerAddress: *address,
+		LoopAmount:    *pollAmount,
+		LoopPeriod:    *pollPeriod,
+		Timeout:       *timeout,
+		MaxRetries:    *retries,
+		RetryPeriod:   100 * time.Millisecond,
+	}
+	for agency := 1; agency <= *agencies; agency++ {
+		config.Agencies = append(config.Agencies, common.AgencyConfig{
+			ID:          strconv.Itoa(agency),
+			DatasetPath: filepath.Join(dir, dataset.FileName(agency)),
+		})
+	}
+
+	var reports []*Report
+	for _, batch := range batches {
+		config.BatchMaxAmount = batch
+		var report *Report
+		if *embedded {
+			report, err = runEmbedded(config, syncPolicy)
+		} else {
+			report = run(config)
+		}
+		if err != nil {
+			fail(err)
+		}
+		reports = append(reports, report)
+		if len(batches) > 1 {
+			fmt.Printf("batch.maxAmount: %d\n", batch)
+		}
+		if err := printReport(os.Stdout, report); err != nil {
+			fail(err)
+		}
+		fmt.Println()
+	}
+	if len(reports) > 1 {
+		if err := printSweep(os.Stdout, reports); err != nil {
+			fail(err)
+		}
+	}
+
+	if *jsonPath != "" {
+		var out interface{} = reports
+		if len(reports) == 1 {
+			out = reports[0]
+		}
+		if err := writeJSON(*jsonPath, out); err != nil {
+			fail(err)
+		}
+	}
+	for _, report := range reports {
+		if report.Failed > 0 {
+			os.Exit(1)
+		}
+	}
+}
+
+// parseAmounts Parses a comma separated list of positive amounts
+func parseAmounts(s string) ([]int, error) {
+	var amounts []int
+	for _, field := range strings.Split(s, ",") {
+		amount, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, err
+		}
+		if amount < 1 {
+			return nil, errors.Errorf("amount %d must be positive", amount)
+		}
+		amounts = append(amounts, amount)
+	}
+	return amounts, nil
+}
+
+// runEmbedded Runs the agencies against a fresh central listening on
+// localhost, with its bet log in a temporary directory. Winners are pushed
+// so the run does not wait for polling
+func runEmbedded(config common.ClientConfig, syncPolicy central.SyncPolicy) (*Report, error) {
+	dir, err := os.MkdirTemp("", "loadgen-central")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	server, err := central.NewServer(central.ServerConfig{
+		ListenAddress: "127.0.0.1:0",
+		Agencies:      len(config.Agencies),
+		StoragePath:   filepath.Join(dir, "bets.log"),
+		Storage:       central.LogOptions{Sync: syncPolicy, SyncInterval: 100 * time.Millisecond},
+	})
+	if err != nil {
+		return nil, err
+	}
+	stopped := make(chan error, 1)
+	go func() { stopped <- server.Run() }()
+
+	config.ServerAddress = server.Addr()
+	config.PushWinners = true
+	config.PushTimeout = config.Timeout
+	report := run(config)
+	stats := server.CommitStats()
+	server.Shutdown()
+	if err := <-stopped; err != nil {
+		return nil, err
+	}
+
+	report.Central = &Commits{Sync: syncPolicy.String(), Commits: stats.Commits, Batches: stats.Batches}
+	if stats.Commits > 0 {
+		report.Central.PerSync = float64(stats.Batches) / float64(stats.Commits)
+	}
+	return report, nil
+}
+
+// run Drives every agency concurrently and waits for all of them
+func run(config common.ClientConfig) *Report {
+	start := time.Now()
+	results, _ := common.NewMultiClient(config).Run()
+	elapsed := time.Since(start)
+
+	report := &Report{
+		Address:     config.ServerAddress,
+		Agencies:    len(results),
+		BatchAmount: config.BatchMaxAmount,
+		Seconds:     elapsed.Seconds(),
+	}
+	for _, result := range results {
+		agency := AgencyReport{
+			ID:         result.ID,
+			Bets:       result.Metrics.BetsSent,
+			Rejected:   result.Metrics.Rejected,
+			Batches:    result.Metrics.Batches,
+			Reconnects: result.Metrics.Reconnects,
+			Errors:     result.Metrics.Errors,
+			Winners:    result.Metrics.Winners,
+			Seconds:    result.Elapsed.Seconds(),
+		}
+		if result.Err != nil {
+			agency.Error = result.Err.Error()
+			report.Failed++
+		}
+		report.PerAgency = append(report.PerAgency, agency)
+	}
+	total := common.Total(results)
+	report.Bets = total.BetsSent
+	report.Batches = total.Batches
+	report.Reconnects = total.Reconnects
+	report.Errors = total.Errors
+	if elapsed > 0 {
+		report.BetsPerSecond = float64(report.Bets) / elapsed.Seconds()
+	}
+	report.BatchRTT = latencies(total.BatchRTTs)
+	return report
+}
+
+// latencies Computes nearest-rank percentiles of the round trip times
+func latencies(rtts []time.Duration) Latencies {
+	if len(rtts) == 0 {
+		return Latencies{}
+	}
+	sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+	percentile := func(p float64) float64 {
+		rank := int(math.Ceil(p/100*float64(len(rtts)))) - 1
+		if rank < 0 {
+			rank = 0
+		}
+		return milliseconds(rtts[rank])
+	}
+	return Latencies{
+		P50: percentile(50),
+		P90: percentile(90),
+		P99: percentile(99),
+		Max: milliseconds(rtts[len(rtts)-1]),
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// generate Writes a synthetic agency file for every agency in dir
+func generate(dir string, agencies int, rows int, seed int64) error {
+	config := dataset.DefaultGeneratorConfig()
+	config.Rows = rows
+	for agency := 1; agency <= agencies; agency++ {
+		config.Seed = seed + int64(agency)
+		generator, err := dataset.NewGenerator(config)
+		if err != nil {
+			return err
+		}
+		file, err := os.Create(filepath.Join(dir, dataset.FileName(agency)))
+		if err != nil {
+			return err
+		}
+		err = generator.Generate(dataset.NewWriter(file))
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func printReport(out io.Writer, report *Report) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "AGENCY\tBETS\tREJECTED\tBATCHES\tRECONNECTS\tERRORS\tWINNERS\tSECONDS\tRESULT\t")
+	for _, a := range report.PerAgency {
+		result := "success"
+		if a.Error != "" {
+			result = "fail"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%.2f\t%s\t\n",
+			a.ID, a.Bets, a.Rejected, a.Batches, a.Reconnects, a.Errors, a.Winners, a.Seconds, result)
+	}
+	fmt.Fprintf(w, "total\t%d\t\t%d\t%d\t%d\t\t%.2f\t%d/%d\t\n",
+		report.Bets, report.Batches, report.Reconnects, report.Errors,
+		report.Seconds, report.Agencies-report.Failed, report.Agencies)
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "\nthroughput: %.1f bets/s\n", report.BetsPerSecond)
+	fmt.Fprintf(out, "batch rtt: p50 %.2fms | p90 %.2fms | p99 %.2fms | max %.2fms\n",
+		report.BatchRTT.P50, report.BatchRTT.P90, report.BatchRTT.P99, report.BatchRTT.Max)
+	if report.Central != nil {
+		fmt.Fprintf(out, "central: %d batches in %d commits (%.1f per fsync) | sync: %s\n",
+			report.Central.Batches, report.Central.Commits, report.Central.PerSync, report.Central.Sync)
+	}
+	for _, a := range report.PerAgency {
+		if a.Error != "" {
+			fmt.Fprintf(out, "agency %s: %s\n", a.ID, a.Error)
+		}
+	}
+	return nil
+}
+
+// printSweep Compares the runs made with different batch amounts
+func printSweep(out io.Writer, reports []*Report) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "BATCH\tBETS/S\tP50 MS\tP99 MS\tCOMMITS\tBATCHES/FSYNC\tRESULT\t")
+	for _, r := range reports {
+		commits, perSync := "", ""
+		if r.Central != nil {
+			commits = strconv.Itoa(r.Central.Commits)
+			perSync = fmt.Sprintf("%.1f", r.Central.PerSync)
+		}
+		fmt.Fprintf(w, "%d\t%.1f\t%.2f\t%.2f\t%s\t%s\t%d/%d\t\n",
+			r.BatchAmount, r.BetsPerSecond, r.BatchRTT.P50, r.BatchRTT.P99, commits, perSync, r.Agencies-r.Failed, r.Agencies)
+	}
+	return w.Flush()
+}
+
+func writeJSON(path string, report interface{}) error {
+	out := os.Stdout
+	if path != "-" {
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+func initLogger(level string) error {
+	backend := logging.NewBackendFormatter(
+		logging.NewLogBackend(os.Stderr, "", 0),
+		logging.MustStringFormatter(`%{time:2006-01-02 15:04:05} %{level:.5s}     %{message}`),
+	)
+	leveled := logging.AddModuleLevel(backend)
+	code, err := logging.LogLevel(level)
+	if err != nil {
+		return err
+	}
+	leveled.SetLevel(code, "")
+	logging.SetBackend(leveled)
+	return nil
+}
+
+func fail(err error) {
+	fmt.Fprintf(os.Stderr, "action: loadgen | result: fail | error: %v\n", err)
+	os.Exit(1)
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+
+	central "github.com/7574-sistemas-distribuidos/docker-compose-init/central/common"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// Exit codes of lotctl, so scripts can tell why a command failed
+const (
+	exitOK = 0
+	// exitFailed The admin API rejected the request or answered with an error
+	exitFailed = 1
+	// exitUsage Invalid command, flags or configuration
+	exitUsage = 2
+	// exitUnavailable The admin API could not be reached
+	exitUnavailable = 3
+	// exitConflict The request does not apply to the phase of the contest,
+	// like querying winners before the draw or closing it twice
+	exitConflict = 4
+)
+
+const usage = `Usage: lotctl [flags] <command> [command flags]
+
+Commands:
+  contests               phase of every open contest
+  status                 phase of the contest
+  agencies               agencies with their bets and state
+  winners [--agency N]   winners of an agency, or of every agency
+  close                  close the contest (needs the admin token)
+  draw                   draw with the agencies that finished (needs the admin token)
+  open                   open the contest given with -contest (needs the admin token)
+
+Exit codes: 0 success, 1 request failed, 2 usage error, 3 central unreachable,
+4 not valid in the current phase of the contest (e.g. winners before the draw)
+
+Flags:
+`
+
+// InitConfig Function that uses viper library to parse configuration parameters.
+// Viper is configured to read variables from both environment variables and the
+// config file ./config.yaml, which has the same admin section as the config of
+// the central so lotctl can run next to it. Environment variables take
+// precedence over parameters defined in the configuration file. If some of the
+// variables cannot be parsed, an error is returned
+func InitConfig() (*viper.Viper, error) {
+	v := viper.New()
+
+	// Configure viper to read env variables with the LOTCTL_ prefix
+	v.AutomaticEnv()
+	v.SetEnvPrefix("lotctl")
+	// Use a replacer to replace env variables underscores with points. This let us
+	// use nested configurations in the config file and at the same time define
+	// env variables for the nested configurations
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+
+	// Add env variables supported. The ones of the central are accepted too
+	v.BindEnv("admin.address", "LOTCTL_ADMIN_ADDRESS", "CENTRAL_ADMIN_ADDRESS")
+	v.BindEnv("admin.token", "LOTCTL_ADMIN_TOKEN", "CENTRAL_ADMIN_TOKEN")
+	v.BindEnv("contest")
+	v.BindEnv("output")
+	v.BindEnv("timeout")
+
+	v.SetDefault("admin.address", "127.0.0.1:8080")
+	v.SetDefault("output", "table")
+	v.SetDefault("timeout", "5s")
+
+	// The config file is optional and its absence is not reported, the output
+	// of lotctl is meant to be read by scripts
+	v.SetConfigFile("./config.yaml")
+	v.ReadInConfig()
+
+	if output := v.GetString("output"); output != "table" && output != "json" {
+		return nil, errors.Errorf("Invalid LOTCTL_OUTPUT %q, must be table or json.", output)
+	}
+	if _, err := time.ParseDuration(v.GetString("timeout")); err != nil {
+		return nil, errors.Wrapf(err, "Could not parse LOTCTL_TIMEOUT env var as time.Duration.")
+	}
+
+	return v, nil
+}
+
+// apiError Error answered by the admin API
+type apiError struct {
+	Status  int
+	Message string `json:"error"`
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Status, http.StatusText(e.Status), e.Message)
+}
+
+// unavailableError The admin API could not be reached
+type unavailableError struct {
+	err error
+}
+
+func (e *unavailableError) Error() string {
+	return e.err.Error()
+}
+
+// Admin Client of the admin API of the central
+type Admin struct {
+	address string
+	// contest Contest the requests refer to, the default one if empty
+	contest string
+	token   string
+	client  *http.Client
+}
+
+// request Sends a request about the contest to the admin API and decodes its
+// JSON response into body
+func (a *Admin) request(method string, path string, query url.Values, body interface{}) error {
+	if a.contest != "" {
+		query.Set("contest", a.contest)
+	}
+	target := &url.URL{Scheme: "http", Host: a.address, Path: path, RawQuery: query.Encode()}
+	request, err := http.NewRequest(method, target.String(), nil)
+	if err != nil {
+		return err
+	}
+	if a.token != "" {
+		request.Header.Set("Authorization", "Bearer "+a.token)
+	}
+	response, err := a.client.Do(request)
+	if err != nil {
+		return &unavailableError{err: err}
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusCreated {
+		failure := &apiError{Status: response.StatusCode}
+		if err := json.NewDecoder(response.Body).Decode(failure); err != nil {
+			failure.Message = "invalid response"
+		}
+		return failure
+	}
+	return errors.Wrap(json.NewDecoder(response.Body).Decode(body), "invalid response")
+}
+
+// exitCode Maps the error of a command to the exit code of lotctl
+func exitCode(err error) int {
+	switch e := err.(type) {
+	case nil:
+		return exitOK
+	case *unavailableError:
+		return exitUnavailable
+	case *apiError:
+		if e.Status == http.StatusConflict {
+			return exitConflict
+		}
+	}
+	return exitFailed
+}
+
+func main() {
+	v, err := InitConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitUsage)
+	}
+
+	address := flag.String("addr", "", "address of the admin API (default from admin.address)")
+	contest := flag.String("contest", "", "contest the command refers to (default from contest, the default contest of the central if empty)")
+	token := flag.String("token", "", "admin token (default from admin.token)")
+	output := flag.String("o", "", "output format: table or json (default from output)")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *address != "" {
+		v.Set("admin.address", *address)
+	}
+	if *token != "" {
+		v.Set("admin.token", *token)
+	}
+	if *contest != "" {
+		v.Set("contest", *contest)
+	}
+	if *output != "" {
+		if *output != "table" && *output != "json" {
+			fmt.Fprintf(os.Stderr, "invalid output %q, must be table or json\n", *output)
+			os.Exit(exitUsage)
+		}
+		v.Set("output", *output)
+	}
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+
+	admin := &Admin{
+		address: v.GetString("admin.address"),
+		contest: v.GetString("contest"),
+		token:   v.GetString("admin.token"),
+		// Already validated by InitConfig
+		client: &http.Client{Timeout: v.GetDuration("timeout")},
+	}
+	asJSON := v.GetString("output") == "json"
+
+	result, err := run(admin, flag.Arg(0), flag.Args()[1:])
+	if err == errUsage {
+		os.Exit(exitUsage)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "lotctl %s: %v\n", flag.Arg(0), err)
+		os.Exit(exitCode(err))
+	}
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(result)
+	} else {
+		err = printTable(os.Stdout, result)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "lotctl %s: %v\n", flag.Arg(0), err)
+		os.Exit(exitFailed)
+	}
+}
+
+var errUsage = errors.New("usage")
+
+// run Runs a command, returning what the admin API answered
+func run(admin *Admin, command string, args []string) (interface{}, error) {
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	agency := flags.Int("agency", 0, "agency to list the winners of, every agency if 0")
+	if err := flags.Parse(args); err != nil {
+		return nil, errUsage
+	}
+	if flags.NArg() > 0 || (command != "winners" && *agency != 0) {
+		fmt.Fprintf(os.Stderr, "lotctl %s: unexpected arguments\n", command)
+		return nil, errUsage
+	}
+
+	query := url.Values{}
+	switch command {
+	case "contests":
+		var contests []central.ContestStatus
+		err := admin.request(http.MethodGet, "/contests", query, &contests)
+		return contests, err
+	case "status":
+		var status central.ContestStatus
+		err := admin.request(http.MethodGet, "/contest", query, &status)
+		return status, err
+	case "agencies":
+		var agencies []central.AgencyInfo
+		err := admin.request(http.MethodGet, "/agencies", query, &agencies)
+		return agencies, err
+	case "winners":
+		if *agency != 0 {
+			if *agency < 0 || *agency > 255 {
+				fmt.Fprintf(os.Stderr, "lotctl winners: invalid agency %d\n", *agency)
+				return nil, errUsage
+			}
+			query.Set("agency", strconv.Itoa(*agency))
+		}
+		var winners []central.WinnersInfo
+		err := admin.request(http.MethodGet, "/winners", query, &winners)
+		return winners, err
+	case "close":
+		var status central.ContestStatus
+		err := admin.request(http.MethodPost, "/close", query, &status)
+		return status, err
+	case "draw":
+		var status central.ContestStatus
+		err := admin.request(http.MethodPost, "/draw", query, &status)
+		return status, err
+	case "open":
+		if admin.contest == "" {
+			fmt.Fprintln(os.Stderr, "lotctl open: the contest to open must be given with -contest")
+			return nil, errUsage
+		}
+		var status central.ContestStatus
+		err := admin.request(http.MethodPost, "/contests", query, &status)
+		return status, err
+	}
+	fmt.Fprintf(os.Stderr, "lotctl: unknown command %q\n", command)
+	flag.Usage()
+	return nil, errUsage
+}
+
+// printTable Writes the result of a command as an aligned table
+func printTable(out io.Writer, result interface{}) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	switch r := result.(type) {
+	case central.ContestStatus:
+		fmt.Fprintf(w, "CONTEST\t%s\n", r.Contest)
+		fmt.Fprintf(w, "PHASE\t%s\n", r.Phase)
+		fmt.Fprintf(w, "FINISHED\t%d/%d\n", r.Finished, r.Agencies)
+		fmt.Fprintf(w, "QUORUM\t%d\n", r.Quorum)
+		fmt.Fprintf(w, "OPENED_AT\t%s\n", r.OpenedAt.Format(time.RFC3339))
+		fmt.Fprintf(w, "COMMITMENT\t%s\n", r.Commitment)
+		fmt.Fprintf(w, "HOUSE_CUT\t%s%%\n", protocol.FormatAmount(uint64(r.HouseCut)))
+		if r.DrawnAt != nil {
+			fmt.Fprintf(w, "DRAWN_AT\t%s\n", r.DrawnAt.Format(time.RFC3339))
+		}
+		if r.WinningNumber != nil {
+			fmt.Fprintf(w, "WINNING_NUMBER\t%d\n", *r.WinningNumber)
+		}
+	case []central.ContestStatus:
+		fmt.Fprintln(w, "CONTEST\tPHASE\tFINISHED\tOPENED_AT\tWINNING_NUMBER")
+		for _, contest := range r {
+			number := "-"
+			if contest.WinningNumber != nil {
+				number = strconv.Itoa(int(*contest.WinningNumber))
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d/%d\t%s\t%s\n", contest.Contest, contest.Phase, contest.Finished, contest.Agencies, contest.OpenedAt.Format(time.RFC3339), number)
+		}
+	case []central.AgencyInfo:
+		fmt.Fprintln(w, "AGENCY\tBETS\tFINISHED\tCONNECTED\tSEQUENCE")
+		for _, agency := range r {
+			fmt.Fprintf(w, "%d\t%d\t%t\t%t\t%d\n", agency.ID, agency.Bets, agency.Finished, agency.Connected, agency.Sequence)
+		}
+	case []central.WinnersInfo:
+		fmt.Fprintln(w, "AGENCY\tDOCUMENT\tDIGITS\tPAYOUT")
+		for _, winners := range r {
+			for i, document := range winners.Documents {
+				fmt.Fprintf(w, "%d\t%d\t%d\t%s\n", winners.Agency, document, winners.Tiers[i], protocol.FormatAmount(winners.Payouts[i]))
+			}
+		}
+	}
+	return w.Flush()
+}
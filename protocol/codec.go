@@ -0,0 +1,154 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// ErrShortPayload is returned when a payload ends before all the fields of a
+// message could be decoded
+var ErrShortPayload = errors.New("payload too short")
+
+// writer Appends big endian encoded fields to a growing buffer
+type writer struct {
+	buf     []byte
+	encoder Encoder
+}
+
+func (w *writer) putUint8(v uint8) {
+	w.buf = append(w.buf, v)
+}
+
+func (w *writer) putUint16(v uint16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	w.buf = append(w.buf, b[:]...)
+}
+
+func (w *writer) putUint32(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	w.buf = append(w.buf, b[:]...)
+}
+
+func (w *writer) putUint64(v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	w.buf = append(w.buf, b[:]...)
+}
+
+// putString Writes a string prefixed by its length in bytes (1 byte). The
+// string must have been checked against MaxStringLength before
+func (w *writer) putString(v string) {
+	w.putUint8(uint8(len(v)))
+	w.buf = append(w.buf, v...)
+}
+
+// putBytes Writes a byte string prefixed by its length (1 byte). It must
+// have been checked against MaxStringLength before
+func (w *writer) putBytes(v []byte) {
+	w.putUint8(uint8(len(v)))
+	w.buf = append(w.buf, v...)
+}
+
+// putName Writes a name normalized according to the encoder configuration
+func (w *writer) putName(v string) error {
+	name, err := NormalizeName(v, w.encoder.NameLimit, w.encoder.NamePolicy)
+	if err != nil {
+		return err
+	}
+	w.putString(name)
+	return nil
+}
+
+// reader Consumes big endian encoded fields from a payload. The first error
+// found is kept and every following read becomes a no-op, so callers can
+// decode a whole message and check err only once at the end
+type reader struct {
+	buf []byte
+	err error
+}
+
+func (r *reader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.buf) < n {
+		r.err = ErrShortPayload
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *reader) uint8() uint8 {
+	b := r.take(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *reader) uint16() uint16 {
+	b := r.take(2)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+func (r *reader) uint32() uint32 {
+	b := r.take(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (r *reader) uint64() uint64 {
+	b := r.take(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+func (r *reader) string() string {
+	n := int(r.uint8())
+	return string(r.take(n))
+}
+
+// bytes Reads a byte string. The result does not alias the payload
+func (r *reader) bytes() []byte {
+	n := int(r.uint8())
+	b := r.take(n)
+	if len(b) == 0 {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
+// name Reads a string that must be valid UTF-8. The bytes are kept as they
+// were sent so decoding a frame and encoding it again yields the same frame
+func (r *reader) name() string {
+	s := r.string()
+	if r.err == nil && !utf8.ValidString(s) {
+		r.err = errors.Errorf("name %q is not valid UTF-8", s)
+	}
+	return s
+}
+
+// finish Returns the first error found while decoding or an error if some
+// bytes of the payload were not consumed
+func (r *reader) finish() error {
+	if r.err != nil {
+		return r.err
+	}
+	if len(r.buf) != 0 {
+		return errors.Errorf("%d trailing bytes in payload", len(r.buf))
+	}
+	return nil
+}
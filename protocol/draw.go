@@ -0,0 +1,38 @@
+package protocol
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+const (
+	// SeedSize Size of the secret seed the central draws the winning number from
+	SeedSize = 32
+	// CommitmentSize Size of the commitment to the seed
+	CommitmentSize = sha256.Size
+	// NumberRange Winning numbers are between 0 and NumberRange - 1
+	NumberRange = 10000
+	// NumberDigits Decimal digits of a winning number
+	NumberDigits = 4
+)
+
+// winningNumberDomain Prefix of the hash the winning number is derived from,
+// so it is unrelated to the commitment
+const winningNumberDomain = "tp0/winning-number/v1"
+
+// Commit Returns the commitment to a seed, its SHA-256 hash. The central
+// publishes it before bets open so it cannot change the seed afterwards
+func Commit(seed []byte) [CommitmentSize]byte {
+	return sha256.Sum256(seed)
+}
+
+// WinningNumber Derives the winning number from the seed revealed after the
+// draw. Anyone holding the seed gets the same number: the first 8 bytes of
+// SHA-256(domain || seed), big endian, modulo NumberRange
+func WinningNumber(seed []byte) uint16 {
+	h := sha256.New()
+	h.Write([]byte(winningNumberDomain))
+	h.Write(seed)
+	sum := h.Sum(nil)
+	return uint16(binary.BigEndian.Uint64(sum[:8]) % NumberRange)
+}
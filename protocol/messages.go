@@ -0,0 +1,707 @@
+package protocol
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// BirthdateSize Birthdates are sent as fixed size YYYY-MM-DD strings
+const BirthdateSize = len("2006-01-02")
+
+// MaxBatchAmount Largest amount of bets that fits in the batch counter
+const MaxBatchAmount = math.MaxUint8
+
+// putContest Writes the id of a contest. Requests of the agencies carry the
+// contest they take part in, so the central can run several of them, like
+// successive weekly draws, each with its own bets and winning number. An empty
+// id refers to the default contest of the central
+func putContest(w *writer, contest string) error {
+	if len(contest) > MaxStringLength {
+		return errors.Errorf("contest id is %d bytes long, max is %d", len(contest), MaxStringLength)
+	}
+	w.putString(contest)
+	return nil
+}
+
+// MaxCombinedNumbers Most numbers a BetCombined can play
+const MaxCombinedNumbers = 10
+
+// TypedBetMarker Sent in place of the number of a single bet to tell that
+// the type of the bet and its numbers follow, so single bets are encoded as
+// they were before bet types existed. A single bet cannot play it
+const TypedBetMarker = math.MaxUint16
+
+// BetType Kind of bet, which decides how its numbers are played
+type BetType uint8
+
+const (
+	// BetSingle A single number, the only kind of bet of the original
+	// protocol
+	BetSingle BetType = iota
+	// BetCombined Quiniela combinada: from 2 to MaxCombinedNumbers numbers
+	// played at once, the stake spread evenly among them
+	BetCombined
+)
+
+func (t BetType) String() string {
+	switch t {
+	case BetSingle:
+		return "single"
+	case BetCombined:
+		return "combined"
+	}
+	return "unknown"
+}
+
+// Bet A single bet as it travels on the wire
+type Bet struct {
+	FirstName string
+	LastName  string
+	Document  uint64
+	Birthdate string
+	// Type BetSingle unless set
+	Type BetType
+	// Number Number bet by a BetSingle
+	Number uint16
+	// Numbers Numbers bet by the other types, empty for a BetSingle
+	Numbers []uint16
+	// Stake Amount bet in cents, zero for bets that carry no money
+	Stake uint32
+}
+
+func (b *Bet) encode(w *writer) error {
+	if len(b.Birthdate) != BirthdateSize {
+		return errors.Errorf("birthdate %q must be %d bytes long", b.Birthdate, BirthdateSize)
+	}
+	if err := w.putName(b.FirstName); err != nil {
+		return errors.Wrap(err, "first name")
+	}
+	if err := w.putName(b.LastName); err != nil {
+		return errors.Wrap(err, "last name")
+	}
+	w.putUint64(b.Document)
+	w.buf = append(w.buf, b.Birthdate...)
+	if b.Type == BetSingle {
+		// The number alone, as before bet types existed
+		if b.Number == TypedBetMarker {
+			return errors.Errorf("number %d is reserved for bets of other types", b.Number)
+		}
+		w.putUint16(b.Number)
+	} else {
+		if len(b.Numbers) > math.MaxUint8 {
+			return errors.Errorf("bet has %d numbers, max is %d", len(b.Numbers), math.MaxUint8)
+		}
+		w.putUint16(TypedBetMarker)
+		w.putUint8(uint8(b.Type))
+		w.putUint8(uint8(len(b.Numbers)))
+		for _, number := range b.Numbers {
+			w.putUint16(number)
+		}
+	}
+	w.putUint32(b.Stake)
+	return nil
+}
+
+// NumbersSeparator Separates the numbers of a bet written as text
+const NumbersSeparator = "/"
+
+// FormatNumbers Writes the numbers the bet plays as text, like 7574 or
+// 7574/1033
+func FormatNumbers(bet Bet) string {
+	played := bet.Played()
+	numbers := make([]string, len(played))
+	for i, number := range played {
+		numbers[i] = strconv.Itoa(int(number))
+	}
+	return strings.Join(numbers, NumbersSeparator)
+}
+
+// Played Returns the numbers the bet plays, whatever its type
+func (b *Bet) Played() []uint16 {
+	if b.Type == BetSingle {
+		return []uint16{b.Number}
+	}
+	return b.Numbers
+}
+
+func (b *Bet) decode(r *reader) {
+	b.FirstName = r.name()
+	b.LastName = r.name()
+	b.Document = r.uint64()
+	b.Birthdate = string(r.take(BirthdateSize))
+	if number := r.uint16(); number != TypedBetMarker {
+		b.Number = number
+	} else {
+		b.Type = BetType(r.uint8())
+		if b.Type == BetSingle && r.err == nil {
+			r.err = errors.New("single bet sent with a type")
+		}
+		n := int(r.uint8())
+		b.Numbers = make([]uint16, 0, n)
+		for i := 0; i < n && r.err == nil; i++ {
+			b.Numbers = append(b.Numbers, r.uint16())
+		}
+	}
+	b.Stake = r.uint32()
+}
+
+// BetBatch Group of bets registered by an agency in a single request
+type BetBatch struct {
+	Agency uint8
+	// Contest Contest the bets take part in, the default one of the central
+	// if empty
+	Contest string
+	// Sequence Position of the batch among those of the agency, starting at
+	// 1. The central stores every sequence once, so a batch sent again after
+	// a lost ack is not duplicated. Zero skips the check
+	Sequence uint32
+	Bets     []Bet
+}
+
+func (m *BetBatch) Type() MessageType { return MsgBetBatch }
+
+func (m *BetBatch) encode(w *writer) error {
+	if len(m.Bets) > MaxBatchAmount {
+		return errors.Errorf("batch has %d bets, max is %d", len(m.Bets), MaxBatchAmount)
+	}
+	w.putUint8(m.Agency)
+	if err := putContest(w, m.Contest); err != nil {
+		return err
+	}
+	w.putUint32(m.Sequence)
+	w.putUint8(uint8(len(m.Bets)))
+	for i := range m.Bets {
+		if err := m.Bets[i].encode(w); err != nil {
+			return errors.Wrapf(err, "bet %d", i)
+		}
+	}
+	return nil
+}
+
+func (m *BetBatch) decode(r *reader) {
+	m.Agency = r.uint8()
+	m.Contest = r.string()
+	m.Sequence = r.uint32()
+	n := int(r.uint8())
+	m.Bets = make([]Bet, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		m.Bets[i].decode(r)
+	}
+}
+
+// Status Result code carried by an Ack
+type Status uint8
+
+const (
+	StatusOK Status = iota
+	StatusFail
+	StatusDrawNotReady
+	// StatusContestClosed Answers the requests of an agency that did not
+	// finish before the draw: its bets are refused and it has no winners
+	StatusContestClosed
+	// StatusNotFound Answers a CancelBet for a bet the agency never stored
+	StatusNotFound
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusOK:
+		return "ok"
+	case StatusFail:
+		return "fail"
+	case StatusDrawNotReady:
+		return "draw_not_ready"
+	case StatusContestClosed:
+		return "contest_closed"
+	case StatusNotFound:
+		return "not_found"
+	}
+	return "unknown"
+}
+
+// Ack Generic response of the central to a request
+type Ack struct {
+	Status Status
+	// BetID Id the central gave to the first bet of a BetBatch it stored,
+	// the next ones following it in order. Ids count the bets of every
+	// agency from 1. Zero in any other answer
+	BetID uint32
+}
+
+func (m *Ack) Type() MessageType { return MsgAck }
+
+func (m *Ack) encode(w *writer) error {
+	w.putUint8(uint8(m.Status))
+	w.putUint32(m.BetID)
+	return nil
+}
+
+func (m *Ack) decode(r *reader) {
+	m.Status = Status(r.uint8())
+	m.BetID = r.uint32()
+}
+
+// CancelBet Voids a bet of the agency while the contest is open. The bet is
+// identified by the id the central gave it or, if BetID is zero, by its
+// document and the numbers it plays, which must match a single bet. Answered
+// with an Ack
+type CancelBet struct {
+	Agency   uint8
+	Contest  string
+	BetID    uint32
+	Document uint64
+	// Numbers Played by the bet, see Bet.Played
+	Numbers []uint16
+}
+
+func (m *CancelBet) Type() MessageType { return MsgCancelBet }
+
+func (m *CancelBet) encode(w *writer) error {
+	if len(m.Numbers) > math.MaxUint8 {
+		return errors.Errorf("bet has %d numbers, max is %d", len(m.Numbers), math.MaxUint8)
+	}
+	w.putUint8(m.Agency)
+	if err := putContest(w, m.Contest); err != nil {
+		return err
+	}
+	w.putUint32(m.BetID)
+	w.putUint64(m.Document)
+	w.putUint8(uint8(len(m.Numbers)))
+	for _, number := range m.Numbers {
+		w.putUint16(number)
+	}
+	return nil
+}
+
+func (m *CancelBet) decode(r *reader) {
+	m.Agency = r.uint8()
+	m.Contest = r.string()
+	m.BetID = r.uint32()
+	m.Document = r.uint64()
+	n := int(r.uint8())
+	for i := 0; i < n && r.err == nil; i++ {
+		m.Numbers = append(m.Numbers, r.uint16())
+	}
+}
+
+// BetStatusQuery Asks for the bets of the agency placed by a document.
+// Answered with BetStatuses, or with an Ack if the agency cannot ask
+type BetStatusQuery struct {
+	Agency   uint8
+	Contest  string
+	Document uint64
+}
+
+func (m *BetStatusQuery) Type() MessageType { return MsgBetStatusQuery }
+
+func (m *BetStatusQuery) encode(w *writer) error {
+	w.putUint8(m.Agency)
+	if err := putContest(w, m.Contest); err != nil {
+		return err
+	}
+	w.putUint64(m.Document)
+	return nil
+}
+
+func (m *BetStatusQuery) decode(r *reader) {
+	m.Agency = r.uint8()
+	m.Contest = r.string()
+	m.Document = r.uint64()
+}
+
+// BetStatus State of a stored bet
+type BetStatus uint8
+
+const (
+	// BetStored The bet waits for the draw
+	BetStored BetStatus = iota
+	// BetCancelled The agency cancelled the bet, which does not play
+	BetCancelled
+	// BetWon The bet won a prize in the draw
+	BetWon
+	// BetLost The bet did not win, or its agency was left out of the draw
+	BetLost
+)
+
+func (s BetStatus) String() string {
+	switch s {
+	case BetStored:
+		return "stored"
+	case BetCancelled:
+		return "cancelled"
+	case BetWon:
+		return "winner"
+	case BetLost:
+		return "not_winner"
+	}
+	return "unknown"
+}
+
+// BetState A stored bet along with its id and state. The prize tier and the
+// payout in cents are only set for BetWon
+type BetState struct {
+	ID     uint32
+	Status BetStatus
+	Tier   uint8
+	Payout uint64
+	Bet    Bet
+}
+
+// BetStatuses Answers a BetStatusQuery with the bets of the document in the
+// order they were stored. Up to MaxBatchAmount bets are sent, as many as fit
+// in a frame, and More tells whether some were left out
+type BetStatuses struct {
+	Bets []BetState
+	More bool
+}
+
+func (m *BetStatuses) Type() MessageType { return MsgBetStatuses }
+
+func (m *BetStatuses) encode(w *writer) error {
+	if len(m.Bets) > MaxBatchAmount {
+		return errors.Errorf("%d bets, max is %d", len(m.Bets), MaxBatchAmount)
+	}
+	w.putUint8(uint8(len(m.Bets)))
+	for i := range m.Bets {
+		state := &m.Bets[i]
+		w.putUint32(state.ID)
+		w.putUint8(uint8(state.Status))
+		w.putUint8(state.Tier)
+		w.putUint64(state.Payout)
+		if err := state.Bet.encode(w); err != nil {
+			return errors.Wrapf(err, "bet %d", i)
+		}
+	}
+	more := uint8(0)
+	if m.More {
+		more = 1
+	}
+	w.putUint8(more)
+	return nil
+}
+
+func (m *BetStatuses) decode(r *reader) {
+	n := int(r.uint8())
+	m.Bets = make([]BetState, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		state := &m.Bets[i]
+		state.ID = r.uint32()
+		state.Status = BetStatus(r.uint8())
+		state.Tier = r.uint8()
+		state.Payout = r.uint64()
+		state.Bet.decode(r)
+	}
+	m.More = r.uint8() != 0
+}
+
+// EndOfBets Notifies that the agency has sent all of its bets
+type EndOfBets struct {
+	Agency  uint8
+	Contest string
+}
+
+func (m *EndOfBets) Type() MessageType { return MsgEndOfBets }
+
+func (m *EndOfBets) encode(w *writer) error {
+	w.putUint8(m.Agency)
+	return putContest(w, m.Contest)
+}
+
+func (m *EndOfBets) decode(r *reader) {
+	m.Agency = r.uint8()
+	m.Contest = r.string()
+}
+
+// WinnersQuery Asks for the winners of the agency. Answered with Winners
+// once the draw happened, or with an Ack with StatusDrawNotReady before
+type WinnersQuery struct {
+	Agency  uint8
+	Contest string
+}
+
+func (m *WinnersQuery) Type() MessageType { return MsgWinnersQuery }
+
+func (m *WinnersQuery) encode(w *writer) error {
+	w.putUint8(m.Agency)
+	return putContest(w, m.Contest)
+}
+
+func (m *WinnersQuery) decode(r *reader) {
+	m.Agency = r.uint8()
+	m.Contest = r.string()
+}
+
+// AwaitWinners Asks for the winners of the agency like WinnersQuery, but the
+// central holds the request until the draw happens and then pushes Winners
+// over the same connection. Centrals that do not support it close the
+// connection or answer with an Ack with StatusFail
+type AwaitWinners struct {
+	Agency  uint8
+	Contest string
+}
+
+func (m *AwaitWinners) Type() MessageType { return MsgAwaitWinners }
+
+func (m *AwaitWinners) encode(w *writer) error {
+	w.putUint8(m.Agency)
+	return putContest(w, m.Contest)
+}
+
+func (m *AwaitWinners) decode(r *reader) {
+	m.Agency = r.uint8()
+	m.Contest = r.string()
+}
+
+// ResumeQuery Asks where the agency left off, so an agency that reconnects
+// after a crash of either side does not send its bets again
+type ResumeQuery struct {
+	Agency  uint8
+	Contest string
+}
+
+func (m *ResumeQuery) Type() MessageType { return MsgResumeQuery }
+
+func (m *ResumeQuery) encode(w *writer) error {
+	w.putUint8(m.Agency)
+	return putContest(w, m.Contest)
+}
+
+func (m *ResumeQuery) decode(r *reader) {
+	m.Agency = r.uint8()
+	m.Contest = r.string()
+}
+
+// Resume Answers a ResumeQuery with the last batch sequence stored for the
+// agency, zero if none, and whether it already finished sending bets
+type Resume struct {
+	Sequence uint32
+	Finished bool
+}
+
+func (m *Resume) Type() MessageType { return MsgResume }
+
+func (m *Resume) encode(w *writer) error {
+	w.putUint32(m.Sequence)
+	finished := uint8(0)
+	if m.Finished {
+		finished = 1
+	}
+	w.putUint8(finished)
+	return nil
+}
+
+func (m *Resume) decode(r *reader) {
+	m.Sequence = r.uint32()
+	m.Finished = r.uint8() != 0
+}
+
+// CommitmentQuery Asks for the commitment to the seed of the draw. Agencies
+// ask for it before sending their bets
+type CommitmentQuery struct {
+	Agency  uint8
+	Contest string
+}
+
+func (m *CommitmentQuery) Type() MessageType { return MsgCommitmentQuery }
+
+func (m *CommitmentQuery) encode(w *writer) error {
+	w.putUint8(m.Agency)
+	return putContest(w, m.Contest)
+}
+
+func (m *CommitmentQuery) decode(r *reader) {
+	m.Agency = r.uint8()
+	m.Contest = r.string()
+}
+
+// Commitment Hash of the secret seed of the draw, see Commit
+type Commitment struct {
+	Hash [CommitmentSize]byte
+}
+
+func (m *Commitment) Type() MessageType { return MsgCommitment }
+
+func (m *Commitment) encode(w *writer) error {
+	w.buf = append(w.buf, m.Hash[:]...)
+	return nil
+}
+
+func (m *Commitment) decode(r *reader) {
+	copy(m.Hash[:], r.take(CommitmentSize))
+}
+
+// Winners Documents of the winning bets of a single agency. The seed the
+// winning number was derived from is revealed along with them, so the agency
+// can check it against the commitment published before the draw. Winners
+// that do not fit in a frame are split across several by WriteMessage and
+// joined back by ReadMessage
+type Winners struct {
+	// Contest Contest the draw belongs to
+	Contest string
+	// DrawnAt Unix time of the draw in milliseconds, zero if unknown
+	DrawnAt       int64
+	WinningNumber uint16
+	// Seed Empty if the central does not support verifiable draws
+	Seed      []byte
+	Documents []uint64
+	// Tiers Prize tier of every document: how many trailing digits of the
+	// winning number the bet matched, NumberDigits for an exact match. Empty
+	// means every document matched exactly
+	Tiers []uint8
+	// Pool Prize pool of the contest in cents: the stakes of the bets that
+	// took part in the draw, minus the cut of the house
+	Pool uint64
+	// Payouts Amount in cents paid to every document, its share of the pool.
+	// Empty means nothing is paid, as for bets without stakes
+	Payouts []uint64
+	// Signature Ed25519 signature of the central, see SignWinners. Empty if
+	// the central does not sign its results
+	Signature []byte
+	// More Set in every frame of split winners but the last one, which is
+	// the only one carrying the signature
+	More bool
+}
+
+// winnersPerFrame Most winners sent in a frame: 17 bytes each, in what is
+// left once contest, seed and signature take their largest size
+const winnersPerFrame = (MaxFrameSize - HeaderSize - 3*(1+MaxStringLength) - 8 - 2 - 8 - 1 - 2) / 17
+
+func (m *Winners) Type() MessageType { return MsgWinners }
+
+func (m *Winners) encode(w *writer) error {
+	if len(m.Documents) > math.MaxUint16 {
+		return errors.Errorf("%d winners do not fit in a frame", len(m.Documents))
+	}
+	if len(m.Seed) > MaxStringLength || len(m.Signature) > MaxStringLength {
+		return errors.Errorf("seed or signature longer than %d bytes", MaxStringLength)
+	}
+	if len(m.Tiers) != 0 && len(m.Tiers) != len(m.Documents) {
+		return errors.Errorf("%d tiers for %d winners", len(m.Tiers), len(m.Documents))
+	}
+	if len(m.Payouts) != 0 && len(m.Payouts) != len(m.Documents) {
+		return errors.Errorf("%d payouts for %d winners", len(m.Payouts), len(m.Documents))
+	}
+	if err := putContest(w, m.Contest); err != nil {
+		return err
+	}
+	w.putUint64(uint64(m.DrawnAt))
+	w.putUint16(m.WinningNumber)
+	w.putBytes(m.Seed)
+	w.putUint64(m.Pool)
+	more := uint8(0)
+	if m.More {
+		more = 1
+	}
+	w.putUint8(more)
+	w.putUint16(uint16(len(m.Documents)))
+	for i, d := range m.Documents {
+		w.putUint64(d)
+		w.putUint8(m.Tier(i))
+		w.putUint64(m.Payout(i))
+	}
+	w.putBytes(m.Signature)
+	return nil
+}
+
+// split Returns the frames the winners are sent in, winnersPerFrame winners
+// each. Every frame repeats the contest, the draw and the pool
+func (m *Winners) split() []*Winners {
+	n := len(m.Documents)
+	if n <= winnersPerFrame || (len(m.Tiers) != 0 && len(m.Tiers) != n) || (len(m.Payouts) != 0 && len(m.Payouts) != n) {
+		// Sent as is, so the encoder reports the winners that are wrong
+		return []*Winners{m}
+	}
+	var parts []*Winners
+	for start := 0; start < n; start += winnersPerFrame {
+		end := start + winnersPerFrame
+		if end > n {
+			end = n
+		}
+		part := *m
+		part.Documents = m.Documents[start:end]
+		if len(m.Tiers) != 0 {
+			part.Tiers = m.Tiers[start:end]
+		}
+		if len(m.Payouts) != 0 {
+			part.Payouts = m.Payouts[start:end]
+		}
+		part.More = end < n
+		if part.More {
+			part.Signature = nil
+		}
+		parts = append(parts, &part)
+	}
+	return parts
+}
+
+// join Appends the winners of the next frame of split winners
+func (m *Winners) join(next *Winners) error {
+	if next.Contest != m.Contest || next.DrawnAt != m.DrawnAt || next.WinningNumber != m.WinningNumber || next.Pool != m.Pool || string(next.Seed) != string(m.Seed) {
+		return errors.New("frame belongs to other winners")
+	}
+	m.Documents = append(m.Documents, next.Documents...)
+	m.Tiers = append(m.Tiers, next.Tiers...)
+	m.Payouts = append(m.Payouts, next.Payouts...)
+	m.Signature = next.Signature
+	m.More = next.More
+	return nil
+}
+
+// Tier Returns the prize tier of the i-th document
+func (m *Winners) Tier(i int) uint8 {
+	if len(m.Tiers) == 0 {
+		return NumberDigits
+	}
+	return m.Tiers[i]
+}
+
+// Payout Returns the amount in cents paid to the i-th document
+func (m *Winners) Payout(i int) uint64 {
+	if len(m.Payouts) == 0 {
+		return 0
+	}
+	return m.Payouts[i]
+}
+
+func (m *Winners) decode(r *reader) {
+	m.Contest = r.string()
+	m.DrawnAt = int64(r.uint64())
+	m.WinningNumber = r.uint16()
+	m.Seed = r.bytes()
+	m.Pool = r.uint64()
+	m.More = r.uint8() != 0
+	n := int(r.uint16())
+	m.Documents = make([]uint64, 0, n)
+	m.Tiers = make([]uint8, 0, n)
+	m.Payouts = make([]uint64, 0, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		m.Documents = append(m.Documents, r.uint64())
+		m.Tiers = append(m.Tiers, r.uint8())
+		m.Payouts = append(m.Payouts, r.uint64())
+	}
+	m.Signature = r.bytes()
+}
+
+// MaxBatchAmountFor Returns the largest amount of copies of bet that can be sent
+// in a single BetBatch frame of the contest without exceeding budget bytes.
+// The real encoder is used so the result is exact. Zero is returned if not
+// even one bet fits
+func MaxBatchAmountFor(bet Bet, contest string, budget int) (int, error) {
+	best := 0
+	batch := &BetBatch{Contest: contest}
+	for n := 1; n <= MaxBatchAmount; n++ {
+		batch.Bets = append(batch.Bets, bet)
+		frame, err := Encode(batch)
+		if err != nil {
+			return 0, err
+		}
+		if len(frame) > budget {
+			break
+		}
+		best = n
+	}
+	return best, nil
+}
@@ -0,0 +1,55 @@
+package protocol
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ParseAmount Parses an amount of money written in units with up to two
+// decimals, like 150 or 150.50, and returns it in cents. Amounts are kept in
+// cents everywhere so no rounding happens on the way
+func ParseAmount(s string) (uint32, error) {
+	units, cents := s, ""
+	if dot := strings.IndexByte(s, '.'); dot >= 0 {
+		units, cents = s[:dot], s[dot+1:]
+		if cents == "" || len(cents) > 2 {
+			return 0, errors.Errorf("amount %q must have one or two decimals", s)
+		}
+	}
+	if !isDigits(units) || (cents != "" && !isDigits(cents)) {
+		return 0, errors.Errorf("amount %q is not a number", s)
+	}
+	if len(cents) == 1 {
+		cents += "0"
+	}
+	whole, err := strconv.ParseUint(units, 10, 32)
+	if err != nil || whole > math.MaxUint32/100 {
+		return 0, errors.Errorf("amount %q is too large", s)
+	}
+	fraction, _ := strconv.ParseUint("0"+cents, 10, 8)
+	amount := whole*100 + fraction
+	if amount > math.MaxUint32 {
+		return 0, errors.Errorf("amount %q is too large", s)
+	}
+	return uint32(amount), nil
+}
+
+// FormatAmount Writes an amount in cents as units with two decimals
+func FormatAmount(cents uint64) string {
+	return strconv.FormatUint(cents/100, 10) + "." + strconv.FormatUint(cents%100/10, 10) + strconv.FormatUint(cents%10, 10)
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
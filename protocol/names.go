@@ -0,0 +1,82 @@
+package protocol
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"golang.org/x/text/unicode/norm"
+)
+
+// NamePolicy What the encoder does with a name longer than the field limit
+type NamePolicy uint8
+
+const (
+	// NameReject Fails to encode the message
+	NameReject NamePolicy = iota
+	// NameTruncate Cuts the name at the last character that fits. Combining
+	// marks are never separated from the character they modify
+	NameTruncate
+)
+
+func (p NamePolicy) String() string {
+	switch p {
+	case NameReject:
+		return "reject"
+	case NameTruncate:
+		return "truncate"
+	}
+	return "unknown"
+}
+
+// ParseNamePolicy Parses the name of a policy as returned by String
+func ParseNamePolicy(s string) (NamePolicy, error) {
+	switch strings.ToLower(s) {
+	case "reject":
+		return NameReject, nil
+	case "truncate":
+		return NameTruncate, nil
+	}
+	return 0, errors.Errorf("unknown name policy %q", s)
+}
+
+// ErrNameTooLong is returned when a name does not fit in its field and the
+// policy is NameReject
+var ErrNameTooLong = errors.New("name too long")
+
+// NormalizeName Returns the name in Unicode NFC form, the one sent on the wire.
+// The same name typed with precomposed ("á") or decomposed ("a" + U+0301)
+// accents is therefore always sent with the same bytes. If the normalized name
+// is longer than limit bytes it is rejected or truncated according to policy
+func NormalizeName(name string, limit int, policy NamePolicy) (string, error) {
+	if !utf8.ValidString(name) {
+		return "", errors.Errorf("name %q is not valid UTF-8", name)
+	}
+	if limit <= 0 || limit > MaxStringLength {
+		limit = MaxStringLength
+	}
+
+	normalized := norm.NFC.String(name)
+	if len(normalized) <= limit {
+		return normalized, nil
+	}
+	if policy != NameTruncate {
+		return "", errors.Wrapf(ErrNameTooLong, "%q is %d bytes long, max is %d", normalized, len(normalized), limit)
+	}
+
+	// Advance one normalization boundary at a time so a character is never
+	// split from its combining marks
+	end := 0
+	for end < len(normalized) {
+		next := end + norm.NFC.NextBoundaryInString(normalized[end:], true)
+		if next > limit {
+			break
+		}
+		end = next
+	}
+	truncated := strings.TrimRight(normalized[:end], " ")
+	if truncated == "" {
+		return "", errors.Wrapf(ErrNameTooLong, "%q cannot be truncated to %d bytes", normalized, limit)
+	}
+	return truncated, nil
+}
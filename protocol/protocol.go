@@ -0,0 +1,254 @@
+// Package protocol Binary protocol spoken between the agencies (clients) and
+// the lottery central (server).
+//
+// Every message travels in a frame with the following layout:
+//
+//	| length (2 bytes) | type (1 byte) | payload (length - 1 bytes) |
+//
+// Integers are big endian. Strings are UTF-8 encoded and prefixed by their
+// length in bytes (1 byte), so they can be at most MaxStringLength bytes long.
+// Names are sent in Unicode NFC form, see NormalizeName.
+package protocol
+
+import (
+	"io"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// LengthSize Size of the frame length prefix
+	LengthSize = 2
+	// HeaderSize Size of the length prefix plus the message type
+	HeaderSize = LengthSize + 1
+	// MaxFrameSize Largest frame that can be represented by the length prefix
+	MaxFrameSize = LengthSize + math.MaxUint16
+	// MaxStringLength Largest string (in bytes) that fits in a string field
+	MaxStringLength = math.MaxUint8
+)
+
+// ErrFrameTooLarge is returned when a message does not fit in a single frame
+var ErrFrameTooLarge = errors.New("frame too large")
+
+// MessageType Discriminator sent in every frame header
+type MessageType uint8
+
+const (
+	MsgBetBatch MessageType = iota + 1
+	MsgAck
+	MsgEndOfBets
+	MsgWinnersQuery
+	MsgWinners
+	MsgAwaitWinners
+	MsgCommitmentQuery
+	MsgCommitment
+	MsgResumeQuery
+	MsgResume
+	MsgCancelBet
+	MsgBetStatusQuery
+	MsgBetStatuses
+)
+
+func (t MessageType) String() string {
+	switch t {
+	case MsgBetBatch:
+		return "bet_batch"
+	case MsgAck:
+		return "ack"
+	case MsgEndOfBets:
+		return "end_of_bets"
+	case MsgWinnersQuery:
+		return "winners_query"
+	case MsgWinners:
+		return "winners"
+	case MsgAwaitWinners:
+		return "await_winners"
+	case MsgCommitmentQuery:
+		return "commitment_query"
+	case MsgCommitment:
+		return "commitment"
+	case MsgResumeQuery:
+		return "resume_query"
+	case MsgResume:
+		return "resume"
+	case MsgCancelBet:
+		return "cancel_bet"
+	case MsgBetStatusQuery:
+		return "bet_status_query"
+	case MsgBetStatuses:
+		return "bet_statuses"
+	}
+	return "unknown"
+}
+
+// Message Every value that can be sent in a frame
+type Message interface {
+	Type() MessageType
+	encode(w *writer) error
+	decode(r *reader)
+}
+
+// newMessage Returns an empty message of the given type so it can be decoded
+func newMessage(t MessageType) (Message, error) {
+	switch t {
+	case MsgBetBatch:
+		return &BetBatch{}, nil
+	case MsgAck:
+		return &Ack{}, nil
+	case MsgEndOfBets:
+		return &EndOfBets{}, nil
+	case MsgWinnersQuery:
+		return &WinnersQuery{}, nil
+	case MsgWinners:
+		return &Winners{}, nil
+	case MsgAwaitWinners:
+		return &AwaitWinners{}, nil
+	case MsgCommitmentQuery:
+		return &CommitmentQuery{}, nil
+	case MsgCommitment:
+		return &Commitment{}, nil
+	case MsgResumeQuery:
+		return &ResumeQuery{}, nil
+	case MsgResume:
+		return &Resume{}, nil
+	case MsgCancelBet:
+		return &CancelBet{}, nil
+	case MsgBetStatusQuery:
+		return &BetStatusQuery{}, nil
+	case MsgBetStatuses:
+		return &BetStatuses{}, nil
+	}
+	return nil, errors.Errorf("unknown message type %d", t)
+}
+
+// Encoder Serializes messages into frames. Names are normalized to NFC and
+// the ones longer than NameLimit bytes (MaxStringLength if zero) are handled
+// according to NamePolicy. The zero value rejects them
+type Encoder struct {
+	NameLimit  int
+	NamePolicy NamePolicy
+}
+
+// Encode Serializes the message into a complete frame, header included
+func (e Encoder) Encode(msg Message) ([]byte, error) {
+	w := &writer{buf: make([]byte, HeaderSize, 64), encoder: e}
+	w.buf[LengthSize] = byte(msg.Type())
+	if err := msg.encode(w); err != nil {
+		return nil, errors.Wrapf(err, "could not encode %v", msg.Type())
+	}
+	if len(w.buf) > MaxFrameSize {
+		return nil, errors.Wrapf(ErrFrameTooLarge, "%v needs %d bytes", msg.Type(), len(w.buf))
+	}
+	length := len(w.buf) - LengthSize
+	w.buf[0] = byte(length >> 8)
+	w.buf[1] = byte(length)
+	return w.buf, nil
+}
+
+// WriteMessage Encodes the message and writes the whole frame. Writes are
+// retried until every byte is sent to avoid short-writes. Winners that do
+// not fit in a frame are written in several, all of them encoded before
+// writing the first one
+func (e Encoder) WriteMessage(w io.Writer, msg Message) error {
+	parts := []Message{msg}
+	if winners, ok := msg.(*Winners); ok {
+		parts = parts[:0]
+		for _, part := range winners.split() {
+			parts = append(parts, part)
+		}
+	}
+	var frames []byte
+	for _, part := range parts {
+		frame, err := e.Encode(part)
+		if err != nil {
+			return err
+		}
+		frames = append(frames, frame...)
+	}
+	for sent := 0; sent < len(frames); {
+		n, err := w.Write(frames[sent:])
+		if err != nil {
+			return err
+		}
+		sent += n
+	}
+	return nil
+}
+
+// Encode Serializes the message with the default Encoder
+func Encode(msg Message) ([]byte, error) {
+	return Encoder{}.Encode(msg)
+}
+
+// Decode Parses a complete frame, header included
+func Decode(frame []byte) (Message, error) {
+	if len(frame) < HeaderSize {
+		return nil, ErrShortPayload
+	}
+	length := int(frame[0])<<8 | int(frame[1])
+	if length != len(frame)-LengthSize {
+		return nil, errors.Errorf("frame length %d does not match the %d bytes received", length, len(frame)-LengthSize)
+	}
+	return decodePayload(MessageType(frame[LengthSize]), frame[HeaderSize:])
+}
+
+func decodePayload(t MessageType, payload []byte) (Message, error) {
+	msg, err := newMessage(t)
+	if err != nil {
+		return nil, err
+	}
+	r := &reader{buf: payload}
+	msg.decode(r)
+	if err := r.finish(); err != nil {
+		return nil, errors.Wrapf(err, "could not decode %v", t)
+	}
+	return msg, nil
+}
+
+// WriteMessage Writes the message with the default Encoder
+func WriteMessage(w io.Writer, msg Message) error {
+	return Encoder{}.WriteMessage(w, msg)
+}
+
+// ReadMessage Reads a message and decodes it. Every message takes exactly
+// one frame but split Winners, whose frames are read and joined back.
+// io.ReadFull is used to avoid short-reads
+func ReadMessage(r io.Reader) (Message, error) {
+	msg, err := readFrame(r)
+	if err != nil {
+		return nil, err
+	}
+	winners, ok := msg.(*Winners)
+	for ok && winners.More {
+		next, err := readFrame(r)
+		if err != nil {
+			return nil, err
+		}
+		part, isWinners := next.(*Winners)
+		if !isWinners {
+			return nil, errors.Errorf("got %v in the middle of split winners", next.Type())
+		}
+		if err := winners.join(part); err != nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
+
+// readFrame Reads exactly one frame and decodes it
+func readFrame(r io.Reader) (Message, error) {
+	var header [HeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	length := int(header[0])<<8 | int(header[1])
+	if length < 1 {
+		return nil, errors.New("empty frame")
+	}
+	payload := make([]byte, length-1)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	return decodePayload(MessageType(header[LengthSize]), payload)
+}
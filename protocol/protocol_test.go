@@ -0,0 +1,374 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestBetBatchRoundTrip(t *testing.T) {
+	batch := &BetBatch{
+		Agency:   3,
+		Sequence: 70000,
+		Bets: []Bet{
+			{FirstName: "Santiago Lionel", LastName: "Lorca", Document: 30904465, Birthdate: "1999-03-17", Number: 7574},
+			{FirstName: "Tiago Nicolás", LastName: "Rivera", Document: 34407251, Birthdate: "2001-08-29", Number: 1033, Stake: 150050},
+			{FirstName: "Ana", LastName: "Perez", Document: 30000001, Birthdate: "1980-01-01", Type: BetCombined, Numbers: []uint16{7574, 1033, 12}},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteMessage(&buf, batch); err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+	msg, err := ReadMessage(&buf)
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if !reflect.DeepEqual(msg, batch) {
+		t.Errorf("decoded %+v, want %+v", msg, batch)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("%d bytes left unread", buf.Len())
+	}
+}
+
+func TestSingleBetsKeepTheirEncoding(t *testing.T) {
+	bet := Bet{FirstName: "a", LastName: "b", Document: 30904465, Birthdate: "1999-03-17", Number: 7574, Stake: 15050}
+	frame, err := Encode(&BetBatch{Bets: []Bet{bet}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Names, document and birthdate, then the number and the stake alone
+	encoded := frame[len(frame)-2-4:]
+	if want := []byte{0x1d, 0x96, 0, 0, 0x3a, 0xca}; !bytes.Equal(encoded, want) {
+		t.Errorf("single bet ends with % x, want % x", encoded, want)
+	}
+
+	bet.Number = TypedBetMarker
+	if _, err := Encode(&BetBatch{Bets: []Bet{bet}}); err == nil {
+		t.Errorf("single bet encoded with the number %d", TypedBetMarker)
+	}
+}
+
+func TestDecodeRejectsTruncatedFrame(t *testing.T) {
+	frame, err := Encode(&Winners{Documents: []uint64{1, 2}})
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if _, err := Decode(frame[:len(frame)-1]); err == nil {
+		t.Error("expected an error decoding a truncated frame")
+	}
+}
+
+func TestMaxBatchAmountForStaysUnderBudget(t *testing.T) {
+	bet := Bet{FirstName: "Milagros De Los Angeles", LastName: "Valenzuela", Birthdate: "2000-01-01"}
+	const budget = 8000
+
+	n, err := MaxBatchAmountFor(bet, "2026-42", budget)
+	if err != nil {
+		t.Fatalf("MaxBatchAmountFor: %v", err)
+	}
+
+	bets := make([]Bet, n+1)
+	for i := range bets {
+		bets[i] = bet
+	}
+	fits, _ := Encode(&BetBatch{Contest: "2026-42", Bets: bets[:n]})
+	exceeds, _ := Encode(&BetBatch{Contest: "2026-42", Bets: bets})
+	if len(fits) > budget || len(exceeds) <= budget {
+		t.Errorf("max amount %d: %d bytes fit, %d bytes exceed a budget of %d", n, len(fits), len(exceeds), budget)
+	}
+}
+
+func TestNamesAreSentInNFC(t *testing.T) {
+	decomposed := Bet{FirstName: "Tiago Nicola\u0301s", LastName: "Rivera", Birthdate: "2001-08-29"}
+	precomposed := decomposed
+	precomposed.FirstName = "Tiago Nicol\u00e1s"
+
+	frameA, err := Encode(&BetBatch{Bets: []Bet{decomposed}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	frameB, _ := Encode(&BetBatch{Bets: []Bet{precomposed}})
+	if !bytes.Equal(frameA, frameB) {
+		t.Error("precomposed and decomposed names are encoded differently")
+	}
+
+	msg, err := Decode(frameA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := msg.(*BetBatch).Bets[0].FirstName; got != precomposed.FirstName {
+		t.Errorf("decoded %q, want %q", got, precomposed.FirstName)
+	}
+	again, _ := Encode(msg)
+	if !bytes.Equal(again, frameA) {
+		t.Error("re-encoding a decoded frame changed its bytes")
+	}
+}
+
+func TestNormalizeNamePolicies(t *testing.T) {
+	// "José" with a decomposed accent is 5 bytes once normalized
+	name := "Jose\u0301 Maria"
+
+	if _, err := NormalizeName(name, 5, NameReject); err == nil {
+		t.Error("expected the name to be rejected")
+	}
+
+	tests := []struct {
+		limit int
+		want  string
+	}{
+		{limit: 5, want: "Jos\u00e9"},
+		{limit: 4, want: "Jos"},
+		{limit: 7, want: "Jos\u00e9 M"},
+		{limit: 255, want: "Jos\u00e9 Maria"},
+	}
+	for _, test := range tests {
+		got, err := NormalizeName(name, test.limit, NameTruncate)
+		if err != nil || got != test.want {
+			t.Errorf("limit %d: got %q (%v), want %q", test.limit, got, err, test.want)
+		}
+	}
+
+	// A combining mark is never separated from its base character
+	got, _ := NormalizeName("Ze\u0301\u0323", 3, NameTruncate)
+	if got != "Z" {
+		t.Errorf("got %q, want %q", got, "Z")
+	}
+}
+
+func TestDecodeRejectsInvalidUTF8(t *testing.T) {
+	frame, _ := Encode(&BetBatch{Bets: []Bet{{FirstName: "ab", LastName: "cd", Birthdate: "2001-08-29"}}})
+	frame[HeaderSize+8] = 0xff
+	if _, err := Decode(frame); err == nil {
+		t.Error("expected an error decoding an invalid name")
+	}
+}
+
+func TestWinnersRoundTripRevealsSeed(t *testing.T) {
+	seed := make([]byte, SeedSize)
+	for i := range seed {
+		seed[i] = byte(i)
+	}
+	winners := &Winners{Contest: "2026-42", DrawnAt: 1760821200123, WinningNumber: WinningNumber(seed), Seed: seed, Documents: []uint64{30904465}, Tiers: []uint8{NumberDigits}, Pool: 700000, Payouts: []uint64{700000}}
+	frame, err := Encode(winners)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	msg, err := Decode(frame)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if !reflect.DeepEqual(msg, winners) {
+		t.Errorf("decoded %+v, want %+v", msg, winners)
+	}
+}
+
+func TestWinningNumberIsDerivedFromSeed(t *testing.T) {
+	seed := make([]byte, SeedSize)
+	for i := range seed {
+		seed[i] = byte(i)
+	}
+	// Fixed vectors: agencies on any platform must derive the same values
+	if got := fmt.Sprintf("%x", Commit(seed)); got != "630dcd2966c4336691125448bbb25b4ff412a49c732db2c8abc1b8581bd710dd" {
+		t.Errorf("commitment %s", got)
+	}
+	if got := WinningNumber(seed); got != 5164 {
+		t.Errorf("winning number %d, want 5164", got)
+	}
+}
+
+func TestSignedWinnersDetectTampering(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	winners := &Winners{WinningNumber: 5164, Documents: []uint64{30904465, 34407251}}
+	if err := VerifyWinners(public, "1", 3, winners); err != ErrUnsigned {
+		t.Errorf("unsigned winners: %v", err)
+	}
+	if err := SignWinners(private, "1", 3, winners); err != nil {
+		t.Fatal(err)
+	}
+	frame, _ := Encode(winners)
+	decoded, err := Decode(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyWinners(public, "1", 3, decoded.(*Winners)); err != nil {
+		t.Errorf("valid signature: %v", err)
+	}
+
+	tampered := *winners
+	tampered.Documents = []uint64{30904465}
+	if err := VerifyWinners(public, "1", 3, &tampered); err != ErrBadSignature {
+		t.Errorf("removed winner: %v", err)
+	}
+	tampered.Documents = winners.Documents
+	tampered.Tiers = []uint8{4, 3}
+	if err := VerifyWinners(public, "1", 3, &tampered); err != ErrBadSignature {
+		t.Errorf("changed tier: %v", err)
+	}
+	tampered.Tiers = nil
+	tampered.Payouts = []uint64{1, 0}
+	if err := VerifyWinners(public, "1", 3, &tampered); err != ErrBadSignature {
+		t.Errorf("changed payout: %v", err)
+	}
+	tampered.Payouts = nil
+	tampered.DrawnAt = 1760821200123
+	if err := VerifyWinners(public, "1", 3, &tampered); err != ErrBadSignature {
+		t.Errorf("changed draw time: %v", err)
+	}
+	tampered.DrawnAt = 0
+	tampered.Seed = []byte("another seed")
+	if err := VerifyWinners(public, "1", 3, &tampered); err != ErrBadSignature {
+		t.Errorf("changed seed: %v", err)
+	}
+	if err := VerifyWinners(public, "1", 4, winners); err != ErrBadSignature {
+		t.Errorf("another agency: %v", err)
+	}
+	if err := VerifyWinners(public, "2", 3, winners); err != ErrBadSignature {
+		t.Errorf("another contest: %v", err)
+	}
+}
+
+func TestLargeWinnersAreSplitAcrossFrames(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 10000
+	winners := &Winners{Contest: "2026-42", DrawnAt: 1760821200123, WinningNumber: 5164, Seed: []byte("seed"), Pool: 700000}
+	for i := 0; i < n; i++ {
+		winners.Documents = append(winners.Documents, uint64(30000000+i))
+		winners.Tiers = append(winners.Tiers, uint8(1+i%NumberDigits))
+		winners.Payouts = append(winners.Payouts, uint64(i))
+	}
+	if err := SignWinners(private, "2026-42", 3, winners); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Encode(winners); errors.Cause(err) != ErrFrameTooLarge {
+		t.Fatalf("%d winners fit in a single frame: %v", n, err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteMessage(&buf, winners); err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+	stream := buf.Bytes()
+	frames := 0
+	for reader := bytes.NewReader(stream); reader.Len() > 0; frames++ {
+		if _, err := readFrame(reader); err != nil {
+			t.Fatalf("frame %d: %v", frames, err)
+		}
+	}
+	if want := (n + winnersPerFrame - 1) / winnersPerFrame; frames != want {
+		t.Errorf("sent in %d frames, want %d", frames, want)
+	}
+
+	msg, err := ReadMessage(&buf)
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if !reflect.DeepEqual(msg, winners) {
+		t.Error("joined winners differ from the ones sent")
+	}
+	if err := VerifyWinners(public, "2026-42", 3, msg.(*Winners)); err != nil {
+		t.Errorf("signature of the joined winners: %v", err)
+	}
+
+	// A frame of another message in between is not taken as winners
+	first := bytes.NewReader(stream)
+	readFrame(first)
+	rest, _ := io.ReadAll(first)
+	ack, _ := Encode(&Ack{Status: StatusOK})
+	broken := append(append(append([]byte{}, stream[:len(stream)-len(rest)]...), ack...), rest...)
+	if _, err := ReadMessage(bytes.NewReader(broken)); err == nil {
+		t.Error("read split winners with an ack in between")
+	}
+}
+
+func TestResumeRoundTrip(t *testing.T) {
+	for _, resume := range []*Resume{{}, {Sequence: 70000, Finished: true}} {
+		frame, err := Encode(resume)
+		if err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+		msg, err := Decode(frame)
+		if err != nil {
+			t.Fatalf("Decode: %v", err)
+		}
+		if !reflect.DeepEqual(msg, resume) {
+			t.Errorf("decoded %+v, want %+v", msg, resume)
+		}
+	}
+}
+
+func TestRequestsCarryTheContest(t *testing.T) {
+	requests := []Message{
+		&BetBatch{Agency: 3, Contest: "2026-42", Sequence: 1, Bets: []Bet{{FirstName: "a", LastName: "b", Birthdate: "2001-08-29"}}},
+		&EndOfBets{Agency: 3, Contest: "2026-42"},
+		&WinnersQuery{Agency: 3, Contest: "2026-42"},
+		&AwaitWinners{Agency: 3, Contest: "2026-42"},
+		&CommitmentQuery{Agency: 3, Contest: "2026-42"},
+		&ResumeQuery{Agency: 3},
+		&CancelBet{Agency: 3, Contest: "2026-42", BetID: 12},
+		&CancelBet{Agency: 3, Contest: "2026-42", Document: 30904465, Numbers: []uint16{7574, 1033}},
+		&Ack{Status: StatusNotFound, BetID: 12},
+		&BetStatusQuery{Agency: 3, Contest: "2026-42", Document: 30904465},
+		&BetStatuses{Bets: []BetState{}},
+		&BetStatuses{More: true, Bets: []BetState{
+			{ID: 1, Status: BetCancelled, Bet: Bet{FirstName: "a", LastName: "b", Document: 30904465, Birthdate: "2001-08-29", Number: 7574}},
+			{ID: 4, Status: BetWon, Tier: 2, Payout: 15050, Bet: Bet{FirstName: "a", LastName: "b", Document: 30904465, Birthdate: "2001-08-29", Type: BetCombined, Numbers: []uint16{7574, 1033}, Stake: 100}},
+		}},
+	}
+	for _, request := range requests {
+		frame, err := Encode(request)
+		if err != nil {
+			t.Fatalf("Encode %v: %v", request.Type(), err)
+		}
+		msg, err := Decode(frame)
+		if err != nil {
+			t.Fatalf("Decode %v: %v", request.Type(), err)
+		}
+		if !reflect.DeepEqual(msg, request) {
+			t.Errorf("decoded %+v, want %+v", msg, request)
+		}
+	}
+}
+
+func TestAmountsAreWrittenInCents(t *testing.T) {
+	for s, want := range map[string]uint32{"0": 0, "150": 15000, "150.5": 15050, "150.05": 15005, "0.99": 99} {
+		got, err := ParseAmount(s)
+		if err != nil || got != want {
+			t.Errorf("ParseAmount(%q) = %v, %v, want %v", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "-1", "1.", ".5", "1.234", "1,50", "42949673"} {
+		if _, err := ParseAmount(s); err == nil {
+			t.Errorf("ParseAmount(%q) did not fail", s)
+		}
+	}
+	if got := FormatAmount(15005); got != "150.05" {
+		t.Errorf("FormatAmount(15005) = %q", got)
+	}
+	if got := FormatAmount(7); got != "0.07" {
+		t.Errorf("FormatAmount(7) = %q", got)
+	}
+}
+
+func TestFormatNumbers(t *testing.T) {
+	if got := FormatNumbers(Bet{Number: 42}); got != "42" {
+		t.Errorf("single bet: %q", got)
+	}
+	if got := FormatNumbers(Bet{Type: BetCombined, Numbers: []uint16{7574, 1033}}); got != "7574/1033" {
+		t.Errorf("combined bet: %q", got)
+	}
+}
@@ -0,0 +1,80 @@
+package protocol
+
+import (
+	"crypto/ed25519"
+
+	"github.com/pkg/errors"
+)
+
+// winnersDomain Prefix of the signed winners payload, so the signature cannot
+// be reused for anything else
+const winnersDomain = "tp0/winners/v5"
+
+// ErrUnsigned is returned when winners that must be signed are not
+var ErrUnsigned = errors.New("winners are not signed")
+
+// ErrBadSignature is returned when the signature of the winners does not
+// match their content
+var ErrBadSignature = errors.New("bad signature")
+
+// signedWinners Returns the payload covered by the signature of the winners
+// of an agency: domain, contest id, agency id, winning number, time and seed
+// of the draw, prize pool and documents with their prize tier and payout,
+// encoded like the rest of the protocol
+func signedWinners(contest string, agency uint8, m *Winners) ([]byte, error) {
+	if len(contest) > MaxStringLength {
+		return nil, errors.Errorf("contest id is %d bytes long, max is %d", len(contest), MaxStringLength)
+	}
+	if len(m.Seed) > MaxStringLength {
+		return nil, errors.Errorf("seed is %d bytes long, max is %d", len(m.Seed), MaxStringLength)
+	}
+	if len(m.Tiers) != 0 && len(m.Tiers) != len(m.Documents) {
+		return nil, errors.Errorf("%d tiers for %d winners", len(m.Tiers), len(m.Documents))
+	}
+	if len(m.Payouts) != 0 && len(m.Payouts) != len(m.Documents) {
+		return nil, errors.Errorf("%d payouts for %d winners", len(m.Payouts), len(m.Documents))
+	}
+	w := &writer{buf: make([]byte, 0, 83+len(m.Seed)+17*len(m.Documents))}
+	w.putString(winnersDomain)
+	w.putString(contest)
+	w.putUint8(agency)
+	w.putUint16(m.WinningNumber)
+	w.putUint64(uint64(m.DrawnAt))
+	w.putBytes(m.Seed)
+	w.putUint64(m.Pool)
+	// Winners split across several frames can be more than fit in 2 bytes
+	w.putUint32(uint32(len(m.Documents)))
+	for i, d := range m.Documents {
+		w.putUint64(d)
+		w.putUint8(m.Tier(i))
+		w.putUint64(m.Payout(i))
+	}
+	return w.buf, nil
+}
+
+// SignWinners Signs the winners of an agency with the key of the central and
+// stores the signature in m.Signature
+func SignWinners(key ed25519.PrivateKey, contest string, agency uint8, m *Winners) error {
+	payload, err := signedWinners(contest, agency, m)
+	if err != nil {
+		return err
+	}
+	m.Signature = ed25519.Sign(key, payload)
+	return nil
+}
+
+// VerifyWinners Checks that the winners were signed by the central holding
+// the private half of key for the given contest and agency
+func VerifyWinners(key ed25519.PublicKey, contest string, agency uint8, m *Winners) error {
+	if len(m.Signature) == 0 {
+		return ErrUnsigned
+	}
+	payload, err := signedWinners(contest, agency, m)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(key, payload, m.Signature) {
+		return ErrBadSignature
+	}
+	return nil
+}
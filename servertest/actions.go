@@ -0,0 +1,133 @@
+package servertest
+
+import (
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// errDropped is returned by actions that close the connection on purpose
+var errDropped = errors.New("connection dropped by script")
+
+// Action Answers a received message. Returning an error closes the connection
+type Action func(conn net.Conn, received protocol.Message) error
+
+// Reply Answers with the given message
+func Reply(msg protocol.Message) Action {
+	return func(conn net.Conn, _ protocol.Message) error {
+		return protocol.WriteMessage(conn, msg)
+	}
+}
+
+// Ack Answers with an Ack carrying the given status
+func Ack(status protocol.Status) Action {
+	return Reply(&protocol.Ack{Status: status})
+}
+
+// Fail Answers with a failed Ack, as the server does when a batch has an
+// invalid bet
+func Fail() Action {
+	return Ack(protocol.StatusFail)
+}
+
+// DrawNotReady Answers a winners query before the draw happened
+func DrawNotReady() Action {
+	return Ack(protocol.StatusDrawNotReady)
+}
+
+// DefaultContest Id of the contest the fake central answers for queries that
+// name none, like a real central does with its default contest
+const DefaultContest = "1"
+
+// Winners Answers with the given winner documents, of the contest of the
+// received query or DefaultContest if it names none
+func Winners(documents ...uint64) Action {
+	return func(conn net.Conn, received protocol.Message) error {
+		winners := &protocol.Winners{Contest: DefaultContest, Documents: documents}
+		contest := ""
+		switch m := received.(type) {
+		case *protocol.WinnersQuery:
+			contest = m.Contest
+		case *protocol.AwaitWinners:
+			contest = m.Contest
+		}
+		if contest != "" {
+			winners.Contest = contest
+		}
+		return protocol.WriteMessage(conn, winners)
+	}
+}
+
+// Delay Waits d before running the action
+func Delay(d time.Duration, action Action) Action {
+	return func(conn net.Conn, received protocol.Message) error {
+		time.Sleep(d)
+		return action(conn, received)
+	}
+}
+
+// Fragmented Sends the message in chunks of at most size bytes, waiting pause
+// between them, to exercise short-reads on the client
+func Fragmented(msg protocol.Message, size int, pause time.Duration) Action {
+	return func(conn net.Conn, _ protocol.Message) error {
+		frame, err := protocol.Encode(msg)
+		if err != nil {
+			return err
+		}
+		for len(frame) > 0 {
+			n := size
+			if n > len(frame) {
+				n = len(frame)
+			}
+			if _, err := conn.Write(frame[:n]); err != nil {
+				return err
+			}
+			frame = frame[n:]
+			if len(frame) > 0 {
+				time.Sleep(pause)
+			}
+		}
+		return nil
+	}
+}
+
+// Truncated Sends only the first n bytes of the message frame and drops the
+// connection
+func Truncated(msg protocol.Message, n int) Action {
+	return func(conn net.Conn, _ protocol.Message) error {
+		frame, err := protocol.Encode(msg)
+		if err != nil {
+			return err
+		}
+		if n > len(frame) {
+			n = len(frame)
+		}
+		if _, err := conn.Write(frame[:n]); err != nil {
+			return err
+		}
+		return errDropped
+	}
+}
+
+// Drop Closes the connection without answering
+func Drop() Action {
+	return func(net.Conn, protocol.Message) error {
+		return errDropped
+	}
+}
+
+// Sequence Runs several actions one after the other on the same message, for
+// example to answer twice
+func Sequence(actions ...Action) Action {
+	return func(conn net.Conn, received protocol.Message) error {
+		for _, action := range actions {
+			if err := action(conn, received); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
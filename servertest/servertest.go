@@ -0,0 +1,191 @@
+// Package servertest Scriptable fake lottery central for client tests.
+//
+// A Server decodes every frame it receives, records it and answers with the
+// next Action scripted for that message type, falling back to a default
+// action once the script runs out. Actions can reply, delay, fragment or
+// truncate the reply and drop the connection, which allows testing the client
+// retry, batching and winners logic deterministically.
+package servertest
+
+import (
+	"net"
+	"sync"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/protocol"
+)
+
+// Frame A message received by the server
+type Frame struct {
+	// Conn Index of the connection the message arrived on, starting at 0
+	Conn    int
+	Message protocol.Message
+}
+
+// Server Fake lottery central
+type Server struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	frames   []Frame
+	conns    []net.Conn
+	scripts  map[protocol.MessageType][]Action
+	defaults map[protocol.MessageType]Action
+	closed   bool
+	wg       sync.WaitGroup
+}
+
+// newServer Initializes a server with the default actions: bets and end of
+// bets are acknowledged, agencies resume from the first batch, winners
+// queries (polled or awaited) get an empty list of winners and documents have
+// no bets
+func newServer() *Server {
+	return &Server{
+		scripts: make(map[protocol.MessageType][]Action),
+		defaults: map[protocol.MessageType]Action{
+			protocol.MsgBetBatch:       Ack(protocol.StatusOK),
+			protocol.MsgEndOfBets:      Ack(protocol.StatusOK),
+			protocol.MsgWinnersQuery:   Winners(),
+			protocol.MsgAwaitWinners:   Winners(),
+			protocol.MsgResumeQuery:    Reply(&protocol.Resume{}),
+			protocol.MsgBetStatusQuery: Reply(&protocol.BetStatuses{}),
+		},
+	}
+}
+
+// NewServer Starts a server listening on a random port of the loopback
+// interface. It must be stopped with Close
+func NewServer() (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := newServer()
+	s.listener = listener
+	s.wg.Add(1)
+	go s.acceptLoop()
+	return s, nil
+}
+
+// NewPipe Starts a server on one end of a net.Pipe and returns the other end
+// to be used by the test. The server must be stopped with Close
+func NewPipe() (*Server, net.Conn) {
+	s := newServer()
+	client, server := net.Pipe()
+	s.serve(server)
+	return s, client
+}
+
+// Addr Address the server is listening on
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close Stops accepting connections, closes the open ones and waits for
+// their handlers to finish
+func (s *Server) Close() {
+	s.mu.Lock()
+	s.closed = true
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	s.wg.Wait()
+}
+
+// On Sets the action used for a message type once its script runs out
+func (s *Server) On(t protocol.MessageType, action Action) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.defaults[t] = action
+}
+
+// Script Queues actions for the next messages of the given type. Each action
+// is used once, in order, regardless of the connection the message arrives on
+func (s *Server) Script(t protocol.MessageType, actions ...Action) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scripts[t] = append(s.scripts[t], actions...)
+}
+
+// Frames Returns every message received so far, in arrival order
+func (s *Server) Frames() []Frame {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Frame(nil), s.frames...)
+}
+
+// Messages Returns the received messages of the given type
+func (s *Server) Messages(t protocol.MessageType) []protocol.Message {
+	var messages []protocol.Message
+	for _, f := range s.Frames() {
+		if f.Message.Type() == t {
+			messages = append(messages, f.Message)
+		}
+	}
+	return messages
+}
+
+// Connections Amount of connections accepted so far
+func (s *Server) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+func (s *Server) acceptLoop() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.serve(conn)
+	}
+}
+
+// serve Registers the connection and handles it in a new goroutine
+func (s *Server) serve(conn net.Conn) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	id := len(s.conns)
+	s.conns = append(s.conns, conn)
+	s.wg.Add(1)
+	s.mu.Unlock()
+
+	go func() {
+		defer s.wg.Done()
+		defer conn.Close()
+		for {
+			msg, err := protocol.ReadMessage(conn)
+			if err != nil {
+				return
+			}
+			if err := s.record(id, msg)(conn, msg); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+// record Stores the message and returns the action that answers it
+func (s *Server) record(conn int, msg protocol.Message) Action {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.frames = append(s.frames, Frame{Conn: conn, Message: msg})
+
+	if script := s.scripts[msg.Type()]; len(script) > 0 {
+		s.scripts[msg.Type()] = script[1:]
+		return script[0]
+	}
+	if action, ok := s.defaults[msg.Type()]; ok {
+		return action
+	}
+	return Drop()
+}